@@ -56,7 +56,7 @@ var configureCmd = &cobra.Command{
 		// Configure VCS type
 		vcsPrompt := promptui.Select{
 			Label: "Choose VCS system",
-			Items: []string{"Github.com", "Gitlab.com", "Azure Devops (cloud)", "Bitbucket.com"},
+			Items: []string{"Github.com", "Github Enterprise Server", "Gitlab.com", "Azure Devops (cloud)", "Azure DevOps Server", "Bitbucket.com", "Bitbucket Server / Data Center", "Gitea / Forgejo", "Local directory"},
 		}
 
 		_, vcsResult, err := vcsPrompt.Run()
@@ -71,21 +71,44 @@ var configureCmd = &cobra.Command{
 
 		switch vcsResult {
 		case "Github.com":
-			ghToken, err := utils.PromptForString("Enter Github token", 40)
-			if err != nil {
+			if err := configureGithubAuth(&ct, 40); err != nil {
 				fmt.Printf(err.Error())
 				return
 			}
-			ghOrg, err := utils.PromptForString("Enter Github Organization name", -1)
+			ghOrg, err := utils.PromptForString("Enter Github Organization name(s), comma separated", -1)
 			if err != nil {
 				fmt.Printf(err.Error())
 				return
 			}
 			config["SYRINGE_VCS"] = "github"
-			config["SYRINGE_VCS_TOKEN_GITHUB"] = ghToken
+			config["SYRINGE_VCS_TOKEN_GITHUB"] = ct.VcsToken
 			config["SYRINGE_GITHUB_ORG"] = ghOrg
 			ct.VcsType = "github"
-			ct.VcsToken = ghToken
+			ct.Associated["githubOrg"] = ghOrg
+
+		case "Github Enterprise Server":
+			if err := configureGithubAuth(&ct, -1); err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			ghUrl, err := utils.PromptForString("Enter Github Enterprise Server API URL (https://ghe.example.com/api/v3/)", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			ghUploadUrl, err := utils.PromptForString("Enter Github Enterprise Server upload URL (blank to derive from the API URL)", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			ghOrg, err := utils.PromptForString("Enter Github Organization name(s), comma separated", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			ct.VcsType = "github"
+			ct.Associated["githubUrl"] = ghUrl
+			ct.Associated["githubUploadUrl"] = ghUploadUrl
 			ct.Associated["githubOrg"] = ghOrg
 
 		case "Gitlab.com":
@@ -100,9 +123,15 @@ var configureCmd = &cobra.Command{
 				fmt.Printf(err.Error())
 				return
 			}
+			gitlabGroups, err := utils.PromptForString("Enter Gitlab group path(s) or ID(s), comma separated (blank for all visible projects)", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
 			ct.VcsType = "gitlab"
 			ct.VcsToken = gitlabToken
 			ct.Associated["gitlabUrl"] = gitlabUrl
+			ct.Associated["gitlabGroups"] = gitlabGroups
 
 		case "Azure Devops (cloud)":
 			azureToken, err := utils.PromptForString("Enter Azure DevOps token", 52)
@@ -115,30 +144,100 @@ var configureCmd = &cobra.Command{
 				fmt.Printf(err.Error())
 				return
 			}
+			azureProjects, err := utils.PromptForString("Enter Azure DevOps team project(s), comma separated (blank for all)", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
 			ct.VcsType = "azure"
 			ct.VcsToken = azureToken
 			ct.Associated["azureOrg"] = azureOrg
+			ct.Associated["azureProjects"] = azureProjects
 
-		case "Bitbucket.com":
-			bbOwner, err := utils.PromptForString("Enter Bitbucket Cloud Username", -1)
+		case "Azure DevOps Server":
+			azureToken, err := utils.PromptForString("Enter Azure DevOps Server token", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			azureUrl, err := utils.PromptForString("Enter Azure DevOps Server collection url (e.g. https://ado.example.com/tfs/DefaultCollection)", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			azureProjects, err := utils.PromptForString("Enter Azure DevOps team project(s), comma separated (blank for all)", -1)
 			if err != nil {
 				fmt.Printf(err.Error())
 				return
 			}
-			bbClientId, err := utils.PromptForString("Enter Bitbucket Cloud Oauth Client Credential ClientID", -1)
+			ct.VcsType = "azure"
+			ct.VcsToken = azureToken
+			ct.Associated["azureUrl"] = azureUrl
+			ct.Associated["azureProjects"] = azureProjects
+
+		case "Bitbucket.com":
+			bbOwner, err := utils.PromptForString("Enter Bitbucket Cloud workspace", -1)
 			if err != nil {
 				fmt.Printf(err.Error())
 				return
 			}
-			bbClientSecret, err := utils.PromptForString("Enter Bitbucket Cloud Oauth Client Credential ClientSecret", -1)
+			bbProjectKeys, err := utils.PromptForString("Enter Bitbucket project key(s), comma separated (blank for all)", -1)
 			if err != nil {
 				fmt.Printf(err.Error())
 				return
 			}
+			if err := configureBitbucketCloudAuth(&ct); err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
 			ct.VcsType = "bitbucket_cloud"
 			ct.Associated["bbOwner"] = bbOwner
-			ct.Associated["bbClientId"] = bbClientId
-			ct.Associated["bbClientSecret"] = bbClientSecret
+			ct.Associated["bbProjectKeys"] = bbProjectKeys
+
+		case "Bitbucket Server / Data Center":
+			bbServerToken, err := utils.PromptForString("Enter Bitbucket Server personal access token", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			bbServerUrl, err := utils.PromptForString("Enter Bitbucket Server URL (https://bitbucket.example.com)", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			ct.VcsType = "bitbucket_server"
+			ct.VcsToken = bbServerToken
+			ct.Associated["bbServerUrl"] = bbServerUrl
+
+		case "Gitea / Forgejo":
+			giteaToken, err := utils.PromptForString("Enter Gitea access token", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			giteaUrl, err := utils.PromptForString("Enter Gitea URL (https://gitea.example.com)", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			giteaOrg, err := utils.PromptForString("Enter Gitea Organization name (blank for all repos visible to the token)", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			ct.VcsType = "gitea"
+			ct.VcsToken = giteaToken
+			ct.Associated["giteaUrl"] = giteaUrl
+			ct.Associated["giteaOrg"] = giteaOrg
+
+		case "Local directory":
+			localPath, err := utils.PromptForString("Enter path to the directory of checked-out repositories", -1)
+			if err != nil {
+				fmt.Printf(err.Error())
+				return
+			}
+			ct.VcsType = "local"
+			ct.Associated["localPath"] = localPath
 
 		default:
 			fmt.Printf("vcsType switch default case. Shouldn't happen\n")
@@ -198,3 +297,85 @@ var configureCmd = &cobra.Command{
 		fmt.Printf("Finished configuring Syringe. Wrote configuration to 'syringe_config.yaml`\n")
 	},
 }
+
+// configureGithubAuth prompts for either a personal access token or GitHub App installation credentials
+func configureGithubAuth(ct *structs.ConfigThing, tokenLen int) error {
+	authPrompt := promptui.Select{
+		Label: "Choose Github authentication",
+		Items: []string{"Personal access token", "Github App installation"},
+	}
+	_, authResult, err := authPrompt.Run()
+	if err != nil {
+		return fmt.Errorf("authPrompt failed: %v\n", err)
+	}
+
+	if authResult == "Personal access token" {
+		ghToken, err := utils.PromptForString("Enter Github token", tokenLen)
+		if err != nil {
+			return err
+		}
+		ct.VcsToken = ghToken
+		return nil
+	}
+
+	appId, err := utils.PromptForString("Enter Github App ID", -1)
+	if err != nil {
+		return err
+	}
+	installationId, err := utils.PromptForString("Enter Github App installation ID", -1)
+	if err != nil {
+		return err
+	}
+	privateKeyPath, err := utils.PromptForString("Enter path to the Github App private key (.pem)", -1)
+	if err != nil {
+		return err
+	}
+	ct.Associated["githubAppId"] = appId
+	ct.Associated["githubInstallationId"] = installationId
+	ct.Associated["githubPrivateKeyPath"] = privateKeyPath
+	return nil
+}
+
+// configureBitbucketCloudAuth prompts for an OAuth consumer, an access token or an app password
+func configureBitbucketCloudAuth(ct *structs.ConfigThing) error {
+	authPrompt := promptui.Select{
+		Label: "Choose Bitbucket Cloud authentication",
+		Items: []string{"OAuth consumer (client credentials)", "Access token (repository, project or workspace)", "App password"},
+	}
+	_, authResult, err := authPrompt.Run()
+	if err != nil {
+		return fmt.Errorf("authPrompt failed: %v\n", err)
+	}
+
+	switch authResult {
+	case "Access token (repository, project or workspace)":
+		accessToken, err := utils.PromptForString("Enter Bitbucket Cloud access token", -1)
+		if err != nil {
+			return err
+		}
+		ct.Associated["bbAccessToken"] = accessToken
+	case "App password":
+		username, err := utils.PromptForString("Enter Bitbucket Cloud username", -1)
+		if err != nil {
+			return err
+		}
+		appPassword, err := utils.PromptForString("Enter Bitbucket Cloud app password", -1)
+		if err != nil {
+			return err
+		}
+		ct.Associated["bbUsername"] = username
+		ct.Associated["bbAppPassword"] = appPassword
+	default:
+		clientId, err := utils.PromptForString("Enter Bitbucket Cloud Oauth Client Credential ClientID", -1)
+		if err != nil {
+			return err
+		}
+		clientSecret, err := utils.PromptForString("Enter Bitbucket Cloud Oauth Client Credential ClientSecret", -1)
+		if err != nil {
+			return err
+		}
+		ct.Associated["bbClientId"] = clientId
+		ct.Associated["bbClientSecret"] = clientSecret
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	Syringe2 "github.com/peterjmorgan/Syringe/internal"
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+func init() {
+	injectCmd.Flags().Bool("dry-run", false, "Print the patch for each repo instead of opening pull/merge requests")
+	injectCmd.Flags().String("branch", Syringe2.DefaultInjectBranch, "Branch to commit the CI change to")
+	injectCmd.Flags().StringSlice("projects", nil, "Only these projects, by name")
+	rootCmd.AddCommand(injectCmd)
+}
+
+var injectCmd = &cobra.Command{
+	Use:   "inject",
+	Short: "Open pull/merge requests adding Phylum to CI for repos that don't run it",
+	Run: func(cmd *cobra.Command, args []string) {
+		var mineOnly bool = false
+		var ratelimit int = 0
+		var discovery string = ""
+		var err error
+
+		if cmd.Flags().Lookup("debug").Changed {
+			log.SetLevel(log.DebugLevel)
+		}
+
+		if cmd.Flags().Lookup("mine-only").Changed {
+			mineOnly = true
+		}
+		if cmd.Flags().Lookup("ratelimit").Changed {
+			ratelimit, err = cmd.Flags().GetInt("ratelimit")
+			if err != nil {
+				log.Errorf("Failed to read int value from ratelimit")
+			}
+		}
+		if cmd.Flags().Lookup("discovery").Changed {
+			discovery, err = cmd.Flags().GetString("discovery")
+			if err != nil {
+				log.Errorf("Failed to read string value from discovery")
+			}
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Errorf("Failed to read bool value from dry-run")
+		}
+		branch, err := cmd.Flags().GetString("branch")
+		if err != nil {
+			log.Errorf("Failed to read string value from branch")
+		}
+		onlyProjects, err := cmd.Flags().GetStringSlice("projects")
+		if err != nil {
+			log.Errorf("Failed to read string values from projects")
+		}
+
+		opts := structs.SyringeOptions{
+			MineOnly:  mineOnly,
+			RateLimit: ratelimit,
+			Discovery: discovery,
+			Filter:    readRepoFilter(cmd),
+			Http:      readHttpOptions(cmd),
+			Refs:      readRefOptions(cmd),
+		}
+
+		configData, err := utils.ReadConfigFile(&structs.TestConfigData{})
+		if err != nil {
+			log.Fatalf("Failed to read config file")
+			return
+		}
+
+		s, err := Syringe2.NewSyringe(configData, &opts)
+		if err != nil {
+			log.Fatalf("Failed to create NewSyringe(): %v\n", err)
+			return
+		}
+
+		if err = s.ListProjects(); err != nil {
+			log.Fatalf("Failed to ListProjects(): %v\n", err)
+			return
+		}
+		if err = s.GetAllLockfiles(); err != nil {
+			log.Errorf("Failed to GetAllLockfiles: %v\n", err)
+		}
+
+		t := table.NewWriter()
+		t.SetStyle(table.StyleLight)
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Source", "Project Name", "Result"})
+		for _, p := range *s.Projects {
+			if len(onlyProjects) > 0 && !slices.Contains(onlyProjects, p.Name) {
+				continue
+			}
+			if p.PhylumInCi {
+				t.AppendRow(table.Row{p.Source, p.Name, "skipped: CI already runs Phylum"})
+				continue
+			}
+			if len(p.Lockfiles) == 0 {
+				t.AppendRow(table.Row{p.Source, p.Name, "skipped: no lockfiles"})
+				continue
+			}
+
+			change, err := s.PlanInjection(p, branch)
+			if err != nil {
+				t.AppendRow(table.Row{p.Source, p.Name, fmt.Sprintf("skipped: %v", err)})
+				continue
+			}
+			if dryRun {
+				fmt.Printf("# %v (%v)\n%v\n", p.Name, p.Source, Syringe2.FormatChangePatch(change))
+				t.AppendRow(table.Row{p.Source, p.Name, "dry run"})
+				continue
+			}
+
+			changeUrl, err := s.Inject(p, change)
+			if err != nil {
+				log.Errorf("Failed to inject Phylum CI into %v: %v\n", p.Name, err)
+				t.AppendRow(table.Row{p.Source, p.Name, fmt.Sprintf("failed: %v", err)})
+				continue
+			}
+			t.AppendRow(table.Row{p.Source, p.Name, changeUrl})
+		}
+		t.Render()
+	},
+}
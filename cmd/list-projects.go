@@ -23,7 +23,7 @@ var listProjectsCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		var mineOnly bool = false
 		var ratelimit int = 0
-		var proxyUrl string = ""
+		var discovery string = ""
 		var err error
 
 		if cmd.Flags().Lookup("debug").Changed {
@@ -39,17 +39,20 @@ var listProjectsCmd = &cobra.Command{
 				log.Errorf("Failed to read int value from ratelimit")
 			}
 		}
-		if cmd.Flags().Lookup("proxyUrl").Changed {
-			proxyUrl, err = cmd.Flags().GetString("proxyUrl")
+		if cmd.Flags().Lookup("discovery").Changed {
+			discovery, err = cmd.Flags().GetString("discovery")
 			if err != nil {
-				log.Errorf("Failed to read string value from proxyUrl")
+				log.Errorf("Failed to read string value from discovery")
 			}
 		}
 
 		opts := structs.SyringeOptions{
 			MineOnly:  mineOnly,
 			RateLimit: ratelimit,
-			ProxyUrl:  proxyUrl,
+			Discovery: discovery,
+			Filter:    readRepoFilter(cmd),
+			Http:      readHttpOptions(cmd),
+			Refs:      readRefOptions(cmd),
 		}
 
 		configData, err := utils.ReadConfigFile(&structs.TestConfigData{})
@@ -106,14 +109,34 @@ var listProjectsCmd = &cobra.Command{
 		t.SetStyle(table.StyleLight)
 		t.SetOutputMirror(os.Stdout)
 		// t.AppendHeader(table.Row{"Project Name", "ID", "Main Branch", "Protected", "Lockfile Path"}, rowConfigAutoMerge)
-		t.AppendHeader(table.Row{"Project Name", "ID", "Main Branch", "Lockfile Path"})
+		t.AppendHeader(table.Row{"Source", "Project Name", "ID", "Main Branch", "Phylum CI", "Ref", "Lockfile Path"})
 		for _, p := range *s.Projects {
+			phylumInCi := "no"
+			if p.PhylumInCi {
+				phylumInCi = "yes"
+			}
 			for _, lockfile := range p.Lockfiles {
-				t.AppendRow(table.Row{p.Name, p.Id, p.Branch, lockfile.Path})
+				ref := p.Branch
+				if lockfile.Ref != "" {
+					ref, _ = utils.SplitRef(lockfile.Ref)
+				}
+				t.AppendRow(table.Row{p.Source, p.Name, p.Id, p.Branch, phylumInCi, ref, lockfile.Path})
 			}
 			// t.AppendRow(table.Row{p.Name, p.Id, p.Branch})
 		}
 		t.Style().Options.SeparateRows = true
 		t.Render()
+
+		if len(s.Skipped) > 0 {
+			st := table.NewWriter()
+			st.SetStyle(table.StyleLight)
+			st.SetOutputMirror(os.Stdout)
+			st.AppendHeader(table.Row{"Source", "Skipped Project", "Reason"})
+			for _, skipped := range s.Skipped {
+				st.AppendRow(table.Row{skipped.Source, skipped.Name, skipped.Reason})
+			}
+			st.Render()
+		}
+		renderRateLimitStats(s)
 	},
 }
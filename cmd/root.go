@@ -6,7 +6,12 @@ package cmd
 
 import (
 	"os"
+	"time"
 
+	"github.com/jedib0t/go-pretty/v6/table"
+	Syringe2 "github.com/peterjmorgan/Syringe/internal"
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -40,7 +45,126 @@ func init() {
 	// when this action is called directly.
 	rootCmd.PersistentFlags().BoolP("debug", "d", false, "Debug logging")
 	// TODO: consider removing these. Mostly for testing for a specific use case. Perhaps moving to the environment is better
-	rootCmd.PersistentFlags().BoolP("mine-only", "m", false, "(Gitlab) Only projects owned by the user")
+	rootCmd.PersistentFlags().BoolP("mine-only", "m", false, "(Gitlab) Only projects owned by the user. (Github) Also scan repos owned by the user")
 	rootCmd.PersistentFlags().Int32P("ratelimit", "r", 100, "Rate Limit (X/reqs/sec) ")
-	rootCmd.PersistentFlags().StringP("proxyUrl", "p", "", "proxy (https://url:port)")
+	rootCmd.PersistentFlags().StringP("proxyUrl", "p", "", "proxy (https://url:port). Defaults to HTTPS_PROXY / HTTP_PROXY")
+	rootCmd.PersistentFlags().String("ca-bundle", "", "PEM file of CA certificates to trust in addition to the system roots")
+	rootCmd.PersistentFlags().Bool("insecure", false, "Skip TLS certificate verification")
+	rootCmd.PersistentFlags().Duration("timeout", 2*time.Minute, "Timeout for each HTTP request (0 for none)")
+	rootCmd.PersistentFlags().String("user-agent", "Syringe", "User-Agent sent with every HTTP request")
+	rootCmd.PersistentFlags().String("cache-dir", "", "Cache VCS API responses in this directory and revalidate them with ETags, so repeat scans use less rate limit")
+	rootCmd.PersistentFlags().String("discovery", "api", "Lockfile discovery: 'api' (VCS tree APIs), 'clone' (shallow git clone), 'archive' (one tarball/zip download per repo) or 'search' (org-wide code search)")
+	rootCmd.PersistentFlags().StringSlice("branches", nil, "Also scan branches matching these globs, e.g. release/*")
+	rootCmd.PersistentFlags().StringSlice("tags", nil, "Also scan tags matching these globs, e.g. v*")
+	rootCmd.PersistentFlags().Bool("ref-labels", false, "Analyze other branches and tags into the default branch's Phylum project with a label, instead of a project per ref")
+	rootCmd.PersistentFlags().Bool("exclude-forks", false, "(Github) Skip forked repos")
+	rootCmd.PersistentFlags().Bool("exclude-archived", false, "(Github) Skip archived repos")
+	rootCmd.PersistentFlags().Bool("exclude-templates", false, "(Github) Skip template repos")
+	rootCmd.PersistentFlags().String("visibility", "", "(Github) Only repos with this visibility: public, private or internal")
+	rootCmd.PersistentFlags().StringSlice("include-topics", nil, "(Github) Only repos with at least one of these topics")
+	rootCmd.PersistentFlags().StringSlice("exclude-topics", nil, "(Github) Skip repos with any of these topics")
+}
+
+// readRepoFilter collects the repo filter flags; unset flags fall back to the config file
+func readRepoFilter(cmd *cobra.Command) structs.RepoFilter {
+	var filter structs.RepoFilter
+	var err error
+
+	filter.ExcludeForks, err = cmd.Flags().GetBool("exclude-forks")
+	if err != nil {
+		log.Errorf("Failed to read bool value from exclude-forks")
+	}
+	filter.ExcludeArchived, err = cmd.Flags().GetBool("exclude-archived")
+	if err != nil {
+		log.Errorf("Failed to read bool value from exclude-archived")
+	}
+	filter.ExcludeTemplates, err = cmd.Flags().GetBool("exclude-templates")
+	if err != nil {
+		log.Errorf("Failed to read bool value from exclude-templates")
+	}
+	filter.Visibility, err = cmd.Flags().GetString("visibility")
+	if err != nil {
+		log.Errorf("Failed to read string value from visibility")
+	}
+	filter.IncludeTopics, err = cmd.Flags().GetStringSlice("include-topics")
+	if err != nil {
+		log.Errorf("Failed to read string values from include-topics")
+	}
+	filter.ExcludeTopics, err = cmd.Flags().GetStringSlice("exclude-topics")
+	if err != nil {
+		log.Errorf("Failed to read string values from exclude-topics")
+	}
+
+	return filter
+}
+
+// readHttpOptions collects the flags for the HTTP client shared by every VCS client and Phylum
+func readHttpOptions(cmd *cobra.Command) structs.HttpOptions {
+	var httpOptions structs.HttpOptions
+	var err error
+
+	httpOptions.ProxyUrl, err = cmd.Flags().GetString("proxyUrl")
+	if err != nil {
+		log.Errorf("Failed to read string value from proxyUrl")
+	}
+	httpOptions.CaBundle, err = cmd.Flags().GetString("ca-bundle")
+	if err != nil {
+		log.Errorf("Failed to read string value from ca-bundle")
+	}
+	httpOptions.Insecure, err = cmd.Flags().GetBool("insecure")
+	if err != nil {
+		log.Errorf("Failed to read bool value from insecure")
+	}
+	httpOptions.Timeout, err = cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		log.Errorf("Failed to read duration value from timeout")
+	}
+	httpOptions.UserAgent, err = cmd.Flags().GetString("user-agent")
+	if err != nil {
+		log.Errorf("Failed to read string value from user-agent")
+	}
+	httpOptions.CacheDir, err = cmd.Flags().GetString("cache-dir")
+	if err != nil {
+		log.Errorf("Failed to read string value from cache-dir")
+	}
+
+	return httpOptions
+}
+
+// readRefOptions collects the flags picking branches and tags to scan besides the default branch
+func readRefOptions(cmd *cobra.Command) structs.RefOptions {
+	var refOptions structs.RefOptions
+	var err error
+
+	refOptions.Branches, err = cmd.Flags().GetStringSlice("branches")
+	if err != nil {
+		log.Errorf("Failed to read string values from branches")
+	}
+	refOptions.Tags, err = cmd.Flags().GetStringSlice("tags")
+	if err != nil {
+		log.Errorf("Failed to read string values from tags")
+	}
+	refOptions.Label, err = cmd.Flags().GetBool("ref-labels")
+	if err != nil {
+		log.Errorf("Failed to read bool value from ref-labels")
+	}
+
+	return refOptions
+}
+
+// renderRateLimitStats prints how often each source was held back or throttled during the run,
+// if any was
+func renderRateLimitStats(s *Syringe2.Syringe) {
+	stats := s.RateLimitStats()
+	if len(stats) == 0 {
+		return
+	}
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Source", "Requests", "Throttled", "Retried", "Held Back", "Waited"})
+	for _, stat := range stats {
+		t.AppendRow(table.Row{stat.Source, stat.Requests, stat.Throttled, stat.Retries, stat.Waits, stat.Waited.Round(time.Second)})
+	}
+	t.Render()
 }
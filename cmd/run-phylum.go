@@ -18,6 +18,8 @@ var projectIDFileName string
 
 func init() {
 	runPhylumCmd.Flags().StringVar(&projectIDFileName, "pidFilename", "", "project id filename")
+	runPhylumCmd.Flags().String("state-file", "", "File recording what was scanned, e.g. syringe_state.json, so unchanged repos and lockfiles are skipped next run. Off by default")
+	runPhylumCmd.Flags().Bool("full", false, "Rescan every repo and reanalyze every lockfile, ignoring the state file")
 	rootCmd.AddCommand(runPhylumCmd)
 }
 
@@ -27,7 +29,7 @@ var runPhylumCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		var mineOnly bool = false
 		var ratelimit int = 0
-		var proxyUrl string = ""
+		var discovery string = ""
 		var err error
 
 		if cmd.Flags().Lookup("debug").Changed {
@@ -43,17 +45,31 @@ var runPhylumCmd = &cobra.Command{
 				log.Errorf("Failed to read int value from ratelimit")
 			}
 		}
-		if cmd.Flags().Lookup("proxyUrl").Changed {
-			proxyUrl, err = cmd.Flags().GetString("proxyUrl")
+		if cmd.Flags().Lookup("discovery").Changed {
+			discovery, err = cmd.Flags().GetString("discovery")
 			if err != nil {
-				log.Errorf("Failed to read string value from proxyUrl")
+				log.Errorf("Failed to read string value from discovery")
 			}
 		}
 
+		stateFile, err := cmd.Flags().GetString("state-file")
+		if err != nil {
+			log.Errorf("Failed to read string value from state-file")
+		}
+		full, err := cmd.Flags().GetBool("full")
+		if err != nil {
+			log.Errorf("Failed to read bool value from full")
+		}
+
 		opts := structs.SyringeOptions{
 			MineOnly:  mineOnly,
 			RateLimit: ratelimit,
-			ProxyUrl:  proxyUrl,
+			Discovery: discovery,
+			Filter:    readRepoFilter(cmd),
+			Http:      readHttpOptions(cmd),
+			Refs:      readRefOptions(cmd),
+			StateFile: stateFile,
+			Full:      full,
 		}
 
 		configData, err := utils.ReadConfigFile(&structs.TestConfigData{})
@@ -136,27 +152,51 @@ var runPhylumCmd = &cobra.Command{
 		sem := semaphore.NewWeighted(50)
 		ctx := context.TODO()
 
+		// projects with a lockfile that failed to analyze, so they're rescanned next run
+		failedProjects := make(map[string]bool, 0)
+		var failedMutex sync.Mutex
+
 		// Phylum analyze loop
 		for _, project := range *s.Projects {
 			for _, lockfile := range project.Lockfiles {
+				if s.LockfileUnchanged(project, lockfile) {
+					log.Debugf("Skipping %v from %v, unchanged since the last scan\n", lockfile.Path, project.Name)
+					analyzeBar.Add(1)
+					continue
+				}
 				wgAnalyze.Add(1)
-				go func(inLockfile *structs.VcsFile) {
+				go func(inProject *structs.SyringeProject, inLockfile *structs.VcsFile) {
 					defer wgAnalyze.Done()
-					log.Debugf("Analyzing %v from %v\n", inLockfile.Path, project.Name)
-					if err = sem.Acquire(ctx, 1); err != nil {
+					log.Debugf("Analyzing %v from %v\n", inLockfile.Path, inProject.Name)
+					if err := sem.Acquire(ctx, 1); err != nil {
 						log.Errorf("Failed to acquire semaphore: %v\n", err)
 						return
 					}
 					defer sem.Release(1)
-					err = s.PhylumRunAnalyze(*inLockfile.PhylumProject, inLockfile, inLockfile.PhylumProject.Name)
+					err := s.PhylumRunAnalyze(*inLockfile.PhylumProject, inLockfile, inLockfile.PhylumProject.Name)
 					if err != nil {
 						log.Errorf("Failed to analyze %v: %v\n", inLockfile.PhylumProject.Name, err)
+						failedMutex.Lock()
+						failedProjects[inProject.Id] = true
+						failedMutex.Unlock()
+					} else {
+						s.RecordAnalyzed(inProject, inLockfile)
 					}
 					analyzeBar.Add(1)
-				}(lockfile)
+				}(project, lockfile)
 
 			}
 		}
 		wgAnalyze.Wait()
+
+		for _, project := range *s.Projects {
+			if !failedProjects[project.Id] {
+				s.RecordHead(project)
+			}
+		}
+		if err = s.SaveState(); err != nil {
+			log.Errorf("Failed to save scan state to %v: %v\n", stateFile, err)
+		}
+		renderRateLimitStats(s)
 	},
 }
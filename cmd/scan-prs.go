@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	Syringe2 "github.com/peterjmorgan/Syringe/internal"
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	scanPrsCmd.Flags().Bool("dry-run", false, "List the pull/merge requests and lockfiles without analyzing them")
+	rootCmd.AddCommand(scanPrsCmd)
+}
+
+var scanPrsCmd = &cobra.Command{
+	Use:   "scan-prs",
+	Short: "Analyze lockfiles changed by open pull/merge requests against each repo's Phylum projects",
+	Run: func(cmd *cobra.Command, args []string) {
+		var mineOnly bool = false
+		var ratelimit int = 0
+		var err error
+
+		if cmd.Flags().Lookup("debug").Changed {
+			log.SetLevel(log.DebugLevel)
+		}
+
+		if cmd.Flags().Lookup("mine-only").Changed {
+			mineOnly = true
+		}
+		if cmd.Flags().Lookup("ratelimit").Changed {
+			ratelimit, err = cmd.Flags().GetInt("ratelimit")
+			if err != nil {
+				log.Errorf("Failed to read int value from ratelimit")
+			}
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Errorf("Failed to read bool value from dry-run")
+		}
+
+		opts := structs.SyringeOptions{
+			MineOnly:  mineOnly,
+			RateLimit: ratelimit,
+			Filter:    readRepoFilter(cmd),
+			Http:      readHttpOptions(cmd),
+			Refs:      readRefOptions(cmd),
+		}
+
+		configData, err := utils.ReadConfigFile(&structs.TestConfigData{})
+		if err != nil {
+			log.Fatalf("Failed to read config file")
+			return
+		}
+
+		s, err := Syringe2.NewSyringe(configData, &opts)
+		if err != nil {
+			log.Fatalf("Failed to create NewSyringe(): %v\n", err)
+			return
+		}
+
+		phylumProjectMap := &map[string]structs.PhylumProject{}
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.ListProjects(); err != nil {
+				log.Fatalf("Failed to ListProjects(): %v\n", err)
+				return
+			}
+		}()
+		if !dryRun {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.PhylumGetProjectMap(&phylumProjectMap); err != nil {
+					log.Fatalf("Failed to PhylumGetProjectMap(): %v\n", err)
+					return
+				}
+			}()
+		}
+		wg.Wait()
+
+		if err = s.GetAllPullRequests(); err != nil {
+			log.Errorf("Failed to GetAllPullRequests(): %v\n", err)
+		}
+
+		t := table.NewWriter()
+		t.SetStyle(table.StyleLight)
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Source", "Project Name", "Pull Request", "Lockfile", "Result"})
+		for _, p := range *s.Projects {
+			for _, pullRequest := range p.PullRequests {
+				prName := fmt.Sprintf("#%v %v", pullRequest.Id, pullRequest.Title)
+				for _, lockfile := range pullRequest.Lockfiles {
+					if dryRun {
+						t.AppendRow(table.Row{p.Source, p.Name, prName, lockfile.Path, "dry run"})
+						continue
+					}
+					err := s.PhylumAnalyzePullRequest(p, pullRequest, lockfile, phylumProjectMap)
+					if err != nil {
+						log.Errorf("Failed to analyze %v from pull request %v in %v: %v\n", lockfile.Path, pullRequest.Id, p.Name, err)
+						t.AppendRow(table.Row{p.Source, p.Name, prName, lockfile.Path, fmt.Sprintf("failed: %v", err)})
+						continue
+					}
+					t.AppendRow(table.Row{p.Source, p.Name, prName, lockfile.Path, fmt.Sprintf("analyzed: %v", Syringe2.PullRequestLabel(pullRequest))})
+				}
+			}
+		}
+		t.Render()
+		renderRateLimitStats(s)
+	},
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	Syringe2 "github.com/peterjmorgan/Syringe/internal"
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	serveCmd.Flags().String("listen", ":8080", "Address to take webhooks on")
+	serveCmd.Flags().String("state-file", "", "File recording what was scanned, shared with run-phylum. Off by default")
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Receive push webhooks and analyze the lockfiles of each pushed repo",
+	Run: func(cmd *cobra.Command, args []string) {
+		var mineOnly bool = false
+		var ratelimit int = 0
+		var err error
+
+		if cmd.Flags().Lookup("debug").Changed {
+			log.SetLevel(log.DebugLevel)
+		}
+
+		if cmd.Flags().Lookup("mine-only").Changed {
+			mineOnly = true
+		}
+		if cmd.Flags().Lookup("ratelimit").Changed {
+			ratelimit, err = cmd.Flags().GetInt("ratelimit")
+			if err != nil {
+				log.Errorf("Failed to read int value from ratelimit")
+			}
+		}
+		discovery, err := cmd.Flags().GetString("discovery")
+		if err != nil {
+			log.Errorf("Failed to read string value from discovery")
+		}
+		listen, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			log.Errorf("Failed to read string value from listen")
+		}
+		stateFile, err := cmd.Flags().GetString("state-file")
+		if err != nil {
+			log.Errorf("Failed to read string value from state-file")
+		}
+
+		opts := structs.SyringeOptions{
+			MineOnly:  mineOnly,
+			RateLimit: ratelimit,
+			Discovery: discovery,
+			Filter:    readRepoFilter(cmd),
+			Http:      readHttpOptions(cmd),
+			Refs:      readRefOptions(cmd),
+			StateFile: stateFile,
+		}
+
+		configData, err := utils.ReadConfigFile(&structs.TestConfigData{})
+		if err != nil {
+			log.Fatalf("Failed to read config file")
+			return
+		}
+
+		s, err := Syringe2.NewSyringe(configData, &opts)
+		if err != nil {
+			log.Fatalf("Failed to create NewSyringe(): %v\n", err)
+			return
+		}
+
+		served := 0
+		for _, source := range s.Sources {
+			if source.WebhookSecret == "" {
+				log.Warnf("Source %v has no webhookSecret, not taking its webhooks\n", source.Name)
+				continue
+			}
+			served++
+			log.Infof("Taking %v webhooks for source %v on %v%v\n", source.VcsType, source.Name, Syringe2.WebhookPath, source.Name)
+		}
+		if served == 0 {
+			log.Fatalf("No source has a webhookSecret in the config\n")
+			return
+		}
+
+		var phylumProjectMap *map[string]structs.PhylumProject
+		var wg sync.WaitGroup
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := s.ListProjects(); err != nil {
+				log.Fatalf("Failed to ListProjects(): %v\n", err)
+				return
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := s.PhylumGetProjectMap(&phylumProjectMap); err != nil {
+				log.Fatalf("Failed to PhylumGetProjectMap(): %v\n", err)
+				return
+			}
+		}()
+		wg.Wait()
+		log.Infof("Watching %v projects\n", len(*s.Projects))
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		webhookServer := Syringe2.NewWebhookServer(s, phylumProjectMap)
+		go webhookServer.Run(ctx)
+
+		httpServer := &http.Server{Addr: listen, Handler: webhookServer, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			httpServer.Shutdown(shutdownCtx)
+		}()
+
+		log.Infof("Listening on %v\n", listen)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to serve: %v\n", err)
+		}
+	},
+}
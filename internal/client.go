@@ -1,15 +1,68 @@
 package syringePackage
 
 import (
+	"fmt"
 	"strings"
 
 	Client2 "github.com/peterjmorgan/Syringe/internal/client"
 	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
 )
 
 type Client interface {
 	ListProjects() (*[]*structs.SyringeProject, error)
-	GetLockfilesByProject(int64, string) ([]*structs.VcsFile, error)
+	GetLockfilesByProject(string, string) ([]*structs.VcsFile, error)
+}
+
+// SkipReporter is implemented by clients that leave projects out of ListProjects, e.g. due to filters
+type SkipReporter interface {
+	SkippedProjects() []*structs.SkippedProject
+}
+
+// RateLimitReporter is implemented by clients that pace their requests by the VCS's rate limit
+// headers, to report how often they were throttled. The limiter is nil when there is none, and
+// may be shared with other sources of the same account.
+type RateLimitReporter interface {
+	RateLimiter() *utils.AdaptiveRateLimitTransport
+}
+
+// FileGetter is implemented by clients that can fetch any files matching a path predicate, so
+// lockfiles and CI files are collected in one pass over the repo
+type FileGetter interface {
+	GetFilesByProject(string, string, func(string) bool) ([]*structs.VcsFile, error)
+}
+
+// Injector is implemented by clients that can propose a change to a repo as a pull or merge
+// request. It returns the request's web URL.
+type Injector interface {
+	OpenChangeRequest(projectId string, change *structs.ChangeRequest) (string, error)
+}
+
+// RefLister is implemented by clients that can list a project's branch and tag names, for scanning
+// refs other than the default branch. Tags are passed back to GetFilesByProject as utils.TagRef.
+type RefLister interface {
+	ListRefs(projectId string) (branches []string, tags []string, err error)
+}
+
+// PullRequestLister is implemented by clients that can list a project's open pull or merge
+// requests and fetch the head version of each lockfile a request adds or modifies
+type PullRequestLister interface {
+	ListPullRequests(projectId string) ([]*structs.PullRequest, error)
+	GetPullRequestLockfiles(projectId string, pullRequest *structs.PullRequest) ([]*structs.VcsFile, error)
+}
+
+// HeadGetter is implemented by clients that can look up the commit a branch points at, so
+// incremental scans can skip projects that haven't changed without listing their trees
+type HeadGetter interface {
+	GetHead(projectId string, branch string) (string, error)
+}
+
+// Searcher is implemented by clients that can find files across every project they list with a few
+// code search queries, instead of listing each project's tree. SearchFiles keys paths by the ID part
+// of the project key (see structs.NewProjectKey). Search only covers default branches.
+type Searcher interface {
+	SearchFiles(match func(string) bool) (map[string][]string, error)
+	GetFiles(projectId string, ref string, paths []string) ([]*structs.VcsFile, error)
 }
 
 // func NewClient(clientType string, envMap map[string]string, mineOnly bool, ratelimit int, proxyUrl string) (Client, error) {
@@ -28,6 +81,63 @@ func NewClient(clientType string, configData *structs.ConfigThing, opts *structs
 		c = Client2.NewAzureClient(configData, opts)
 	case "bitbucket_cloud":
 		c = Client2.NewBitbucketCloudClient(configData, opts)
+	case "bitbucket_server": // bitbucket server / data center
+		c = Client2.NewBitbucketServerClient(configData, opts)
+	case "gitea": // gitea / forgejo
+		c = Client2.NewGiteaClient(configData, opts)
+	case "local": // checked-out directories on disk
+		c = Client2.NewLocalClient(configData, opts)
+	default:
+		err = fmt.Errorf("unknown VCS type: %v", clientType)
 	}
 	return c, err
 }
+
+// Source is a named Client. Projects record the name of the source that listed them.
+type Source struct {
+	Name          string
+	Client        Client
+	VcsType       string
+	WebhookSecret string // verifies the source's push webhooks for serve, from webhookSecret in the config
+}
+
+// NewSources creates a client for each source in the config. A config without sources is a
+// single source named after its VcsType.
+func NewSources(configData *structs.ConfigThing, opts *structs.SyringeOptions) ([]*Source, error) {
+	if len(configData.Sources) == 0 {
+		client, err := NewClient(configData.VcsType, configData, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []*Source{{
+			Name:          strings.ToLower(configData.VcsType),
+			Client:        client,
+			VcsType:       strings.ToLower(configData.VcsType),
+			WebhookSecret: configData.Associated["webhookSecret"],
+		}}, nil
+	}
+
+	sources := make([]*Source, 0, len(configData.Sources))
+	seen := make(map[string]bool, len(configData.Sources))
+	for _, sourceConfig := range configData.Sources {
+		if sourceConfig.Name == "" {
+			return nil, fmt.Errorf("source with VCS type %v has no name", sourceConfig.VcsType)
+		}
+		if seen[sourceConfig.Name] {
+			return nil, fmt.Errorf("duplicate source name: %v", sourceConfig.Name)
+		}
+		seen[sourceConfig.Name] = true
+
+		client, err := NewClient(sourceConfig.VcsType, configData.SourceConfig(sourceConfig), opts)
+		if err != nil {
+			return nil, fmt.Errorf("source %v: %v", sourceConfig.Name, err)
+		}
+		sources = append(sources, &Source{
+			Name:          sourceConfig.Name,
+			Client:        client,
+			VcsType:       strings.ToLower(sourceConfig.VcsType),
+			WebhookSecret: sourceConfig.Associated["webhookSecret"],
+		})
+	}
+	return sources, nil
+}
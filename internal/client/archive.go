@@ -0,0 +1,148 @@
+package client
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// Archive is a download of every file on one ref of a project
+type Archive struct {
+	Body   io.ReadCloser
+	Format string // ArchiveTarGz or ArchiveZip
+	// StripPrefix is set when every entry is nested in one top-level directory, e.g. owner-repo-sha/
+	StripPrefix bool
+}
+
+// Archiver is implemented by clients that can download a project's ref as one archive for
+// archive-based discovery. The ref is a branch name or utils.TagRef.
+type Archiver interface {
+	DownloadArchive(projectId string, ref string) (*Archive, error)
+}
+
+// ArchiveFiles reads the files whose path satisfies match out of the archive and closes it.
+// Tarballs are read as they stream in; zips are spooled to a temp file as they need random access.
+func ArchiveFiles(archive *Archive, match func(string) bool) ([]*structs.VcsFile, error) {
+	defer archive.Body.Close()
+
+	switch archive.Format {
+	case ArchiveTarGz:
+		return tarGzFiles(archive, match)
+	case ArchiveZip:
+		return zipFiles(archive, match)
+	default:
+		return nil, fmt.Errorf("unknown archive format: %v", archive.Format)
+	}
+}
+
+// archivePath returns the repo path of an archive entry, or "" for the top-level directory
+func archivePath(name string, stripPrefix bool) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if stripPrefix {
+		_, name, _ = strings.Cut(name, "/")
+	}
+	return name
+}
+
+func archiveFile(filePath string, content []byte) *structs.VcsFile {
+	log.Debugf("File: %v in %v from archive\n", path.Base(filePath), filePath)
+	return &structs.VcsFile{
+		Name:          path.Base(filePath),
+		Path:          filePath,
+		Id:            gitBlobSHA(content),
+		Content:       content,
+		PhylumProject: nil,
+	}
+}
+
+func tarGzFiles(archive *Archive, match func(string) bool) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	gzipReader, err := gzip.NewReader(archive.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %v", err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		filePath := archivePath(header.Name, archive.StripPrefix)
+		if filePath == "" || !match(filePath) {
+			continue
+		}
+		content, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v from archive: %v", filePath, err)
+		}
+		retFiles = append(retFiles, archiveFile(filePath, content))
+	}
+
+	return retFiles, nil
+}
+
+func zipFiles(archive *Archive, match func(string) bool) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	tempFile, err := ioutil.TempFile("", "syringe-archive")
+	if err != nil {
+		log.Errorf("Failed to create temp file: %v\n", err)
+		return nil, err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	size, err := io.Copy(tempFile, archive.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download archive: %v", err)
+	}
+	zipReader, err := zip.NewReader(tempFile, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %v", err)
+	}
+
+	for _, entry := range zipReader.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		filePath := archivePath(entry.Name, archive.StripPrefix)
+		if filePath == "" || !match(filePath) {
+			continue
+		}
+		entryReader, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v from archive: %v", filePath, err)
+		}
+		content, err := ioutil.ReadAll(entryReader)
+		entryReader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v from archive: %v", filePath, err)
+		}
+		retFiles = append(retFiles, archiveFile(filePath, content))
+	}
+
+	return retFiles, nil
+}
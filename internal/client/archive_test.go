@@ -0,0 +1,114 @@
+package client
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/peterjmorgan/Syringe/internal/utils"
+)
+
+var archiveTestFiles = map[string]string{
+	"package-lock.json":           "{}",
+	"README.md":                   "readme",
+	"services/api/poetry.lock":    "poetry",
+	"services/api/main.py":        "print()",
+	"services/web/node/yarn.lock": "yarn",
+}
+
+// makeTarGz builds a tarball of files, nested in prefix when it is set
+func makeTarGz(t *testing.T, prefix string, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	if prefix != "" {
+		tarWriter.WriteHeader(&tar.Header{Name: prefix + "/", Typeflag: tar.TypeDir, Mode: 0755})
+	}
+	// GitHub tarballs start with a pax header carrying the commit
+	tarWriter.WriteHeader(&tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "c0ffee"}})
+	for path, content := range files {
+		if prefix != "" {
+			path = prefix + "/" + path
+		}
+		if err := tarWriter.WriteHeader(&tar.Header{Name: path, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		tarWriter.Write([]byte(content))
+	}
+	tarWriter.Close()
+	gzipWriter.Close()
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	zipWriter.Create("services/")
+	for path, content := range files {
+		entryWriter, err := zipWriter.Create(path)
+		if err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+		entryWriter.Write([]byte(content))
+	}
+	zipWriter.Close()
+	return buf.Bytes()
+}
+
+func TestArchiveFiles(t *testing.T) {
+	wantPaths := []string{"package-lock.json", "services/api/poetry.lock", "services/web/node/yarn.lock"}
+
+	tests := []struct {
+		name    string
+		archive func() *Archive
+		wantErr bool
+	}{
+		{"tar.gz with prefix", func() *Archive {
+			return &Archive{Body: ioutil.NopCloser(bytes.NewReader(makeTarGz(t, "acme-api-c0ffee", archiveTestFiles))), Format: ArchiveTarGz, StripPrefix: true}
+		}, false},
+		{"tar.gz", func() *Archive {
+			return &Archive{Body: ioutil.NopCloser(bytes.NewReader(makeTarGz(t, "", archiveTestFiles))), Format: ArchiveTarGz}
+		}, false},
+		{"zip", func() *Archive {
+			return &Archive{Body: ioutil.NopCloser(bytes.NewReader(makeZip(t, archiveTestFiles))), Format: ArchiveZip}
+		}, false},
+		{"not gzip", func() *Archive {
+			return &Archive{Body: ioutil.NopCloser(bytes.NewReader([]byte("<html>"))), Format: ArchiveTarGz}
+		}, true},
+		{"unknown format", func() *Archive {
+			return &Archive{Body: ioutil.NopCloser(bytes.NewReader(nil)), Format: "rar"}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ArchiveFiles(tt.archive(), utils.IsLockfile)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ArchiveFiles() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			var gotPaths []string
+			for _, file := range got {
+				gotPaths = append(gotPaths, file.Path)
+				if string(file.Content) != archiveTestFiles[file.Path] {
+					t.Errorf("ArchiveFiles() %v content = %q", file.Path, file.Content)
+				}
+				if file.Id != gitBlobSHA(file.Content) {
+					t.Errorf("ArchiveFiles() %v id = %v", file.Path, file.Id)
+				}
+			}
+			sort.Strings(gotPaths)
+			if !reflect.DeepEqual(gotPaths, wantPaths) {
+				t.Errorf("ArchiveFiles() paths = %v, want %v", gotPaths, wantPaths)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -11,7 +12,6 @@ import (
 	"github.com/microsoft/azure-devops-go-api/azuredevops/git"
 	"github.com/peterjmorgan/Syringe/internal/utils"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/exp/slices"
 
 	"github.com/microsoft/azure-devops-go-api/azuredevops"
 	"github.com/microsoft/azure-devops-go-api/azuredevops/core"
@@ -22,8 +22,11 @@ type AzureClient struct {
 	Clients         *AzureSubClient
 	Ctx             context.Context
 	OrgName         string
-	ProjectMap      map[int64]*git.GitRepository
+	Token           string
+	TeamProjects    []string
+	ProjectMap      map[string]*git.GitRepository
 	ProjectMapMutex sync.RWMutex
+	rateLimiter     *utils.AdaptiveRateLimitTransport // paces the organization's requests through http.DefaultTransport
 }
 
 type AzureSubClient struct {
@@ -35,10 +38,15 @@ type AzureSubClient struct {
 // func NewAzureClient(envMap map[string]string, opts *structs.SyringeOptions) *AzureClient {
 func NewAzureClient(configData *structs.ConfigThing, opts *structs.SyringeOptions) *AzureClient {
 
-	// TODO: handle alternate urls to ADO
 	//org := envMap["vcsOrg"]
 	//conn := azuredevops.NewPatConnection(org, envMap["vcsToken"])
-	conn := azuredevops.NewPatConnection(configData.Associated["azureOrg"], configData.VcsToken)
+	connectionUrl := AzureConnectionUrl(configData.Associated["azureOrg"], configData.Associated["azureUrl"])
+	conn := azuredevops.NewPatConnection(connectionUrl, configData.VcsToken)
+	// The SDK can't be handed a client. Leaving TlsConfig unset makes it use http.DefaultTransport,
+	// which NewSyringe has replaced with the shared transport.
+	if opts != nil && opts.Http.Timeout != 0 {
+		conn.Timeout = &opts.Http.Timeout
+	}
 	ctx := context.Background()
 	coreClient, err := core.NewClient(ctx, conn)
 	if err != nil {
@@ -59,15 +67,103 @@ func NewAzureClient(configData *structs.ConfigThing, opts *structs.SyringeOption
 			BuildClient: buildClient,
 			GitClient:   gitClient,
 		},
-		Ctx:        ctx,
-		ProjectMap: make(map[int64]*git.GitRepository, 0),
+		Ctx:          ctx,
+		OrgName:      connectionUrl,
+		Token:        configData.VcsToken,
+		TeamProjects: utils.SplitList(configData.Associated["azureProjects"]),
+		ProjectMap:   make(map[string]*git.GitRepository, 0),
+		rateLimiter:  utils.DefaultRateLimiter(connectionUrl),
 	}
 }
 
+// RateLimiter returns the limiter pacing the organization's requests, which sources configured with
+// the same organization share
+func (a *AzureClient) RateLimiter() *utils.AdaptiveRateLimitTransport {
+	return a.rateLimiter
+}
+
+// AzureConnectionUrl returns the organization or collection URL to connect to. collectionUrl is an
+// Azure DevOps Server collection (e.g. https://ado.example.com/tfs/DefaultCollection) and takes
+// precedence; a bare org name is expanded to its dev.azure.com URL.
+func AzureConnectionUrl(org string, collectionUrl string) string {
+	if collectionUrl = strings.TrimSpace(collectionUrl); collectionUrl != "" {
+		return strings.TrimSuffix(collectionUrl, "/")
+	}
+
+	org = strings.TrimSuffix(strings.TrimSpace(org), "/")
+	if org != "" && !strings.HasPrefix(org, "http://") && !strings.HasPrefix(org, "https://") {
+		return fmt.Sprintf("https://dev.azure.com/%v", org)
+	}
+	return org
+}
+
+// Host names the organization or collection server in project keys
+func (a *AzureClient) Host() string {
+	return utils.UrlHost(a.OrgName)
+}
+
+// ListProjects lists the repos in the configured team projects, or in every team project in the
+// organization or collection. Repos are named teamproject/repo as names are only unique per team project.
 func (a *AzureClient) ListProjects() (*[]*structs.SyringeProject, error) {
-	var localProjects []core.TeamProjectReference
 	var retProjects []*structs.SyringeProject
 
+	teamProjects := a.TeamProjects
+	if len(teamProjects) == 0 {
+		var err error
+		teamProjects, err = a.listTeamProjects()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Iterate through ADO repositories
+	for _, teamProject := range teamProjects {
+		teamProject := teamProject
+		repos, err := a.Clients.GitClient.GetRepositories(a.Ctx, git.GetRepositoriesArgs{
+			Project: &teamProject,
+		})
+		if err != nil {
+			errStr := fmt.Sprintf("failed to GetRepositories for %v: %v\n", teamProject, err)
+			log.Error(errStr)
+			return nil, fmt.Errorf(errStr)
+		}
+
+		for _, repo := range *repos {
+			// Empty repos have no default branch
+			var branch string
+			if repo.DefaultBranch != nil {
+				branch = *repo.DefaultBranch
+			}
+			projectName := teamProject
+			if repo.Project != nil && repo.Project.Name != nil {
+				projectName = *repo.Project.Name
+			}
+
+			projectKey := structs.NewProjectKey("azure", a.Host(), projectName, repo.Id.String())
+			retProjects = append(retProjects, &structs.SyringeProject{
+				Id:        projectKey,
+				GUID:      *repo.Id,
+				Name:      fmt.Sprintf("%v/%v", projectName, *repo.Name),
+				Namespace: projectName,
+				Branch:    branch,
+				Lockfiles: nil,
+				CiFiles:   nil,
+				Hydrated:  false,
+			})
+			temp := new(git.GitRepository)
+			*temp = repo
+			a.ProjectMapMutex.Lock()
+			a.ProjectMap[projectKey] = temp
+			a.ProjectMapMutex.Unlock()
+		}
+	}
+	return &retProjects, nil
+}
+
+// listTeamProjects returns the ID of every team project in the organization or collection
+func (a *AzureClient) listTeamProjects() ([]string, error) {
+	var retProjects []string
+
 	// Projects are not 1-to-1 with repositories in ADO
 	projectResp, err := a.Clients.CoreClient.GetProjects(a.Ctx, core.GetProjectsArgs{})
 	if err != nil {
@@ -77,7 +173,7 @@ func (a *AzureClient) ListProjects() (*[]*structs.SyringeProject, error) {
 	// Paginate through ADO Projects
 	for projectResp != nil {
 		for _, proj := range (*projectResp).Value {
-			localProjects = append(localProjects, proj)
+			retProjects = append(retProjects, proj.Id.String())
 		}
 		if projectResp.ContinuationToken != "" {
 			projectArgs := core.GetProjectsArgs{
@@ -91,53 +187,32 @@ func (a *AzureClient) ListProjects() (*[]*structs.SyringeProject, error) {
 			projectResp = nil
 		}
 	}
+	return retProjects, nil
+}
 
-	// Iterate through ADO repositories
-	for _, proj := range localProjects {
-
-		projId := proj.Id.String()
-		repos, err := a.Clients.GitClient.GetRepositories(a.Ctx, git.GetRepositoriesArgs{
-			Project: &projId,
-		})
-		if err != nil {
-			errStr := fmt.Sprintf("failed to GetRepositories for %v: %v\n", proj.Name, err)
-			log.Error(errStr)
-			return nil, fmt.Errorf(errStr)
-		}
-
-		for _, repo := range *repos {
-			retProjects = append(retProjects, &structs.SyringeProject{
-				Id:        int64(repo.Id.ID()),
-				GUID:      *repo.Id,
-				Name:      *repo.Name,
-				Branch:    *repo.DefaultBranch,
-				Lockfiles: nil,
-				CiFiles:   nil,
-				Hydrated:  false,
-			})
-			temp := new(git.GitRepository)
-			*temp = repo
-			a.ProjectMap[int64(repo.Id.ID())] = temp
-		}
+// azureVersion describes a branch, refs/heads/ branch or utils.TagRef for the items API
+func azureVersion(branch string) *git.GitVersionDescriptor {
+	refName, isTag := utils.SplitRef(branch)
+	versionType := git.GitVersionTypeValues.Branch
+	if isTag {
+		versionType = git.GitVersionTypeValues.Tag
+	}
+	return &git.GitVersionDescriptor{
+		Version:     &refName,
+		VersionType: &versionType,
 	}
-	return &retProjects, nil
 }
 
 func (a *AzureClient) ListFiles(repoID string, branch string) ([]*git.GitItem, error) {
 	var retItems []*git.GitItem
 
 	var recurse git.VersionControlRecursionType = "full"
-	var versionType git.GitVersionType = git.GitVersionType("branch")
 
 	items, err := a.Clients.GitClient.GetItems(a.Ctx, git.GetItemsArgs{
 		RepositoryId:           &repoID,
 		RecursionLevel:         &recurse,
 		IncludeContentMetadata: &[]bool{true}[0],
-		VersionDescriptor: &git.GitVersionDescriptor{
-			Version:        &branch,
-			VersionOptions: nil,
-			VersionType:    &versionType,
-		},
+		VersionDescriptor:      azureVersion(branch),
 	})
 	if err != nil {
 		errStr := fmt.Sprintf("failed to GetItems for %v: %v\n", repoID, err)
@@ -158,17 +233,26 @@ func (a *AzureClient) ListFiles(repoID string, branch string) ([]*git.GitItem, e
 // This is a little messed up because i'm calling repos "projects" and those don't match up in ADOland
 // This should be okay. ListProjects() creates a SyringeProject for each repo in an ADO project.
 // TODO: consider renaming SyringeProject to SyringeRepository as that's a better term for the struct
-func (a *AzureClient) GetLockfilesByProject(projectId int64, mainBranchName string) ([]*structs.VcsFile, error) {
-	var retLockfiles []*structs.VcsFile
+func (a *AzureClient) GetLockfilesByProject(projectId string, mainBranchName string) ([]*structs.VcsFile, error) {
+	return a.GetFilesByProject(projectId, mainBranchName, utils.IsLockfile)
+}
+
+// GetFilesByProject fetches every file on the branch whose path satisfies match
+func (a *AzureClient) GetFilesByProject(projectId string, mainBranchName string, match func(string) bool) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
 
 	a.ProjectMapMutex.RLock()
 	repo := a.ProjectMap[projectId]
 	a.ProjectMapMutex.RUnlock()
 
+	if repo == nil {
+		return nil, fmt.Errorf("unknown azure repo %v", projectId)
+	}
 	guid := repo.Id.String()
 
-	if strings.Contains(mainBranchName, "/") {
-		mainBranchName = filepath.Base(mainBranchName)
+	// Empty repos have no branch to read from
+	if mainBranchName == "" {
+		return nil, nil
 	}
 
 	projectFiles, err := a.ListFiles(guid, mainBranchName)
@@ -178,17 +262,16 @@ func (a *AzureClient) GetLockfilesByProject(projectId int64, mainBranchName stri
 		return nil, fmt.Errorf(errStr)
 	}
 
-	supportedLockfiles := utils.GetSupportedLockfiles()
-
 	for _, file := range projectFiles {
 		fileName := filepath.Base(*file.Path)
-		if slices.Contains(supportedLockfiles, fileName) || strings.HasSuffix(fileName, ".csproj") {
-			log.Debugf("Lockfile: %v in %v from project: %v\n", fileName, *file.Path, *repo.Name)
+		if match(*file.Path) {
+			log.Debugf("File: %v in %v from project: %v\n", fileName, *file.Path, *repo.Name)
 			// download te file
 			item, err := a.Clients.GitClient.GetItem(a.Ctx, git.GetItemArgs{
-				RepositoryId:   &guid,
-				Path:           file.Path,
-				IncludeContent: &[]bool{true}[0],
+				RepositoryId:      &guid,
+				Path:              file.Path,
+				IncludeContent:    &[]bool{true}[0],
+				VersionDescriptor: azureVersion(mainBranchName),
 			})
 			if err != nil {
 				errStr := fmt.Sprintf("failed to GetItem for %v: %v\n", fileName, err)
@@ -196,15 +279,375 @@ func (a *AzureClient) GetLockfilesByProject(projectId int64, mainBranchName stri
 				return nil, fmt.Errorf(errStr)
 			}
 
-			retLockfiles = append(retLockfiles, &structs.VcsFile{
+			retFiles = append(retFiles, &structs.VcsFile{
 				Name:          fileName,
 				Path:          *file.Path,
-				Id:            *file.CommitId, // TODO: look at this
+				Id:            *file.ObjectId,
 				Content:       []byte(*item.Content),
 				PhylumProject: nil,
 			})
 		}
 	}
 
-	return retLockfiles, nil
+	return retFiles, nil
+}
+
+// DownloadArchive downloads a zip of every file on the branch or utils.TagRef through the items API
+func (a *AzureClient) DownloadArchive(projectId string, ref string) (*Archive, error) {
+	a.ProjectMapMutex.RLock()
+	repo := a.ProjectMap[projectId]
+	a.ProjectMapMutex.RUnlock()
+	if repo == nil {
+		return nil, fmt.Errorf("unknown azure repo %v", projectId)
+	}
+	guid := repo.Id.String()
+	var teamProject *string
+	if repo.Project != nil {
+		teamProject = repo.Project.Name
+	}
+
+	var recurse git.VersionControlRecursionType = "full"
+	body, err := a.Clients.GitClient.GetItemZip(a.Ctx, git.GetItemZipArgs{
+		RepositoryId:      &guid,
+		Project:           teamProject,
+		Path:              &[]string{"/"}[0],
+		RecursionLevel:    &recurse,
+		Download:          &[]bool{true}[0],
+		VersionDescriptor: azureVersion(ref),
+	})
+	if err != nil {
+		log.Errorf("Failed to GetItemZip for %v: %v\n", *repo.Name, err)
+		return nil, err
+	}
+	return &Archive{Body: body, Format: ArchiveZip}, nil
+}
+
+// ListRefs lists the names of a repo's branches and tags
+func (a *AzureClient) ListRefs(projectId string) ([]string, []string, error) {
+	var branches, tags []string
+
+	a.ProjectMapMutex.RLock()
+	repo := a.ProjectMap[projectId]
+	a.ProjectMapMutex.RUnlock()
+	if repo == nil {
+		return nil, nil, fmt.Errorf("unknown azure repo %v", projectId)
+	}
+	guid := repo.Id.String()
+	var teamProject *string
+	if repo.Project != nil {
+		teamProject = repo.Project.Name
+	}
+
+	for _, prefix := range []string{"heads/", "tags/"} {
+		var continuationToken *string
+		for {
+			refs, err := a.Clients.GitClient.GetRefs(a.Ctx, git.GetRefsArgs{
+				RepositoryId:      &guid,
+				Project:           teamProject,
+				Filter:            &[]string{prefix}[0],
+				ContinuationToken: continuationToken,
+			})
+			if err != nil {
+				log.Errorf("Failed to GetRefs for %v: %v\n", *repo.Name, err)
+				return nil, nil, err
+			}
+			for _, ref := range refs.Value {
+				if ref.Name == nil {
+					continue
+				}
+				refName, isTag := utils.SplitRef(*ref.Name)
+				if isTag {
+					tags = append(tags, refName)
+				} else {
+					branches = append(branches, refName)
+				}
+			}
+			if refs.ContinuationToken == "" {
+				break
+			}
+			continuationToken = &refs.ContinuationToken
+		}
+	}
+
+	return branches, tags, nil
+}
+
+// ListPullRequests lists a repo's active pull requests
+func (a *AzureClient) ListPullRequests(projectId string) ([]*structs.PullRequest, error) {
+	var retPullRequests []*structs.PullRequest
+
+	a.ProjectMapMutex.RLock()
+	repo := a.ProjectMap[projectId]
+	a.ProjectMapMutex.RUnlock()
+	if repo == nil {
+		return nil, fmt.Errorf("unknown azure repo %v", projectId)
+	}
+	guid := repo.Id.String()
+	var teamProject *string
+	if repo.Project != nil {
+		teamProject = repo.Project.Name
+	}
+
+	top := 100
+	for skip := 0; ; skip += top {
+		pullRequests, err := a.Clients.GitClient.GetPullRequests(a.Ctx, git.GetPullRequestsArgs{
+			RepositoryId:   &guid,
+			Project:        teamProject,
+			SearchCriteria: &git.GitPullRequestSearchCriteria{Status: &git.PullRequestStatusValues.Active},
+			Skip:           &[]int{skip}[0],
+			Top:            &top,
+		})
+		if err != nil {
+			log.Errorf("Failed to GetPullRequests for %v: %v\n", *repo.Name, err)
+			return nil, err
+		}
+		for _, pullRequest := range *pullRequests {
+			if pullRequest.PullRequestId == nil {
+				continue
+			}
+			pr := &structs.PullRequest{Id: strconv.Itoa(*pullRequest.PullRequestId)}
+			if pullRequest.Title != nil {
+				pr.Title = *pullRequest.Title
+			}
+			if repo.WebUrl != nil {
+				pr.Url = fmt.Sprintf("%v/pullrequest/%v", *repo.WebUrl, *pullRequest.PullRequestId)
+			}
+			if pullRequest.SourceRefName != nil {
+				pr.HeadBranch, _ = utils.SplitRef(*pullRequest.SourceRefName)
+			}
+			if pullRequest.LastMergeSourceCommit != nil && pullRequest.LastMergeSourceCommit.CommitId != nil {
+				pr.HeadSha = *pullRequest.LastMergeSourceCommit.CommitId
+			}
+			if pullRequest.ForkSource != nil && pullRequest.ForkSource.Repository != nil && pullRequest.ForkSource.Repository.Name != nil {
+				pr.HeadRepo = *pullRequest.ForkSource.Repository.Name
+			}
+			retPullRequests = append(retPullRequests, pr)
+		}
+		if len(*pullRequests) < top {
+			break
+		}
+	}
+
+	return retPullRequests, nil
+}
+
+// GetPullRequestLockfiles fetches the lockfiles a pull request adds or modifies, as of its latest
+// iteration, at the head commit
+func (a *AzureClient) GetPullRequestLockfiles(projectId string, pullRequest *structs.PullRequest) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	a.ProjectMapMutex.RLock()
+	repo := a.ProjectMap[projectId]
+	a.ProjectMapMutex.RUnlock()
+	if repo == nil {
+		return nil, fmt.Errorf("unknown azure repo %v", projectId)
+	}
+	guid := repo.Id.String()
+	var teamProject *string
+	if repo.Project != nil {
+		teamProject = repo.Project.Name
+	}
+	pullRequestId, err := strconv.Atoi(pullRequest.Id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pull request id %v: %v", pullRequest.Id, err)
+	}
+
+	iterations, err := a.Clients.GitClient.GetPullRequestIterations(a.Ctx, git.GetPullRequestIterationsArgs{
+		RepositoryId:  &guid,
+		PullRequestId: &pullRequestId,
+		Project:       teamProject,
+	})
+	if err != nil {
+		log.Errorf("Failed to GetPullRequestIterations for %v in %v: %v\n", pullRequestId, *repo.Name, err)
+		return nil, err
+	}
+	if len(*iterations) == 0 || (*iterations)[len(*iterations)-1].Id == nil {
+		return nil, nil
+	}
+	iterationId := *(*iterations)[len(*iterations)-1].Id
+
+	var paths []string
+	top := 2000
+	skip := 0
+	for {
+		changes, err := a.Clients.GitClient.GetPullRequestIterationChanges(a.Ctx, git.GetPullRequestIterationChangesArgs{
+			RepositoryId:  &guid,
+			PullRequestId: &pullRequestId,
+			IterationId:   &iterationId,
+			Project:       teamProject,
+			Top:           &top,
+			Skip:          &skip,
+		})
+		if err != nil {
+			log.Errorf("Failed to GetPullRequestIterationChanges for %v in %v: %v\n", pullRequestId, *repo.Name, err)
+			return nil, err
+		}
+		if changes.ChangeEntries != nil {
+			for _, change := range *changes.ChangeEntries {
+				if change.ChangeType != nil && strings.Contains(string(*change.ChangeType), string(git.VersionControlChangeTypeValues.Delete)) {
+					continue
+				}
+				// Item is untyped in the API; it decodes as a map with the item's path
+				item, ok := change.Item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if path, ok := item["path"].(string); ok && utils.IsLockfile(path) {
+					paths = append(paths, path)
+				}
+			}
+		}
+		if changes.NextSkip == nil || *changes.NextSkip == 0 {
+			break
+		}
+		skip = *changes.NextSkip
+	}
+
+	versionType := git.GitVersionTypeValues.Commit
+	for _, path := range paths {
+		item, err := a.Clients.GitClient.GetItem(a.Ctx, git.GetItemArgs{
+			RepositoryId:      &guid,
+			Path:              &path,
+			IncludeContent:    &[]bool{true}[0],
+			VersionDescriptor: &git.GitVersionDescriptor{Version: &pullRequest.HeadSha, VersionType: &versionType},
+		})
+		if err != nil {
+			log.Errorf("Failed to GetItem for %v at %v: %v\n", path, pullRequest.HeadSha, err)
+			return nil, err
+		}
+		var content string
+		if item.Content != nil {
+			content = *item.Content
+		}
+		retFiles = append(retFiles, &structs.VcsFile{
+			Name:    filepath.Base(path),
+			Path:    path,
+			Id:      pullRequest.HeadSha,
+			Content: []byte(content),
+		})
+	}
+
+	return retFiles, nil
+}
+
+// refObjectId returns the commit a full ref name such as refs/heads/main points at
+func (a *AzureClient) refObjectId(repo *git.GitRepository, refName string) (string, error) {
+	guid := repo.Id.String()
+	var teamProject *string
+	if repo.Project != nil {
+		teamProject = repo.Project.Name
+	}
+
+	refs, err := a.Clients.GitClient.GetRefs(a.Ctx, git.GetRefsArgs{
+		RepositoryId: &guid,
+		Project:      teamProject,
+		Filter:       &[]string{strings.TrimPrefix(refName, "refs/")}[0],
+	})
+	if err != nil {
+		log.Errorf("Failed to GetRefs for %v: %v\n", *repo.Name, err)
+		return "", err
+	}
+	// The filter is a prefix, so refs/heads/main also matches refs/heads/main-old
+	for _, ref := range refs.Value {
+		if ref.Name != nil && *ref.Name == refName && ref.ObjectId != nil {
+			return *ref.ObjectId, nil
+		}
+	}
+	return "", fmt.Errorf("branch %v not found in %v", refName, *repo.Name)
+}
+
+// GetHead returns the commit the branch points at
+func (a *AzureClient) GetHead(projectId string, branch string) (string, error) {
+	a.ProjectMapMutex.RLock()
+	repo := a.ProjectMap[projectId]
+	a.ProjectMapMutex.RUnlock()
+	if repo == nil {
+		return "", fmt.Errorf("unknown azure repo %v", projectId)
+	}
+	return a.refObjectId(repo, "refs/heads/"+strings.TrimPrefix(branch, "refs/heads/"))
+}
+
+// OpenChangeRequest pushes one commit with every file to a new branch and opens a pull request
+func (a *AzureClient) OpenChangeRequest(projectId string, change *structs.ChangeRequest) (string, error) {
+	a.ProjectMapMutex.RLock()
+	repo := a.ProjectMap[projectId]
+	a.ProjectMapMutex.RUnlock()
+	if repo == nil {
+		return "", fmt.Errorf("unknown azure repo %v", projectId)
+	}
+	guid := repo.Id.String()
+	var teamProject *string
+	if repo.Project != nil {
+		teamProject = repo.Project.Name
+	}
+	baseRef := "refs/heads/" + strings.TrimPrefix(change.BaseBranch, "refs/heads/")
+	headRef := "refs/heads/" + change.HeadBranch
+
+	baseObjectId, err := a.refObjectId(repo, baseRef)
+	if err != nil {
+		return "", err
+	}
+
+	var changes []interface{}
+	for _, file := range change.Files {
+		changeType := git.VersionControlChangeTypeValues.Add
+		if file.Previous != nil {
+			changeType = git.VersionControlChangeTypeValues.Edit
+		}
+		changes = append(changes, git.GitChange{
+			ChangeType: &changeType,
+			Item:       git.GitItem{Path: &[]string{"/" + strings.TrimPrefix(file.Path, "/")}[0]},
+			NewContent: &git.ItemContent{
+				Content:     &[]string{string(file.Content)}[0],
+				ContentType: &git.ItemContentTypeValues.RawText,
+			},
+		})
+	}
+	_, err = a.Clients.GitClient.CreatePush(a.Ctx, git.CreatePushArgs{
+		RepositoryId: &guid,
+		Project:      teamProject,
+		Push: &git.GitPush{
+			// An all-zero old object ID creates the branch
+			RefUpdates: &[]git.GitRefUpdate{{Name: &headRef, OldObjectId: &[]string{strings.Repeat("0", 40)}[0]}},
+			Commits:    &[]git.GitCommitRef{{Comment: &change.CommitMessage, Changes: &changes, Parents: &[]string{baseObjectId}}},
+		},
+	})
+	if err != nil {
+		log.Errorf("Failed to CreatePush for %v: %v\n", *repo.Name, err)
+		return "", err
+	}
+
+	pullRequest, err := a.Clients.GitClient.CreatePullRequest(a.Ctx, git.CreatePullRequestArgs{
+		RepositoryId: &guid,
+		Project:      teamProject,
+		GitPullRequestToCreate: &git.GitPullRequest{
+			SourceRefName: &headRef,
+			TargetRefName: &baseRef,
+			Title:         &change.Title,
+			Description:   &change.Body,
+		},
+	})
+	if err != nil {
+		log.Errorf("Failed to CreatePullRequest for %v: %v\n", *repo.Name, err)
+		return "", err
+	}
+	if repo.WebUrl == nil || pullRequest.PullRequestId == nil {
+		return "", nil
+	}
+	return fmt.Sprintf("%v/pullrequest/%v", *repo.WebUrl, *pullRequest.PullRequestId), nil
+}
+
+func (a *AzureClient) GetCloneTarget(projectId string) (*CloneTarget, error) {
+	a.ProjectMapMutex.RLock()
+	repo, ok := a.ProjectMap[projectId]
+	a.ProjectMapMutex.RUnlock()
+	if !ok || repo.RemoteUrl == nil {
+		return nil, fmt.Errorf("no remote url for project %v", projectId)
+	}
+
+	// ADO accepts a PAT as the password with any username
+	return &CloneTarget{
+		Url:        *repo.RemoteUrl,
+		AuthHeader: basicAuthHeader("pat", a.Token),
+	}, nil
 }
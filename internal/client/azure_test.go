@@ -1,11 +1,16 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/microsoft/azure-devops-go-api/azuredevops/git"
 	"github.com/peterjmorgan/Syringe/internal/structs"
 	"github.com/peterjmorgan/Syringe/internal/utils"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -98,10 +103,16 @@ func TestAzureClient_GetLockfilesByProject(t *testing.T) {
 	a := NewAzureClient(configData, &testingSyringeOpts)
 
 	// populate with projects
-	_, _ = a.ListProjects()
+	projects, _ := a.ListProjects()
+	projectIds := map[string]string{}
+	if projects != nil {
+		for _, p := range *projects {
+			projectIds[p.Name] = p.Id
+		}
+	}
 
 	type args struct {
-		projectId      int64
+		projectId      string
 		mainBranchName string
 	}
 
@@ -112,7 +123,7 @@ func TestAzureClient_GetLockfilesByProject(t *testing.T) {
 		wantLen int
 		wantErr bool
 	}{
-		{"one", args{1249448610, "master"}, nil, 4, false},
+		{"one", args{projectIds["test-project-1/test-project-1"], "master"}, nil, 4, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -131,3 +142,179 @@ func TestAzureClient_GetLockfilesByProject(t *testing.T) {
 		})
 	}
 }
+
+func TestAzureConnectionUrl(t *testing.T) {
+	tests := []struct {
+		name          string
+		org           string
+		collectionUrl string
+		want          string
+	}{
+		{"org url", "https://dev.azure.com/acme/", "", "https://dev.azure.com/acme"},
+		{"org name", "acme", "", "https://dev.azure.com/acme"},
+		{"collection", "acme", "https://ado.example.com/tfs/DefaultCollection/", "https://ado.example.com/tfs/DefaultCollection"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AzureConnectionUrl(tt.org, tt.collectionUrl); got != tt.want {
+				t.Errorf("AzureConnectionUrl() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestAzureServer fakes an Azure DevOps Server collection with team projects Alpha and Beta,
+// which each have a repo called api. The body of each push and pull request is stored in posted
+// under the resource name when posted is non-nil.
+func newTestAzureServer(posted map[string]string) *httptest.Server {
+	const collection = "/tfs/DefaultCollection"
+	locations := `{"count": 6, "value": [
+		{"id": "e81700f7-3be2-46de-8624-2eb35882fcaa", "area": "Location", "resourceName": "ResourceAreas", "routeTemplate": "_apis/{resource}/{areaId}", "minVersion": "1.0", "maxVersion": "5.1", "releasedVersion": "5.1", "resourceVersion": 1},
+		{"id": "603fe2ac-9723-48b9-88ad-09305aa6c6e1", "area": "core", "resourceName": "projects", "routeTemplate": "_apis/{resource}/{*projectId}", "minVersion": "1.0", "maxVersion": "5.1", "releasedVersion": "5.1", "resourceVersion": 1},
+		{"id": "225f7195-f9c7-4d14-ab28-a83f7ff77e1f", "area": "git", "resourceName": "repositories", "routeTemplate": "{project}/_apis/{area}/{resource}/{repositoryId}", "minVersion": "1.0", "maxVersion": "5.1", "releasedVersion": "5.1", "resourceVersion": 1},
+		{"id": "2d874a60-a811-4f62-9c9f-963a6ea0a55b", "area": "git", "resourceName": "refs", "routeTemplate": "{project}/_apis/{area}/repositories/{repositoryId}/{resource}/{*filter}", "minVersion": "1.0", "maxVersion": "5.1", "releasedVersion": "5.1", "resourceVersion": 1},
+		{"id": "ea98d07b-3c87-4971-8ede-a613694ffb55", "area": "git", "resourceName": "pushes", "routeTemplate": "{project}/_apis/{area}/repositories/{repositoryId}/{resource}/{pushId}", "minVersion": "1.0", "maxVersion": "5.1", "releasedVersion": "5.1", "resourceVersion": 2},
+		{"id": "9946fd70-0d40-406e-b686-b4744cbbcc37", "area": "git", "resourceName": "pullRequests", "routeTemplate": "{project}/_apis/{area}/repositories/{repositoryId}/{resource}/{pullRequestId}", "minVersion": "1.0", "maxVersion": "5.1", "releasedVersion": "5.1", "resourceVersion": 1}
+	]}`
+	repos := map[string]string{
+		"Alpha": `{"count": 1, "value": [{"id": "11111111-1111-1111-1111-111111111111", "name": "api", "defaultBranch": "refs/heads/main", "webUrl": "https://ado.example.com/tfs/DefaultCollection/Alpha/_git/api", "project": {"name": "Alpha"}}]}`,
+		"Beta": `{"count": 2, "value": [
+			{"id": "22222222-2222-2222-2222-222222222222", "name": "api", "defaultBranch": "refs/heads/main", "project": {"name": "Beta"}},
+			{"id": "33333333-3333-3333-3333-333333333333", "name": "empty", "project": {"name": "Beta"}}
+		]}`,
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The SDK lowercases the collection url
+		if !strings.HasPrefix(strings.ToLower(r.URL.Path), strings.ToLower(collection)) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		path := r.URL.Path[len(collection):]
+		switch {
+		case r.Method == http.MethodOptions && strings.TrimSuffix(path, "/") == "/_apis":
+			fmt.Fprint(w, locations)
+		case path == "/_apis/ResourceAreas":
+			// On-prem servers have no resource areas, so the SDK stays on the collection url
+			fmt.Fprint(w, `{"count": 0, "value": []}`)
+		case path == "/_apis/projects":
+			fmt.Fprint(w, `{"count": 2, "value": [{"id": "aaaaaaaa-0000-0000-0000-000000000000", "name": "Alpha"}, {"id": "bbbbbbbb-0000-0000-0000-000000000000", "name": "Beta"}]}`)
+		case strings.HasSuffix(path, "/refs"):
+			fmt.Fprint(w, `{"count": 1, "value": [{"name": "refs/heads/main", "objectId": "c0ffeec0ffeec0ffeec0ffeec0ffeec0ffeec0ff"}]}`)
+		case strings.HasSuffix(path, "/pushes") || strings.HasSuffix(path, "/pullRequests"):
+			resource := path[strings.LastIndex(path, "/")+1:]
+			body, _ := io.ReadAll(r.Body)
+			if posted != nil {
+				posted[resource] = string(body)
+			}
+			w.WriteHeader(http.StatusCreated)
+			if resource == "pullRequests" {
+				fmt.Fprint(w, `{"pullRequestId": 9}`)
+				return
+			}
+			fmt.Fprint(w, `{"pushId": 1}`)
+		case strings.HasSuffix(path, "/_apis/git/repositories"):
+			project := strings.Split(strings.TrimPrefix(path, "/"), "/")[0]
+			switch project {
+			case "aaaaaaaa-0000-0000-0000-000000000000":
+				project = "Alpha"
+			case "bbbbbbbb-0000-0000-0000-000000000000":
+				project = "Beta"
+			}
+			if body, ok := repos[project]; ok {
+				fmt.Fprint(w, body)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestAzureClient_ListProjectsCollection(t *testing.T) {
+	server := newTestAzureServer(nil)
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		projects  string
+		wantNames []string
+	}{
+		{"all team projects", "", []string{"Alpha/api", "Beta/api", "Beta/empty"}},
+		{"scoped", "Beta", []string{"Beta/api", "Beta/empty"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAzureClient(&structs.ConfigThing{
+				VcsType:  "azure",
+				VcsToken: "testtoken",
+				Associated: map[string]string{
+					"azureUrl":      server.URL + "/tfs/DefaultCollection",
+					"azureProjects": tt.projects,
+				},
+			}, &structs.SyringeOptions{})
+
+			got, err := a.ListProjects()
+			if err != nil {
+				t.Errorf("ListProjects() error = %v", err)
+				return
+			}
+			var gotNames []string
+			for _, p := range *got {
+				gotNames = append(gotNames, p.Name)
+			}
+			if !reflect.DeepEqual(gotNames, tt.wantNames) {
+				t.Errorf("ListProjects() got = %v, want %v", gotNames, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestAzureClient_OpenChangeRequest(t *testing.T) {
+	posted := map[string]string{}
+	server := newTestAzureServer(posted)
+	defer server.Close()
+
+	a := NewAzureClient(&structs.ConfigThing{
+		VcsType:    "azure",
+		VcsToken:   "testtoken",
+		Associated: map[string]string{"azureUrl": server.URL + "/tfs/DefaultCollection", "azureProjects": "Alpha"},
+	}, &structs.SyringeOptions{})
+	projects, err := a.ListProjects()
+	if err != nil || len(*projects) != 1 {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+
+	got, err := a.OpenChangeRequest((*projects)[0].Id, &structs.ChangeRequest{
+		BaseBranch:    "main",
+		HeadBranch:    "syringe/phylum-ci",
+		Title:         "Add Phylum",
+		CommitMessage: "Add Phylum",
+		Files: []*structs.FileChange{
+			{Path: "azure-pipelines.yml", Content: []byte("steps:\n"), Previous: &structs.VcsFile{Path: "/azure-pipelines.yml"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("OpenChangeRequest() error = %v", err)
+	}
+	if got != "https://ado.example.com/tfs/DefaultCollection/Alpha/_git/api/pullrequest/9" {
+		t.Errorf("OpenChangeRequest() got = %v", got)
+	}
+
+	var push git.GitPush
+	if err := json.Unmarshal([]byte(posted["pushes"]), &push); err != nil {
+		t.Fatalf("OpenChangeRequest() push = %v: %v", posted["pushes"], err)
+	}
+	refUpdate := (*push.RefUpdates)[0]
+	commit := (*push.Commits)[0]
+	if *refUpdate.Name != "refs/heads/syringe/phylum-ci" || (*commit.Parents)[0] != "c0ffeec0ffeec0ffeec0ffeec0ffeec0ffeec0ff" {
+		t.Errorf("OpenChangeRequest() push = %v", posted["pushes"])
+	}
+	if !strings.Contains(posted["pushes"], `"changeType":"edit"`) || !strings.Contains(posted["pushes"], `"path":"/azure-pipelines.yml"`) {
+		t.Errorf("OpenChangeRequest() push changes = %v", posted["pushes"])
+	}
+	if !strings.Contains(posted["pullRequests"], `"sourceRefName":"refs/heads/syringe/phylum-ci"`) || !strings.Contains(posted["pullRequests"], `"targetRefName":"refs/heads/main"`) {
+		t.Errorf("OpenChangeRequest() pull request = %v", posted["pullRequests"])
+	}
+}
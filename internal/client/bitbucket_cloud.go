@@ -1,8 +1,16 @@
 package client
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -11,73 +19,263 @@ import (
 	"github.com/peterjmorgan/Syringe/internal/structs"
 	"github.com/peterjmorgan/Syringe/internal/utils"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/exp/slices"
 )
 
 type BitbucketCloudClient struct {
 	Client          *bitbucket.Client
 	Owner           string
-	ProjectMap      map[int64]*bitbucket.Repository
+	ProjectKeys     []string
+	Username        string
+	AppPassword     string
+	AccessToken     string
+	WebUrl          string
+	ProjectMap      map[string]*bitbucket.Repository
 	ProjectMapMutex sync.RWMutex
+	Skipped         []*structs.SkippedProject
+	rateLimiter     *utils.AdaptiveRateLimitTransport // paces requests by Bitbucket's X-RateLimit-* headers
+}
+
+// bitbucketCloudRepository is the subset of a repository listing Syringe reads
+type bitbucketCloudRepository struct {
+	Uuid       string `json:"uuid"`
+	Name       string `json:"name"`
+	Slug       string `json:"slug"`
+	FullName   string `json:"full_name"`
+	Mainbranch *struct {
+		Name string `json:"name"`
+	} `json:"mainbranch"`
+	Project *struct {
+		Key  string `json:"key"`
+		Name string `json:"name"`
+	} `json:"project"`
+}
+
+type bitbucketCloudPage struct {
+	Values []json.RawMessage `json:"values"`
+	Next   string            `json:"next"`
 }
 
 // func NewBitbucketCloudClient(envMap map[string]string, opts *structs.SyringeOptions) *BitbucketCloudClient {
 func NewBitbucketCloudClient(configData *structs.ConfigThing, opts *structs.SyringeOptions) *BitbucketCloudClient {
 	//token := envMap["vcsToken"]
 	//owner := "peter_morgan_"
+	var client *bitbucket.Client
+	accessToken := configData.Associated["bbAccessToken"]
+	username := configData.Associated["bbUsername"]
+	appPassword := configData.Associated["bbAppPassword"]
 
-	// Have to use Oauth2 client credentials config. Could not auth to the API any other way.
-	//client := bitbucket.NewOAuthClientCredentials("APbFeKnRHr2zBk6v6w", "qP2aBzrzQzmDUbnHnYLScStwxDuHQTFV")
-	client := bitbucket.NewOAuthClientCredentials(configData.Associated["bbClientId"], configData.Associated["bbClientSecret"])
+	switch {
+	case accessToken != "":
+		// Repository, project and workspace access tokens are sent as bearer tokens
+		client = bitbucket.NewOAuthbearerToken(accessToken)
+	case appPassword != "":
+		client = bitbucket.NewBasicAuth(username, appPassword)
+	default:
+		//client := bitbucket.NewOAuthClientCredentials("APbFeKnRHr2zBk6v6w", "qP2aBzrzQzmDUbnHnYLScStwxDuHQTFV")
+		client = bitbucket.NewOAuthClientCredentials(configData.Associated["bbClientId"], configData.Associated["bbClientSecret"])
+	}
+	// OAuth client credentials are exchanged for short-lived tokens, so the cache keys on the consumer
+	cacheIdentity := accessToken
+	if cacheIdentity == "" && appPassword != "" {
+		cacheIdentity = username + ":" + appPassword
+	} else if cacheIdentity == "" {
+		cacheIdentity = "oauth:" + configData.Associated["bbClientId"]
+	}
+	var rateLimiter *utils.AdaptiveRateLimitTransport
+	client.HttpClient, rateLimiter = newRateLimitedHttpClient(opts, cacheIdentity)
 
 	return &BitbucketCloudClient{
-		Client:     client,
-		Owner:      configData.Associated["bbOwner"],
-		ProjectMap: make(map[int64]*bitbucket.Repository, 0),
+		Client:      client,
+		Owner:       configData.Associated["bbOwner"],
+		ProjectKeys: utils.SplitList(configData.Associated["bbProjectKeys"]),
+		Username:    username,
+		AppPassword: appPassword,
+		AccessToken: accessToken,
+		WebUrl:      "https://bitbucket.org",
+		ProjectMap:  make(map[string]*bitbucket.Repository, 0),
+		rateLimiter: rateLimiter,
 	}
 }
 
+// authHeader returns the Authorization header value for whichever credential is configured
+func (b *BitbucketCloudClient) authHeader() string {
+	switch {
+	case b.AccessToken != "":
+		return fmt.Sprintf("Bearer %v", b.AccessToken)
+	case b.AppPassword != "":
+		return fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(b.Username+":"+b.AppPassword)))
+	default:
+		return fmt.Sprintf("Bearer %v", b.Client.GetOAuthToken().AccessToken)
+	}
+}
+
+// get sends an authenticated GET to an API URL and returns the response body
+func (b *BitbucketCloudClient) get(reqUrl string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, reqUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", b.authHeader())
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := b.Client.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v returned %v: %v", req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// getPaged GETs apiPath and every following page, calling handler with each value
+func (b *BitbucketCloudClient) getPaged(apiPath string, query url.Values, handler func(value json.RawMessage) error) error {
+	nextUrl := fmt.Sprintf("%v%v?%v", b.Client.GetApiBaseURL(), apiPath, query.Encode())
+	for nextUrl != "" {
+		body, err := b.get(nextUrl)
+		if err != nil {
+			return err
+		}
+
+		var page bitbucketCloudPage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		for _, value := range page.Values {
+			if err := handler(value); err != nil {
+				return err
+			}
+		}
+		nextUrl = page.Next
+	}
+	return nil
+}
+
+// listRepositories pages through every repo in the workspace, or every repo the user is a member
+// of when no workspace is set, optionally limited to the configured project keys
+func (b *BitbucketCloudClient) listRepositories() ([]*bitbucketCloudRepository, error) {
+	var retRepos []*bitbucketCloudRepository
+
+	query := url.Values{}
+	query.Set("pagelen", "100")
+	apiPath := "/repositories"
+	if b.Owner != "" {
+		apiPath = fmt.Sprintf("/repositories/%v", url.PathEscape(b.Owner))
+	} else {
+		query.Set("role", "member")
+	}
+	if len(b.ProjectKeys) > 0 {
+		var clauses []string
+		for _, key := range b.ProjectKeys {
+			clauses = append(clauses, fmt.Sprintf("project.key=%q", key))
+		}
+		query.Set("q", strings.Join(clauses, " OR "))
+	}
+
+	err := b.getPaged(apiPath, query, func(value json.RawMessage) error {
+		repo := new(bitbucketCloudRepository)
+		if err := json.Unmarshal(value, repo); err != nil {
+			return err
+		}
+		retRepos = append(retRepos, repo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return retRepos, nil
+}
+
 func (b *BitbucketCloudClient) ListProjects() (*[]*structs.SyringeProject, error) {
 	var retProjects []*structs.SyringeProject
+	b.Skipped = nil
 
-	repos, err := b.Client.Repositories.ListForAccount(&bitbucket.RepositoriesOptions{
-		Owner: b.Owner,
-		Role:  "member",
-	})
+	repos, err := b.listRepositories()
 	if err != nil {
 		errStr := fmt.Sprintf("BitBucket: failed to ListProjects: %v\n", err)
 		log.Error(errStr)
 		return nil, fmt.Errorf(errStr)
 	}
-	for _, item := range repos.Items {
-		uuidStr := item.Uuid
-		uuid := uuid.MustParse(uuidStr)
+	// Without an owner every workspace the user belongs to is listed, and slugs are only unique
+	// within a workspace
+	qualifyNames := b.Owner == ""
+	for _, item := range repos {
+		name := item.Slug
+		if qualifyNames {
+			name = item.FullName
+		}
+		uuid, err := uuid.Parse(item.Uuid)
 		if err != nil {
 			log.Errorf("uuid creation failed: %v\n", err)
+			continue
+		}
+
+		// Repos without any commits have no main branch
+		if item.Mainbranch == nil || item.Mainbranch.Name == "" {
+			log.Debugf("Skipping %v: no main branch\n", item.FullName)
+			b.Skipped = append(b.Skipped, &structs.SkippedProject{Name: name, Reason: "no main branch"})
+			continue
+		}
+
+		repo := &bitbucket.Repository{
+			Uuid:       item.Uuid,
+			Name:       item.Name,
+			Slug:       item.Slug,
+			Full_name:  item.FullName,
+			Mainbranch: bitbucket.RepositoryBranch{Name: item.Mainbranch.Name},
+		}
+		if item.Project != nil {
+			repo.Project = bitbucket.Project{Key: item.Project.Key, Name: item.Project.Name}
 		}
 
+		workspace := bitbucketCloudWorkspace(repo)
+		projectKey := structs.NewProjectKey("bitbucket_cloud", "bitbucket.org", workspace, uuid.String())
 		retProjects = append(retProjects, &structs.SyringeProject{
-			Id:        int64(uuid.ID()),
-			Name:      item.Slug,
+			Id:        projectKey,
+			Name:      name,
+			Owner:     workspace,
+			Namespace: repo.Project.Key,
 			Branch:    item.Mainbranch.Name,
 			Lockfiles: nil,
 			CiFiles:   nil,
 			Hydrated:  false,
 			GUID:      uuid,
 		})
-		temp := new(bitbucket.Repository)
-		*temp = item
-		b.ProjectMap[int64(uuid.ID())] = temp
+		b.ProjectMapMutex.Lock()
+		b.ProjectMap[projectKey] = repo
+		b.ProjectMapMutex.Unlock()
 	}
 
 	return &retProjects, nil
 }
 
-func (b *BitbucketCloudClient) ListFiles(repoSlug string, branch string) (*[]*bitbucket.RepositoryFile, error) {
+// SkippedProjects returns the repos the last ListProjects left out
+func (b *BitbucketCloudClient) SkippedProjects() []*structs.SkippedProject {
+	return b.Skipped
+}
+
+// RateLimiter returns the limiter pacing the client's requests
+func (b *BitbucketCloudClient) RateLimiter() *utils.AdaptiveRateLimitTransport {
+	return b.rateLimiter
+}
+
+// bitbucketCloudWorkspace returns the workspace slug from the repo's workspace/slug full name
+func bitbucketCloudWorkspace(repo *bitbucket.Repository) string {
+	workspace, _, _ := strings.Cut(repo.Full_name, "/")
+	return workspace
+}
+
+func (b *BitbucketCloudClient) ListFiles(workspace string, repoSlug string, branch string) (*[]*bitbucket.RepositoryFile, error) {
 	var retFiles []*bitbucket.RepositoryFile
 
 	files, err := b.Client.Repositories.Repository.ListFiles(&bitbucket.RepositoryFilesOptions{
-		Owner:    b.Owner,
+		Owner:    workspace,
 		RepoSlug: repoSlug,
 		Ref:      branch,
 		Path:     "/",
@@ -102,27 +300,36 @@ func (b *BitbucketCloudClient) ListFiles(repoSlug string, branch string) (*[]*bi
 	return &retFiles, nil
 }
 
-func (b *BitbucketCloudClient) GetLockfilesByProject(projectId int64, mainBranchName string) ([]*structs.VcsFile, error) {
-	var retLockfiles []*structs.VcsFile
+func (b *BitbucketCloudClient) GetLockfilesByProject(projectId string, mainBranchName string) ([]*structs.VcsFile, error) {
+	return b.GetFilesByProject(projectId, mainBranchName, utils.IsLockfile)
+}
+
+// GetFilesByProject fetches every file on the branch whose path satisfies match
+func (b *BitbucketCloudClient) GetFilesByProject(projectId string, mainBranchName string, match func(string) bool) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
 
 	b.ProjectMapMutex.RLock()
-	repo := b.ProjectMap[projectId]
+	repo, ok := b.ProjectMap[projectId]
 	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("BitBucket: unknown project ID %v", projectId)
+	}
+	workspace := bitbucketCloudWorkspace(repo)
 
-	projectFiles, err := b.ListFiles(repo.Name, repo.Mainbranch.Name)
+	// Bitbucket resolves tag names wherever it takes a ref
+	refName, _ := utils.SplitRef(mainBranchName)
+	projectFiles, err := b.ListFiles(workspace, repo.Slug, refName)
 	if err != nil {
 		errStr := fmt.Sprintf("BitBucket: failed to GetLockfilesByProject for %v: %v\n", repo.Name, err)
 		log.Error(errStr)
 		return nil, fmt.Errorf(errStr)
 	}
 
-	supportedLockfiles := utils.GetSupportedLockfiles()
-
 	for _, file := range *projectFiles {
 		// var filePath string
 		fileName := filepath.Base(file.Path)
-		if slices.Contains(supportedLockfiles, fileName) || strings.HasSuffix(fileName, ".csproj") {
-			log.Debugf("Lockfile: %v in %v from project: %v\n", fileName, file.Path, repo.Name)
+		if match(file.Path) {
+			log.Debugf("File: %v in %v from project: %v\n", fileName, file.Path, repo.Name)
 			// download te file
 
 			// if !strings.Contains(file.Path, "/") {
@@ -132,9 +339,9 @@ func (b *BitbucketCloudClient) GetLockfilesByProject(projectId int64, mainBranch
 			// }
 
 			content, err := b.Client.Repositories.Repository.GetFileBlob(&bitbucket.RepositoryBlobOptions{
-				Owner:    b.Owner,
-				RepoSlug: repo.Name,
-				Ref:      mainBranchName,
+				Owner:    workspace,
+				RepoSlug: repo.Slug,
+				Ref:      refName,
 				Path:     file.Path,
 			})
 
@@ -151,10 +358,10 @@ func (b *BitbucketCloudClient) GetLockfilesByProject(projectId int64, mainBranch
 				return nil, fmt.Errorf(errStr)
 			}
 
-			retLockfiles = append(retLockfiles, &structs.VcsFile{
+			retFiles = append(retFiles, &structs.VcsFile{
 				Name:          fileName,
 				Path:          file.Path,
-				Id:            file.Path,
+				Id:            gitBlobSHA(content.Content),
 				Content:       content.Content,
 				PhylumProject: nil,
 			})
@@ -162,5 +369,314 @@ func (b *BitbucketCloudClient) GetLockfilesByProject(projectId int64, mainBranch
 		}
 	}
 
-	return retLockfiles, nil
+	return retFiles, nil
+}
+
+// GetHead returns the commit hash the branch points at
+func (b *BitbucketCloudClient) GetHead(projectId string, branch string) (string, error) {
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("BitBucket: unknown project ID %v", projectId)
+	}
+
+	body, err := b.get(fmt.Sprintf("%v/repositories/%v/%v/refs/branches/%v", b.Client.GetApiBaseURL(), url.PathEscape(bitbucketCloudWorkspace(repo)), url.PathEscape(repo.Slug), url.PathEscape(branch)))
+	if err != nil {
+		log.Errorf("BitBucket: failed to get branch %v of %v: %v\n", branch, repo.Slug, err)
+		return "", err
+	}
+	var ref struct {
+		Target struct {
+			Hash string `json:"hash"`
+		} `json:"target"`
+	}
+	if err := json.Unmarshal(body, &ref); err != nil {
+		return "", err
+	}
+	return ref.Target.Hash, nil
+}
+
+// ListRefs lists the names of a repo's branches and tags
+func (b *BitbucketCloudClient) ListRefs(projectId string) ([]string, []string, error) {
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("BitBucket: unknown project ID %v", projectId)
+	}
+	repoPath := fmt.Sprintf("/repositories/%v/%v", url.PathEscape(bitbucketCloudWorkspace(repo)), url.PathEscape(repo.Slug))
+
+	branches, err := b.listRefNames(repoPath + "/refs/branches")
+	if err != nil {
+		log.Errorf("BitBucket: failed to list branches for %v: %v\n", repo.Slug, err)
+		return nil, nil, err
+	}
+	tags, err := b.listRefNames(repoPath + "/refs/tags")
+	if err != nil {
+		log.Errorf("BitBucket: failed to list tags for %v: %v\n", repo.Slug, err)
+		return nil, nil, err
+	}
+	return branches, tags, nil
+}
+
+// listRefNames returns the name of every ref from a refs/branches or refs/tags listing
+func (b *BitbucketCloudClient) listRefNames(apiPath string) ([]string, error) {
+	var names []string
+	err := b.getPaged(apiPath, url.Values{"pagelen": {"100"}}, func(value json.RawMessage) error {
+		var ref struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(value, &ref); err != nil {
+			return err
+		}
+		names = append(names, ref.Name)
+		return nil
+	})
+	return names, err
+}
+
+// ListPullRequests lists a repo's open pull requests
+func (b *BitbucketCloudClient) ListPullRequests(projectId string) ([]*structs.PullRequest, error) {
+	var retPullRequests []*structs.PullRequest
+
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("BitBucket: unknown project ID %v", projectId)
+	}
+	repoPath := fmt.Sprintf("/repositories/%v/%v", url.PathEscape(bitbucketCloudWorkspace(repo)), url.PathEscape(repo.Slug))
+
+	err := b.getPaged(repoPath+"/pullrequests", url.Values{"state": {"OPEN"}, "pagelen": {"50"}}, func(value json.RawMessage) error {
+		var pullRequest struct {
+			Id     int    `json:"id"`
+			Title  string `json:"title"`
+			Source struct {
+				Branch struct {
+					Name string `json:"name"`
+				} `json:"branch"`
+				Commit struct {
+					Hash string `json:"hash"`
+				} `json:"commit"`
+				Repository struct {
+					FullName string `json:"full_name"`
+				} `json:"repository"`
+			} `json:"source"`
+			Links struct {
+				Html struct {
+					Href string `json:"href"`
+				} `json:"html"`
+			} `json:"links"`
+		}
+		if err := json.Unmarshal(value, &pullRequest); err != nil {
+			return err
+		}
+		retPullRequests = append(retPullRequests, &structs.PullRequest{
+			Id:         strconv.Itoa(pullRequest.Id),
+			Title:      pullRequest.Title,
+			Url:        pullRequest.Links.Html.Href,
+			HeadBranch: pullRequest.Source.Branch.Name,
+			HeadSha:    pullRequest.Source.Commit.Hash,
+			HeadRepo:   pullRequest.Source.Repository.FullName,
+		})
+		return nil
+	})
+	if err != nil {
+		log.Errorf("BitBucket: failed to list pull requests for %v: %v\n", repo.Slug, err)
+		return nil, err
+	}
+	return retPullRequests, nil
+}
+
+// GetPullRequestLockfiles fetches the lockfiles a pull request adds or modifies at its head commit,
+// from the fork when the pull request comes from one
+func (b *BitbucketCloudClient) GetPullRequestLockfiles(projectId string, pullRequest *structs.PullRequest) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("BitBucket: unknown project ID %v", projectId)
+	}
+	workspace := bitbucketCloudWorkspace(repo)
+	repoPath := fmt.Sprintf("/repositories/%v/%v", url.PathEscape(workspace), url.PathEscape(repo.Slug))
+
+	var paths []string
+	err := b.getPaged(fmt.Sprintf("%v/pullrequests/%v/diffstat", repoPath, pullRequest.Id), url.Values{"pagelen": {"100"}}, func(value json.RawMessage) error {
+		var diffstat struct {
+			Status string `json:"status"`
+			New    *struct {
+				Path string `json:"path"`
+			} `json:"new"`
+		}
+		if err := json.Unmarshal(value, &diffstat); err != nil {
+			return err
+		}
+		if diffstat.Status != "removed" && diffstat.New != nil && utils.IsLockfile(diffstat.New.Path) {
+			paths = append(paths, diffstat.New.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("BitBucket: failed to get diffstat of pull request %v in %v: %v\n", pullRequest.Id, repo.Slug, err)
+		return nil, err
+	}
+
+	headWorkspace, headSlug := workspace, repo.Slug
+	if owner, slug, found := strings.Cut(pullRequest.HeadRepo, "/"); found {
+		headWorkspace, headSlug = owner, slug
+	}
+	for _, path := range paths {
+		content, err := b.Client.Repositories.Repository.GetFileBlob(&bitbucket.RepositoryBlobOptions{
+			Owner:    headWorkspace,
+			RepoSlug: headSlug,
+			Ref:      pullRequest.HeadSha,
+			Path:     path,
+		})
+		if err != nil {
+			log.Errorf("BitBucket: failed to GetFileBlob for %v at %v: %v\n", path, pullRequest.HeadSha, err)
+			return nil, err
+		}
+		retFiles = append(retFiles, &structs.VcsFile{
+			Name:    filepath.Base(path),
+			Path:    path,
+			Id:      pullRequest.HeadSha,
+			Content: content.Content,
+		})
+	}
+	return retFiles, nil
+}
+
+// webCredentials returns the basic auth credentials bitbucket.org takes for git and downloads
+func (b *BitbucketCloudClient) webCredentials() (string, string) {
+	switch {
+	case b.AccessToken != "":
+		return "x-token-auth", b.AccessToken
+	case b.AppPassword != "":
+		return b.Username, b.AppPassword
+	default:
+		return "x-token-auth", b.Client.GetOAuthToken().AccessToken
+	}
+}
+
+func (b *BitbucketCloudClient) GetCloneTarget(projectId string) (*CloneTarget, error) {
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("BitBucket: unknown project ID %v", projectId)
+	}
+
+	return &CloneTarget{
+		Url:        fmt.Sprintf("%v/%v.git", b.WebUrl, repo.Full_name),
+		AuthHeader: basicAuthHeader(b.webCredentials()),
+	}, nil
+}
+
+// DownloadArchive downloads a tarball of the ref. The API has no archive endpoint, so this uses
+// the website's download link.
+func (b *BitbucketCloudClient) DownloadArchive(projectId string, ref string) (*Archive, error) {
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("BitBucket: unknown project ID %v", projectId)
+	}
+
+	refName, _ := utils.SplitRef(ref)
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v/%v/get/%v.tar.gz", b.WebUrl, repo.Full_name, url.PathEscape(refName)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(b.webCredentials())
+
+	resp, err := b.Client.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("BitBucket: GET archive of %v returned %v", repo.Full_name, resp.Status)
+	}
+	return &Archive{Body: resp.Body, Format: ArchiveTarGz, StripPrefix: true}, nil
+}
+
+// post sends an authenticated POST to the API and returns the response body
+func (b *BitbucketCloudClient) post(apiPath string, contentType string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%v%v", b.Client.GetApiBaseURL(), apiPath), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", b.authHeader())
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := b.Client.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("POST %v returned %v: %v", apiPath, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+// OpenChangeRequest commits every file to a new branch through the src endpoint and opens a pull
+// request. Bitbucket creates the branch from the repo's main branch.
+func (b *BitbucketCloudClient) OpenChangeRequest(projectId string, change *structs.ChangeRequest) (string, error) {
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("BitBucket: unknown project ID %v", projectId)
+	}
+	repoPath := fmt.Sprintf("/repositories/%v/%v", url.PathEscape(bitbucketCloudWorkspace(repo)), url.PathEscape(repo.Slug))
+
+	// Each form field named after a path becomes that file's content
+	form := url.Values{}
+	form.Set("message", change.CommitMessage)
+	form.Set("branch", change.HeadBranch)
+	for _, file := range change.Files {
+		form.Set("/"+strings.TrimPrefix(file.Path, "/"), string(file.Content))
+	}
+	if _, err := b.post(repoPath+"/src", "application/x-www-form-urlencoded", strings.NewReader(form.Encode())); err != nil {
+		log.Errorf("BitBucket: failed to commit to %v: %v\n", repo.Slug, err)
+		return "", err
+	}
+
+	pullRequest := map[string]interface{}{
+		"title":               change.Title,
+		"description":         change.Body,
+		"source":              map[string]interface{}{"branch": map[string]string{"name": change.HeadBranch}},
+		"destination":         map[string]interface{}{"branch": map[string]string{"name": change.BaseBranch}},
+		"close_source_branch": true,
+	}
+	reqBody, err := json.Marshal(pullRequest)
+	if err != nil {
+		return "", err
+	}
+	respBody, err := b.post(repoPath+"/pullrequests", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		log.Errorf("BitBucket: failed to create pull request in %v: %v\n", repo.Slug, err)
+		return "", err
+	}
+
+	var created struct {
+		Links struct {
+			Html struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	}
+	if err := json.Unmarshal(respBody, &created); err != nil {
+		return "", err
+	}
+	return created.Links.Html.Href, nil
 }
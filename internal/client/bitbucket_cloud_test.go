@@ -1,10 +1,14 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/ktrysmt/go-bitbucket"
 	"github.com/peterjmorgan/Syringe/internal/structs"
 	"github.com/peterjmorgan/Syringe/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"testing"
 )
@@ -45,8 +49,9 @@ func TestBitbucketCloudClient_ListFiles(t *testing.T) {
 	b := NewBitbucketCloudClient(configData, &testingSyringeOpts)
 
 	type args struct {
-		repoSlug string
-		branch   string
+		workspace string
+		repoSlug  string
+		branch    string
 	}
 	tests := []struct {
 		name    string
@@ -55,11 +60,11 @@ func TestBitbucketCloudClient_ListFiles(t *testing.T) {
 		wantLen int
 		wantErr bool
 	}{
-		{"one", args{"beta", "master"}, nil, 4, false},
+		{"one", args{b.Owner, "beta", "master"}, nil, 4, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := b.ListFiles(tt.args.repoSlug, tt.args.branch)
+			got, err := b.ListFiles(tt.args.workspace, tt.args.repoSlug, tt.args.branch)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ListFiles() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -82,10 +87,16 @@ func TestBitbucketCloudClient_GetLockfilesByProject(t *testing.T) {
 	}
 	b := NewBitbucketCloudClient(configData, &testingSyringeOpts)
 
-	_, _ = b.ListProjects()
+	projects, _ := b.ListProjects()
+	projectIds := map[string]string{}
+	if projects != nil {
+		for _, p := range *projects {
+			projectIds[p.Name] = p.Id
+		}
+	}
 
 	type args struct {
-		projectId      int64
+		projectId      string
 		mainBranchName string
 	}
 	tests := []struct {
@@ -95,7 +106,7 @@ func TestBitbucketCloudClient_GetLockfilesByProject(t *testing.T) {
 		wantLen int
 		wantErr bool
 	}{
-		{"one", args{3407435279, "master"}, nil, 2, false},
+		{"one", args{projectIds["test-repo-1"], "master"}, nil, 2, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -114,3 +125,225 @@ func TestBitbucketCloudClient_GetLockfilesByProject(t *testing.T) {
 		})
 	}
 }
+
+func TestBitbucketCloudClient_ListProjectsPaged(t *testing.T) {
+	var gotQueries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer testtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		gotQueries = append(gotQueries, r.URL.Query())
+		switch r.URL.Path {
+		case "/repositories/acme":
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `{"values": [
+					{"uuid": "{22222222-2222-2222-2222-222222222222}", "name": "Web", "slug": "web", "full_name": "acme/web", "mainbranch": {"name": "main"}, "project": {"key": "PLAT"}},
+					{"uuid": "{33333333-3333-3333-3333-333333333333}", "name": "Empty", "slug": "empty", "full_name": "acme/empty", "mainbranch": null, "project": {"key": "PLAT"}}
+				]}`)
+				return
+			}
+			fmt.Fprintf(w, `{"values": [
+				{"uuid": "{11111111-1111-1111-1111-111111111111}", "name": "API", "slug": "api", "full_name": "acme/api", "mainbranch": {"name": "master"}, "project": {"key": "PLAT"}}
+			], "next": "http://%v/repositories/acme?page=2"}`, r.Host)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	b := NewBitbucketCloudClient(&structs.ConfigThing{
+		VcsType: "bitbucket_cloud",
+		Associated: map[string]string{
+			"bbOwner":       "acme",
+			"bbAccessToken": "testtoken",
+			"bbProjectKeys": "PLAT, OPS",
+		},
+	}, &structs.SyringeOptions{})
+	baseUrl, _ := url.Parse(server.URL)
+	b.Client.SetApiBaseURL(*baseUrl)
+
+	got, err := b.ListProjects()
+	if err != nil {
+		t.Errorf("ListProjects() error = %v", err)
+		return
+	}
+
+	var gotNames []string
+	for _, p := range *got {
+		gotNames = append(gotNames, fmt.Sprintf("%v/%v@%v", p.Owner, p.Name, p.Branch))
+	}
+	wantNames := []string{"acme/api@master", "acme/web@main"}
+	if !reflect.DeepEqual(gotNames, wantNames) {
+		t.Errorf("ListProjects() got = %v, want %v", gotNames, wantNames)
+	}
+	if skipped := b.SkippedProjects(); len(skipped) != 1 || skipped[0].Name != "empty" {
+		t.Errorf("SkippedProjects() got = %v, want empty", skipped)
+	}
+	if len(gotQueries) == 0 || gotQueries[0].Get("q") != `project.key="PLAT" OR project.key="OPS"` || gotQueries[0].Get("role") != "" {
+		t.Errorf("ListProjects() query got = %v", gotQueries)
+	}
+}
+
+func TestBitbucketCloudClient_ListProjectsMember(t *testing.T) {
+	var gotQueries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQueries = append(gotQueries, r.URL.Query())
+		if r.URL.Path != "/repositories" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"values": [
+			{"uuid": "{11111111-1111-1111-1111-111111111111}", "name": "API", "slug": "api", "full_name": "acme/api", "mainbranch": {"name": "main"}},
+			{"uuid": "{44444444-4444-4444-4444-444444444444}", "name": "API", "slug": "api", "full_name": "globex/api", "mainbranch": {"name": "main"}}
+		]}`)
+	}))
+	defer server.Close()
+
+	b := NewBitbucketCloudClient(&structs.ConfigThing{
+		VcsType:    "bitbucket_cloud",
+		Associated: map[string]string{"bbAccessToken": "testtoken"},
+	}, &structs.SyringeOptions{})
+	baseUrl, _ := url.Parse(server.URL)
+	b.Client.SetApiBaseURL(*baseUrl)
+
+	got, err := b.ListProjects()
+	if err != nil {
+		t.Errorf("ListProjects() error = %v", err)
+		return
+	}
+
+	// Repos with the same slug in different workspaces get different names
+	var gotNames []string
+	for _, p := range *got {
+		gotNames = append(gotNames, fmt.Sprintf("%v:%v", p.Owner, p.Name))
+	}
+	wantNames := []string{"acme:acme/api", "globex:globex/api"}
+	if !reflect.DeepEqual(gotNames, wantNames) {
+		t.Errorf("ListProjects() got = %v, want %v", gotNames, wantNames)
+	}
+	if len(gotQueries) == 0 || gotQueries[0].Get("role") != "member" {
+		t.Errorf("ListProjects() query got = %v", gotQueries)
+	}
+}
+
+func TestBitbucketCloudClient_OpenChangeRequest(t *testing.T) {
+	var gotForm url.Values
+	var gotPullRequest map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer testtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/repositories/acme":
+			fmt.Fprint(w, `{"values": [
+				{"uuid": "{11111111-1111-1111-1111-111111111111}", "name": "API", "slug": "api", "full_name": "acme/api", "mainbranch": {"name": "main"}}
+			]}`)
+		case "/repositories/acme/api/src":
+			r.ParseForm()
+			gotForm = r.PostForm
+			w.WriteHeader(http.StatusCreated)
+		case "/repositories/acme/api/pullrequests":
+			json.NewDecoder(r.Body).Decode(&gotPullRequest)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id": 5, "links": {"html": {"href": "https://bitbucket.example.com/acme/api/pull-requests/5"}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	b := NewBitbucketCloudClient(&structs.ConfigThing{
+		VcsType:    "bitbucket_cloud",
+		Associated: map[string]string{"bbOwner": "acme", "bbAccessToken": "testtoken"},
+	}, &structs.SyringeOptions{})
+	baseUrl, _ := url.Parse(server.URL)
+	b.Client.SetApiBaseURL(*baseUrl)
+
+	projects, err := b.ListProjects()
+	if err != nil || len(*projects) != 1 {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+	got, err := b.OpenChangeRequest((*projects)[0].Id, &structs.ChangeRequest{
+		BaseBranch:    "main",
+		HeadBranch:    "syringe/phylum-ci",
+		Title:         "Add Phylum",
+		CommitMessage: "Add Phylum",
+		Files:         []*structs.FileChange{{Path: "bitbucket-pipelines.yml", Content: []byte("pipelines:\n")}},
+	})
+	if err != nil {
+		t.Fatalf("OpenChangeRequest() error = %v", err)
+	}
+	if got != "https://bitbucket.example.com/acme/api/pull-requests/5" {
+		t.Errorf("OpenChangeRequest() got = %v", got)
+	}
+
+	if gotForm.Get("branch") != "syringe/phylum-ci" || gotForm.Get("/bitbucket-pipelines.yml") != "pipelines:\n" {
+		t.Errorf("OpenChangeRequest() commit form = %v", gotForm)
+	}
+	gotSource := gotPullRequest["source"].(map[string]interface{})["branch"].(map[string]interface{})["name"]
+	gotDestination := gotPullRequest["destination"].(map[string]interface{})["branch"].(map[string]interface{})["name"]
+	if gotSource != "syringe/phylum-ci" || gotDestination != "main" {
+		t.Errorf("OpenChangeRequest() pull request = %v", gotPullRequest)
+	}
+}
+
+func TestBitbucketCloudClient_PullRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repositories/acme":
+			fmt.Fprint(w, `{"values": [
+				{"uuid": "{11111111-1111-1111-1111-111111111111}", "name": "API", "slug": "api", "full_name": "acme/api", "mainbranch": {"name": "main"}}
+			]}`)
+		case "/repositories/acme/api/pullrequests":
+			if r.URL.Query().Get("state") != "OPEN" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"values": [{"id": 9, "title": "Bump requests", "links": {"html": {"href": "https://bitbucket.example.com/acme/api/pull-requests/9"}},
+				"source": {"branch": {"name": "bump"}, "commit": {"hash": "fea7"}, "repository": {"full_name": "fork/api"}}}]}`)
+		case "/repositories/acme/api/pullrequests/9/diffstat":
+			fmt.Fprint(w, `{"values": [
+				{"status": "modified", "new": {"path": "poetry.lock"}},
+				{"status": "removed", "old": {"path": "yarn.lock"}},
+				{"status": "added", "new": {"path": "main.py"}}
+			]}`)
+		case "/repositories/fork/api/src/fea7/poetry.lock":
+			fmt.Fprint(w, "[[package]]\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	b := NewBitbucketCloudClient(&structs.ConfigThing{
+		VcsType:    "bitbucket_cloud",
+		Associated: map[string]string{"bbOwner": "acme", "bbAccessToken": "testtoken"},
+	}, &structs.SyringeOptions{})
+	baseUrl, _ := url.Parse(server.URL)
+	b.Client.SetApiBaseURL(*baseUrl)
+
+	projects, err := b.ListProjects()
+	if err != nil || len(*projects) != 1 {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+	projectId := (*projects)[0].Id
+
+	got, err := b.ListPullRequests(projectId)
+	if err != nil {
+		t.Fatalf("ListPullRequests() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Id != "9" || got[0].HeadSha != "fea7" || got[0].HeadRepo != "fork/api" {
+		t.Fatalf("ListPullRequests() got = %v", got)
+	}
+
+	// The head version is read from the fork
+	lockfiles, err := b.GetPullRequestLockfiles(projectId, got[0])
+	if err != nil {
+		t.Fatalf("GetPullRequestLockfiles() error = %v", err)
+	}
+	if len(lockfiles) != 1 || lockfiles[0].Path != "poetry.lock" || string(lockfiles[0].Content) != "[[package]]\n" {
+		t.Errorf("GetPullRequestLockfiles() got = %v", lockfiles)
+	}
+}
@@ -0,0 +1,513 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const bitbucketServerPageSize = 100
+
+type BitbucketServerClient struct {
+	Client          *http.Client
+	BaseUrl         string
+	Token           string
+	ProjectMap      map[string]*BitbucketServerRepository
+	ProjectMapMutex sync.RWMutex
+	rateLimiter     *utils.AdaptiveRateLimitTransport // paces requests by the X-RateLimit-* headers and Retry-After
+}
+
+// BitbucketServerPage is the envelope every paged REST 1.0 response is wrapped in
+type BitbucketServerPage struct {
+	Values        json.RawMessage `json:"values"`
+	IsLastPage    bool            `json:"isLastPage"`
+	NextPageStart int             `json:"nextPageStart"`
+}
+
+type BitbucketServerProject struct {
+	Id   int64  `json:"id"`
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+type BitbucketServerRepository struct {
+	Id            int64                  `json:"id"`
+	Slug          string                 `json:"slug"`
+	Name          string                 `json:"name"`
+	Project       BitbucketServerProject `json:"project"`
+	DefaultBranch string                 `json:"-"`
+}
+
+type BitbucketServerBranch struct {
+	Id        string `json:"id"`
+	DisplayId string `json:"displayId"`
+}
+
+func NewBitbucketServerClient(configData *structs.ConfigThing, opts *structs.SyringeOptions) *BitbucketServerClient {
+	baseUrl := strings.TrimSuffix(configData.Associated["bbServerUrl"], "/")
+	if baseUrl == "" {
+		log.Fatalf("NewBitbucketServerClient: 'bbServerUrl' is not configured\n")
+	}
+
+	httpClient, rateLimiter := newRateLimitedHttpClient(opts, configData.VcsToken)
+	return &BitbucketServerClient{
+		Client:      httpClient,
+		BaseUrl:     baseUrl,
+		Token:       configData.VcsToken,
+		ProjectMap:  make(map[string]*BitbucketServerRepository, 0),
+		rateLimiter: rateLimiter,
+	}
+}
+
+// RateLimiter returns the limiter pacing the client's requests
+func (b *BitbucketServerClient) RateLimiter() *utils.AdaptiveRateLimitTransport {
+	return b.rateLimiter
+}
+
+// newRequest builds an authenticated GET against the Bitbucket Server REST API
+func (b *BitbucketServerClient) newRequest(apiPath string, query url.Values) (*http.Request, error) {
+	reqUrl := fmt.Sprintf("%v/rest/api/1.0%v", b.BaseUrl, apiPath)
+	if len(query) > 0 {
+		reqUrl = fmt.Sprintf("%v?%v", reqUrl, query.Encode())
+	}
+
+	req, err := http.NewRequest(http.MethodGet, reqUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", b.Token))
+	return req, nil
+}
+
+// get performs an authenticated GET against the Bitbucket Server REST API and returns the response body
+func (b *BitbucketServerClient) get(apiPath string, query url.Values) ([]byte, int, error) {
+	req, err := b.newRequest(apiPath, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("GET %v returned %v", apiPath, resp.Status)
+	}
+	return body, resp.StatusCode, nil
+}
+
+// getPaged follows isLastPage/nextPageStart, handing each page's values to handler
+func (b *BitbucketServerClient) getPaged(apiPath string, query url.Values, handler func(values json.RawMessage) error) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("limit", fmt.Sprintf("%v", bitbucketServerPageSize))
+	start := 0
+
+	for {
+		query.Set("start", fmt.Sprintf("%v", start))
+		body, _, err := b.get(apiPath, query)
+		if err != nil {
+			return err
+		}
+
+		var page BitbucketServerPage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		if err := handler(page.Values); err != nil {
+			return err
+		}
+
+		if page.IsLastPage {
+			break
+		}
+		start = page.NextPageStart
+		log.Debugf("BitbucketServer: %v paging to start=%v\n", apiPath, start)
+	}
+	return nil
+}
+
+func (b *BitbucketServerClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	var bbProjects []BitbucketServerProject
+	var retProjects []*structs.SyringeProject
+
+	// Projects are containers of repositories in Bitbucket Server
+	err := b.getPaged("/projects", nil, func(values json.RawMessage) error {
+		var page []BitbucketServerProject
+		if err := json.Unmarshal(values, &page); err != nil {
+			return err
+		}
+		bbProjects = append(bbProjects, page...)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("BitbucketServer: failed to list projects: %v\n", err)
+		return nil, err
+	}
+
+	for _, proj := range bbProjects {
+		var repos []BitbucketServerRepository
+		reposPath := fmt.Sprintf("/projects/%v/repos", url.PathEscape(proj.Key))
+		err := b.getPaged(reposPath, nil, func(values json.RawMessage) error {
+			var page []BitbucketServerRepository
+			if err := json.Unmarshal(values, &page); err != nil {
+				return err
+			}
+			repos = append(repos, page...)
+			return nil
+		})
+		if err != nil {
+			log.Errorf("BitbucketServer: failed to list repos for project %v: %v\n", proj.Key, err)
+			return nil, err
+		}
+
+		for _, repo := range repos {
+			repo.DefaultBranch = b.GetDefaultBranch(proj.Key, repo.Slug)
+
+			projectKey := structs.NewProjectKey("bitbucket_server", utils.UrlHost(b.BaseUrl), proj.Key, strconv.FormatInt(repo.Id, 10))
+			retProjects = append(retProjects, &structs.SyringeProject{
+				Id:        projectKey,
+				Name:      fmt.Sprintf("%v/%v", proj.Key, repo.Slug), // slugs are only unique within a project
+				Namespace: proj.Key,
+				Branch:    repo.DefaultBranch,
+				Lockfiles: nil,
+				CiFiles:   nil,
+				Hydrated:  false,
+			})
+			temp := new(BitbucketServerRepository)
+			*temp = repo
+			b.ProjectMapMutex.Lock()
+			b.ProjectMap[projectKey] = temp
+			b.ProjectMapMutex.Unlock()
+		}
+	}
+
+	return &retProjects, nil
+}
+
+// GetDefaultBranch returns the display name of the default branch, or "" for empty repositories
+func (b *BitbucketServerClient) GetDefaultBranch(projectKey string, repoSlug string) string {
+	branchPath := fmt.Sprintf("/projects/%v/repos/%v/branches/default", url.PathEscape(projectKey), url.PathEscape(repoSlug))
+	body, _, err := b.get(branchPath, nil)
+	if err != nil {
+		log.Debugf("BitbucketServer: no default branch for %v/%v: %v\n", projectKey, repoSlug, err)
+		return ""
+	}
+
+	var branch BitbucketServerBranch
+	if err := json.Unmarshal(body, &branch); err != nil {
+		log.Debugf("BitbucketServer: failed to parse default branch for %v/%v: %v\n", projectKey, repoSlug, err)
+		return ""
+	}
+	return branch.DisplayId
+}
+
+func (b *BitbucketServerClient) ListFiles(projectKey string, repoSlug string, branch string) ([]string, error) {
+	var retFiles []string
+
+	filesPath := fmt.Sprintf("/projects/%v/repos/%v/files", url.PathEscape(projectKey), url.PathEscape(repoSlug))
+	query := url.Values{}
+	query.Set("at", branch)
+
+	err := b.getPaged(filesPath, query, func(values json.RawMessage) error {
+		var page []string
+		if err := json.Unmarshal(values, &page); err != nil {
+			return err
+		}
+		retFiles = append(retFiles, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("BitbucketServer: failed to ListFiles for %v/%v: %v", projectKey, repoSlug, err)
+	}
+
+	return retFiles, nil
+}
+
+func (b *BitbucketServerClient) GetLockfilesByProject(projectId string, mainBranchName string) ([]*structs.VcsFile, error) {
+	return b.GetFilesByProject(projectId, mainBranchName, utils.IsLockfile)
+}
+
+// GetFilesByProject fetches every file on the branch whose path satisfies match
+func (b *BitbucketServerClient) GetFilesByProject(projectId string, mainBranchName string, match func(string) bool) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("BitbucketServer: unknown project ID %v", projectId)
+	}
+
+	if mainBranchName == "" {
+		log.Debugf("BitbucketServer: skipping %v/%v, no default branch\n", repo.Project.Key, repo.Slug)
+		return nil, nil
+	}
+
+	projectFiles, err := b.ListFiles(repo.Project.Key, repo.Slug, mainBranchName)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	for _, filePath := range projectFiles {
+		fileName := filepath.Base(filePath)
+		if match(filePath) {
+			log.Debugf("File: %v in %v from project: %v\n", fileName, filePath, repo.Slug)
+
+			rawPath := fmt.Sprintf("/projects/%v/repos/%v/raw/%v", url.PathEscape(repo.Project.Key), url.PathEscape(repo.Slug), utils.EscapePath(filePath))
+			query := url.Values{}
+			query.Set("at", mainBranchName)
+			content, _, err := b.get(rawPath, query)
+			if err != nil {
+				log.Errorf("BitbucketServer: failed to get raw file %v in %v: %v\n", filePath, repo.Slug, err)
+				return nil, err
+			}
+
+			retFiles = append(retFiles, &structs.VcsFile{
+				Name:          fileName,
+				Path:          filePath,
+				Id:            gitBlobSHA(content),
+				Content:       content,
+				PhylumProject: nil,
+			})
+		}
+	}
+
+	return retFiles, nil
+}
+
+// GetHead returns the commit the branch points at
+func (b *BitbucketServerClient) GetHead(projectId string, branch string) (string, error) {
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("BitbucketServer: unknown project ID %v", projectId)
+	}
+
+	commitsPath := fmt.Sprintf("/projects/%v/repos/%v/commits", url.PathEscape(repo.Project.Key), url.PathEscape(repo.Slug))
+	body, _, err := b.get(commitsPath, url.Values{"until": {branch}, "limit": {"1"}})
+	if err != nil {
+		log.Errorf("BitbucketServer: failed to get head of %v in %v: %v\n", branch, repo.Slug, err)
+		return "", err
+	}
+	var page struct {
+		Values []struct {
+			Id string `json:"id"`
+		} `json:"values"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return "", err
+	}
+	if len(page.Values) == 0 {
+		return "", fmt.Errorf("BitbucketServer: branch %v of %v has no commits", branch, repo.Slug)
+	}
+	return page.Values[0].Id, nil
+}
+
+// ListRefs lists the names of a repo's branches and tags. Tags need no translation when passed
+// back to GetFilesByProject as the API's at parameter takes refs/tags/ refs.
+func (b *BitbucketServerClient) ListRefs(projectId string) ([]string, []string, error) {
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("BitbucketServer: unknown project ID %v", projectId)
+	}
+	repoPath := fmt.Sprintf("/projects/%v/repos/%v", url.PathEscape(repo.Project.Key), url.PathEscape(repo.Slug))
+
+	branches, err := b.listRefNames(repoPath + "/branches")
+	if err != nil {
+		log.Errorf("BitbucketServer: failed to list branches for %v: %v\n", repo.Slug, err)
+		return nil, nil, err
+	}
+	tags, err := b.listRefNames(repoPath + "/tags")
+	if err != nil {
+		log.Errorf("BitbucketServer: failed to list tags for %v: %v\n", repo.Slug, err)
+		return nil, nil, err
+	}
+	return branches, tags, nil
+}
+
+// listRefNames returns the display name of every ref from a branches or tags listing
+func (b *BitbucketServerClient) listRefNames(apiPath string) ([]string, error) {
+	var names []string
+	err := b.getPaged(apiPath, nil, func(values json.RawMessage) error {
+		var refs []BitbucketServerBranch
+		if err := json.Unmarshal(values, &refs); err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			names = append(names, ref.DisplayId)
+		}
+		return nil
+	})
+	return names, err
+}
+
+// ListPullRequests lists a repo's open pull requests
+func (b *BitbucketServerClient) ListPullRequests(projectId string) ([]*structs.PullRequest, error) {
+	var retPullRequests []*structs.PullRequest
+
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("BitbucketServer: unknown project ID %v", projectId)
+	}
+	pullRequestsPath := fmt.Sprintf("/projects/%v/repos/%v/pull-requests", url.PathEscape(repo.Project.Key), url.PathEscape(repo.Slug))
+
+	err := b.getPaged(pullRequestsPath, url.Values{"state": {"OPEN"}}, func(values json.RawMessage) error {
+		var pullRequests []struct {
+			Id      int    `json:"id"`
+			Title   string `json:"title"`
+			FromRef struct {
+				DisplayId    string                    `json:"displayId"`
+				LatestCommit string                    `json:"latestCommit"`
+				Repository   BitbucketServerRepository `json:"repository"`
+			} `json:"fromRef"`
+			Links struct {
+				Self []struct {
+					Href string `json:"href"`
+				} `json:"self"`
+			} `json:"links"`
+		}
+		if err := json.Unmarshal(values, &pullRequests); err != nil {
+			return err
+		}
+		for _, pullRequest := range pullRequests {
+			pr := &structs.PullRequest{
+				Id:         strconv.Itoa(pullRequest.Id),
+				Title:      pullRequest.Title,
+				HeadBranch: pullRequest.FromRef.DisplayId,
+				HeadSha:    pullRequest.FromRef.LatestCommit,
+				HeadRepo:   fmt.Sprintf("%v/%v", pullRequest.FromRef.Repository.Project.Key, pullRequest.FromRef.Repository.Slug),
+			}
+			if len(pullRequest.Links.Self) > 0 {
+				pr.Url = pullRequest.Links.Self[0].Href
+			}
+			retPullRequests = append(retPullRequests, pr)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("BitbucketServer: failed to list pull requests for %v: %v\n", repo.Slug, err)
+		return nil, err
+	}
+	return retPullRequests, nil
+}
+
+// GetPullRequestLockfiles fetches the lockfiles a pull request adds or modifies at its head commit,
+// from the fork when the pull request comes from one
+func (b *BitbucketServerClient) GetPullRequestLockfiles(projectId string, pullRequest *structs.PullRequest) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("BitbucketServer: unknown project ID %v", projectId)
+	}
+	changesPath := fmt.Sprintf("/projects/%v/repos/%v/pull-requests/%v/changes", url.PathEscape(repo.Project.Key), url.PathEscape(repo.Slug), pullRequest.Id)
+
+	var paths []string
+	err := b.getPaged(changesPath, nil, func(values json.RawMessage) error {
+		var changes []struct {
+			Type string `json:"type"`
+			Path struct {
+				ToString string `json:"toString"`
+			} `json:"path"`
+		}
+		if err := json.Unmarshal(values, &changes); err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if change.Type != "DELETE" && utils.IsLockfile(change.Path.ToString) {
+				paths = append(paths, change.Path.ToString)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("BitbucketServer: failed to get changes of pull request %v in %v: %v\n", pullRequest.Id, repo.Slug, err)
+		return nil, err
+	}
+
+	headKey, headSlug := repo.Project.Key, repo.Slug
+	if key, slug, found := strings.Cut(pullRequest.HeadRepo, "/"); found {
+		headKey, headSlug = key, slug
+	}
+	for _, filePath := range paths {
+		rawPath := fmt.Sprintf("/projects/%v/repos/%v/raw/%v", url.PathEscape(headKey), url.PathEscape(headSlug), utils.EscapePath(filePath))
+		content, _, err := b.get(rawPath, url.Values{"at": {pullRequest.HeadSha}})
+		if err != nil {
+			log.Errorf("BitbucketServer: failed to get raw file %v at %v: %v\n", filePath, pullRequest.HeadSha, err)
+			return nil, err
+		}
+		retFiles = append(retFiles, &structs.VcsFile{
+			Name:    filepath.Base(filePath),
+			Path:    filePath,
+			Id:      pullRequest.HeadSha,
+			Content: content,
+		})
+	}
+	return retFiles, nil
+}
+
+// DownloadArchive downloads a tarball of the branch or utils.TagRef. Entries aren't nested in a
+// directory unless the archive is requested with a prefix.
+func (b *BitbucketServerClient) DownloadArchive(projectId string, ref string) (*Archive, error) {
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("BitbucketServer: unknown project ID %v", projectId)
+	}
+
+	archivePath := fmt.Sprintf("/projects/%v/repos/%v/archive", url.PathEscape(repo.Project.Key), url.PathEscape(repo.Slug))
+	req, err := b.newRequest(archivePath, url.Values{"at": {ref}, "format": {"tgz"}})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned %v", archivePath, resp.Status)
+	}
+	return &Archive{Body: resp.Body, Format: ArchiveTarGz}, nil
+}
+
+func (b *BitbucketServerClient) GetCloneTarget(projectId string) (*CloneTarget, error) {
+	b.ProjectMapMutex.RLock()
+	repo, ok := b.ProjectMap[projectId]
+	b.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("BitbucketServer: unknown project ID %v", projectId)
+	}
+
+	return &CloneTarget{
+		Url:        fmt.Sprintf("%v/scm/%v/%v.git", b.BaseUrl, strings.ToLower(repo.Project.Key), repo.Slug),
+		AuthHeader: fmt.Sprintf("Authorization: Bearer %v", b.Token),
+	}, nil
+}
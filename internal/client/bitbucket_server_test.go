@@ -0,0 +1,183 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+)
+
+func newTestBitbucketServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/api/1.0/projects", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer testtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("start") {
+		case "0":
+			fmt.Fprint(w, `{"values": [{"id": 1, "key": "ONE", "name": "One"}], "isLastPage": false, "nextPageStart": 1}`)
+		default:
+			fmt.Fprint(w, `{"values": [{"id": 2, "key": "TWO", "name": "Two"}], "isLastPage": true}`)
+		}
+	})
+	mux.HandleFunc("/rest/api/1.0/projects/ONE/repos", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"values": [{"id": 10, "slug": "api", "name": "api", "project": {"id": 1, "key": "ONE"}}], "isLastPage": true}`)
+	})
+	mux.HandleFunc("/rest/api/1.0/projects/TWO/repos", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"values": [{"id": 20, "slug": "empty", "name": "empty", "project": {"id": 2, "key": "TWO"}},
+			{"id": 21, "slug": "api", "name": "api", "project": {"id": 2, "key": "TWO"}}], "isLastPage": true}`)
+	})
+	mux.HandleFunc("/rest/api/1.0/projects/ONE/repos/api/branches/default", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": "refs/heads/master", "displayId": "master"}`)
+	})
+	mux.HandleFunc("/rest/api/1.0/projects/TWO/repos/api/branches/default", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": "refs/heads/main", "displayId": "main"}`)
+	})
+	mux.HandleFunc("/rest/api/1.0/projects/TWO/repos/empty/branches/default", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/rest/api/1.0/projects/ONE/repos/api/files", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("start") {
+		case "0":
+			fmt.Fprint(w, `{"values": ["README.md", "package-lock.json"], "isLastPage": false, "nextPageStart": 2}`)
+		default:
+			fmt.Fprint(w, `{"values": ["svc/requirements.txt"], "isLastPage": true}`)
+		}
+	})
+	mux.HandleFunc("/rest/api/1.0/projects/ONE/repos/api/raw/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "contents of %v at %v", r.URL.Path, r.URL.Query().Get("at"))
+	})
+	return httptest.NewServer(mux)
+}
+
+func newTestBitbucketServerClient(serverUrl string) *BitbucketServerClient {
+	return NewBitbucketServerClient(&structs.ConfigThing{
+		VcsType:  "bitbucket_server",
+		VcsToken: "testtoken",
+		Associated: map[string]string{
+			"bbServerUrl": serverUrl,
+		},
+	}, &structs.SyringeOptions{})
+}
+
+func TestBitbucketServerClient_ListProjects(t *testing.T) {
+	server := newTestBitbucketServer()
+	defer server.Close()
+	b := newTestBitbucketServerClient(server.URL)
+
+	tests := []struct {
+		name       string
+		want       *[]*structs.SyringeProject
+		wantLen    int
+		wantBranch map[string]string
+		wantErr    bool
+	}{
+		// both projects have an api repo
+		{"two projects", nil, 3, map[string]string{"ONE/api": "master", "TWO/empty": "", "TWO/api": "main"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := b.ListProjects()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ListProjects() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("BitbucketServer_ListProjects() TypeOf got = %v, want %v", got, tt.want)
+			}
+
+			if len(*got) != tt.wantLen {
+				t.Errorf("BitbucketServer_ListProjects() len(projects) got = %v, want %v", len(*got), tt.wantLen)
+			}
+			for _, p := range *got {
+				if _, ok := tt.wantBranch[p.Name]; !ok {
+					t.Errorf("BitbucketServer_ListProjects() unexpected project name %v", p.Name)
+				}
+				if p.Branch != tt.wantBranch[p.Name] {
+					t.Errorf("BitbucketServer_ListProjects() branch for %v got = %v, want %v", p.Name, p.Branch, tt.wantBranch[p.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestBitbucketServerClient_ListFiles(t *testing.T) {
+	server := newTestBitbucketServer()
+	defer server.Close()
+	b := newTestBitbucketServerClient(server.URL)
+
+	type args struct {
+		projectKey string
+		repoSlug   string
+		branch     string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantLen int
+		wantErr bool
+	}{
+		{"paged files", args{"ONE", "api", "master"}, 3, false},
+		{"missing repo", args{"ONE", "nope", "master"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := b.ListFiles(tt.args.projectKey, tt.args.repoSlug, tt.args.branch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ListFiles() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != tt.wantLen {
+				t.Errorf("BitbucketServer_ListFiles() len(files) got = %v, want %v", len(got), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestBitbucketServerClient_GetLockfilesByProject(t *testing.T) {
+	server := newTestBitbucketServer()
+	defer server.Close()
+	b := newTestBitbucketServerClient(server.URL)
+
+	// populate with projects
+	_, _ = b.ListProjects()
+
+	host := utils.UrlHost(server.URL)
+
+	type args struct {
+		projectId      string
+		mainBranchName string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []*structs.VcsFile
+		wantLen int
+		wantErr bool
+	}{
+		{"api", args{structs.NewProjectKey("bitbucket_server", host, "ONE", "10"), "master"}, nil, 2, false},
+		{"empty", args{structs.NewProjectKey("bitbucket_server", host, "TWO", "20"), ""}, nil, 0, false},
+		{"unknown", args{structs.NewProjectKey("bitbucket_server", host, "ONE", "30"), "master"}, nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := b.GetLockfilesByProject(tt.args.projectId, tt.args.mainBranchName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetLockfilesByProject() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("BitbucketServer_GetLockfilesByProject() TypeOf got = %v, want %v", got, tt.want)
+			}
+
+			if len(got) != tt.wantLen {
+				t.Errorf("BitbucketServer_GetLockfilesByProject() len(files) got = %v, want %v", len(got), tt.wantLen)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// CloneTarget describes how to clone a project over HTTPS with the git binary
+type CloneTarget struct {
+	Url        string
+	AuthHeader string
+}
+
+// Cloner is implemented by clients that can hand out a CloneTarget for clone-based discovery
+type Cloner interface {
+	GetCloneTarget(projectId string) (*CloneTarget, error)
+}
+
+// basicAuthHeader builds an Authorization header value for git's http.extraHeader
+func basicAuthHeader(username string, password string) string {
+	creds := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%v:%v", username, password)))
+	return fmt.Sprintf("Authorization: Basic %v", creds)
+}
+
+// runGit executes git in dir. The auth header is passed through the environment rather than
+// the command line so it doesn't show up in process listings or get written to .git/config.
+func runGit(dir string, authHeader string, args ...string) ([]byte, error) {
+	var stdErrBytes bytes.Buffer
+
+	gitCmd := exec.Command("git", args...)
+	gitCmd.Dir = dir
+	gitCmd.Stderr = &stdErrBytes
+	gitCmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if authHeader != "" {
+		gitCmd.Env = append(gitCmd.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			fmt.Sprintf("GIT_CONFIG_VALUE_0=%v", authHeader),
+		)
+	}
+
+	output, err := gitCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %v: %v: %v", args[0], err, strings.TrimSpace(stdErrBytes.String()))
+	}
+	return output, nil
+}
+
+// CloneLockfiles does a shallow, blobless clone of branch, finds lockfiles from the tree and
+// sparse-checks-out only those paths so no other file contents are transferred.
+func CloneLockfiles(target *CloneTarget, branch string) ([]*structs.VcsFile, error) {
+	return CloneFiles(target, branch, utils.IsLockfile)
+}
+
+// CloneFiles is CloneLockfiles for any files whose path satisfies match
+func CloneFiles(target *CloneTarget, branch string, match func(string) bool) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("clone discovery requires the git binary: %v", err)
+	}
+
+	tempDir, err := ioutil.TempDir("", "syringe-clone")
+	if err != nil {
+		log.Errorf("Failed to create temp directory: %v\n", err)
+		return nil, err
+	}
+	defer utils.RemoveTempDir(tempDir)
+
+	cloneArgs := []string{"clone", "--depth", "1", "--filter=blob:none", "--no-checkout", "--single-branch"}
+	if branch != "" {
+		cloneArgs = append(cloneArgs, "--branch", branch)
+	}
+	cloneArgs = append(cloneArgs, target.Url, tempDir)
+	if _, err = runGit("", target.AuthHeader, cloneArgs...); err != nil {
+		return nil, err
+	}
+
+	// The tree is available without any blobs, so this doesn't fetch file contents
+	treeOutput, err := runGit(tempDir, target.AuthHeader, "ls-tree", "-r", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	fileShas := make(map[string]string, 0)
+	var sparsePatterns []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(treeOutput))
+	for scanner.Scan() {
+		// <mode> SP <type> SP <object> TAB <path>
+		meta, path, found := strings.Cut(scanner.Text(), "\t")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		if match(path) {
+			fileShas[path] = fields[2]
+			sparsePatterns = append(sparsePatterns, fmt.Sprintf("/%v", path))
+		}
+	}
+	if len(sparsePatterns) == 0 {
+		return nil, nil
+	}
+
+	if _, err = runGit(tempDir, target.AuthHeader, append([]string{"sparse-checkout", "set", "--no-cone"}, sparsePatterns...)...); err != nil {
+		return nil, err
+	}
+	if _, err = runGit(tempDir, target.AuthHeader, "checkout"); err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range sparsePatterns {
+		path := strings.TrimPrefix(pattern, "/")
+		content, err := os.ReadFile(filepath.Join(tempDir, filepath.FromSlash(path)))
+		if err != nil {
+			log.Errorf("Failed to read cloned file %v: %v\n", path, err)
+			return nil, err
+		}
+		log.Debugf("File: %v in %v from clone: %v\n", filepath.Base(path), path, target.Url)
+		retFiles = append(retFiles, &structs.VcsFile{
+			Name:          filepath.Base(path),
+			Path:          path,
+			Id:            fileShas[path],
+			Content:       content,
+			PhylumProject: nil,
+		})
+	}
+
+	return retFiles, nil
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setupGitRepo creates a repository with a single commit on branch main
+func setupGitRepo(t *testing.T, files map[string]string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+
+	repoPath := t.TempDir()
+	for path, content := range files {
+		fullPath := filepath.Join(repoPath, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("failed to create %v: %v", path, err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %v: %v", path, err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"config", "uploadpack.allowFilter", "true"},
+		{"add", "-A"},
+		{"-c", "user.name=syringe", "-c", "user.email=syringe@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		if _, err := runGit(repoPath, "", args...); err != nil {
+			t.Fatalf("failed to set up test repo: %v", err)
+		}
+	}
+	return repoPath
+}
+
+func TestCloneLockfiles(t *testing.T) {
+	repoPath := setupGitRepo(t, map[string]string{
+		"package-lock.json":           "{}",
+		"README.md":                   "readme",
+		"services/api/poetry.lock":    "poetry",
+		"services/api/main.py":        "print()",
+		"services/web/web.csproj":     "<Project/>",
+		"services/web/node/app.js":    "app",
+		"services/web/node/yarn.lock": "yarn",
+	})
+
+	type args struct {
+		target *CloneTarget
+		branch string
+	}
+	tests := []struct {
+		name      string
+		args      args
+		wantPaths []string
+		wantErr   bool
+	}{
+		{"main", args{&CloneTarget{Url: "file://" + repoPath}, "main"}, []string{
+			"package-lock.json",
+			"services/api/poetry.lock",
+			"services/web/node/yarn.lock",
+			"services/web/web.csproj",
+		}, false},
+		{"default branch", args{&CloneTarget{Url: "file://" + repoPath}, ""}, []string{
+			"package-lock.json",
+			"services/api/poetry.lock",
+			"services/web/node/yarn.lock",
+			"services/web/web.csproj",
+		}, false},
+		{"missing branch", args{&CloneTarget{Url: "file://" + repoPath}, "nope"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CloneLockfiles(tt.args.target, tt.args.branch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloneLockfiles() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var gotPaths []string
+			for _, lockfile := range got {
+				gotPaths = append(gotPaths, lockfile.Path)
+				if len(lockfile.Id) != 40 {
+					t.Errorf("CloneLockfiles() Id for %v got = %v, want blob SHA", lockfile.Path, lockfile.Id)
+				}
+			}
+			if !reflect.DeepEqual(gotPaths, tt.wantPaths) {
+				t.Errorf("CloneLockfiles() got = %v, want %v", gotPaths, tt.wantPaths)
+			}
+		})
+	}
+}
@@ -0,0 +1,502 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const giteaPageSize = 50
+
+type GiteaClient struct {
+	Client          *http.Client
+	BaseUrl         string
+	Token           string
+	OrgName         string
+	MineOnly        bool
+	ProjectMap      map[string]*GiteaRepository
+	ProjectMapMutex sync.RWMutex
+}
+
+// GiteaRepository is the subset of the Gitea/Forgejo repository object Syringe cares about
+type GiteaRepository struct {
+	Id            int64  `json:"id"`
+	Name          string `json:"name"`
+	FullName      string `json:"full_name"`
+	DefaultBranch string `json:"default_branch"`
+	CloneUrl      string `json:"clone_url"`
+	Empty         bool   `json:"empty"`
+	Owner         struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+}
+
+type GiteaTreeEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Sha  string `json:"sha"`
+}
+
+type GiteaTree struct {
+	Sha        string           `json:"sha"`
+	Entries    []GiteaTreeEntry `json:"tree"`
+	Truncated  bool             `json:"truncated"`
+	Page       int              `json:"page"`
+	TotalCount int              `json:"total_count"`
+}
+
+func NewGiteaClient(configData *structs.ConfigThing, opts *structs.SyringeOptions) *GiteaClient {
+	var mineOnly bool = false
+
+	baseUrl := strings.TrimSuffix(configData.Associated["giteaUrl"], "/")
+	if baseUrl == "" {
+		log.Fatalf("NewGiteaClient: 'giteaUrl' is not configured\n")
+	}
+
+	if opts != nil {
+		mineOnly = opts.MineOnly
+	}
+
+	return &GiteaClient{
+		Client:     newHttpClient(opts, configData.VcsToken),
+		BaseUrl:    baseUrl,
+		Token:      configData.VcsToken,
+		OrgName:    configData.Associated["giteaOrg"],
+		MineOnly:   mineOnly,
+		ProjectMap: make(map[string]*GiteaRepository, 0),
+	}
+}
+
+// get performs an authenticated GET against the Gitea API and returns the response body
+func (g *GiteaClient) get(apiPath string, query url.Values) ([]byte, error) {
+	reqUrl := fmt.Sprintf("%v/api/v1%v", g.BaseUrl, apiPath)
+	if len(query) > 0 {
+		reqUrl = fmt.Sprintf("%v?%v", reqUrl, query.Encode())
+	}
+
+	req, err := http.NewRequest(http.MethodGet, reqUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	if g.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("token %v", g.Token))
+	}
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v returned %v", apiPath, resp.Status)
+	}
+	return body, nil
+}
+
+// listRepos pages through a Gitea repository listing endpoint until a short page is returned
+func (g *GiteaClient) listRepos(apiPath string) ([]GiteaRepository, error) {
+	var retRepos []GiteaRepository
+
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("page", fmt.Sprintf("%v", page))
+		query.Set("limit", fmt.Sprintf("%v", giteaPageSize))
+
+		body, err := g.get(apiPath, query)
+		if err != nil {
+			return nil, err
+		}
+
+		var repos []GiteaRepository
+		if err := json.Unmarshal(body, &repos); err != nil {
+			return nil, err
+		}
+		retRepos = append(retRepos, repos...)
+
+		if len(repos) < giteaPageSize {
+			break
+		}
+		log.Debugf("Gitea: %v paging to page #%v\n", apiPath, page+1)
+	}
+
+	return retRepos, nil
+}
+
+func (g *GiteaClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	var retProjects []*structs.SyringeProject
+	var sources []string
+
+	if g.OrgName != "" {
+		sources = append(sources, fmt.Sprintf("/orgs/%v/repos", url.PathEscape(g.OrgName)))
+	}
+	if g.OrgName == "" || g.MineOnly {
+		sources = append(sources, "/user/repos")
+	}
+
+	// Repos from both the org and the user listing may share a name
+	qualifyNames := len(sources) > 1
+	seen := make(map[string]bool, 0)
+
+	for _, source := range sources {
+		repos, err := g.listRepos(source)
+		if err != nil {
+			log.Errorf("Gitea: failed to list repositories from %v: %v\n", source, err)
+			return nil, err
+		}
+
+		for _, repo := range repos {
+			projectKey := structs.NewProjectKey("gitea", utils.UrlHost(g.BaseUrl), repo.Owner.Login, strconv.FormatInt(repo.Id, 10))
+			if seen[projectKey] {
+				continue
+			}
+			seen[projectKey] = true
+
+			name := repo.Name
+			if qualifyNames {
+				name = fmt.Sprintf("%v/%v", repo.Owner.Login, repo.Name)
+			}
+
+			retProjects = append(retProjects, &structs.SyringeProject{
+				Id:        projectKey,
+				Name:      name,
+				Owner:     repo.Owner.Login,
+				Branch:    repo.DefaultBranch,
+				Lockfiles: []*structs.VcsFile{},
+				CiFiles:   []*structs.VcsFile{},
+				Hydrated:  false,
+			})
+			temp := new(GiteaRepository)
+			*temp = repo
+			g.ProjectMapMutex.Lock()
+			g.ProjectMap[projectKey] = temp
+			g.ProjectMapMutex.Unlock()
+		}
+	}
+
+	log.Debugf("Len of gitea projects: %v\n", len(retProjects))
+	return &retProjects, nil
+}
+
+// ListFiles walks the full repository tree at branch, following the API's pagination
+func (g *GiteaClient) ListFiles(owner string, repoName string, branch string) ([]GiteaTreeEntry, error) {
+	var retEntries []GiteaTreeEntry
+
+	apiPath := fmt.Sprintf("/repos/%v/%v/git/trees/%v", url.PathEscape(owner), url.PathEscape(repoName), url.PathEscape(branch))
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("recursive", "true")
+		query.Set("page", fmt.Sprintf("%v", page))
+		query.Set("per_page", "1000")
+
+		body, err := g.get(apiPath, query)
+		if err != nil {
+			return nil, err
+		}
+
+		var tree GiteaTree
+		if err := json.Unmarshal(body, &tree); err != nil {
+			return nil, err
+		}
+
+		for _, entry := range tree.Entries {
+			if entry.Type == "blob" {
+				retEntries = append(retEntries, entry)
+			}
+		}
+
+		if !tree.Truncated || len(tree.Entries) == 0 {
+			break
+		}
+	}
+
+	return retEntries, nil
+}
+
+func (g *GiteaClient) GetLockfilesByProject(projectId string, mainBranchName string) ([]*structs.VcsFile, error) {
+	return g.GetFilesByProject(projectId, mainBranchName, utils.IsLockfile)
+}
+
+// GetFilesByProject fetches every file on the branch whose path satisfies match
+func (g *GiteaClient) GetFilesByProject(projectId string, mainBranchName string, match func(string) bool) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	g.ProjectMapMutex.RLock()
+	repo, ok := g.ProjectMap[projectId]
+	g.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Gitea: unknown project ID %v", projectId)
+	}
+
+	if repo.Empty || mainBranchName == "" {
+		log.Debugf("Gitea: skipping %v, repository is empty\n", repo.FullName)
+		return nil, nil
+	}
+
+	// Gitea resolves tag names wherever it takes a ref
+	refName, _ := utils.SplitRef(mainBranchName)
+	projectFiles, err := g.ListFiles(repo.Owner.Login, repo.Name, refName)
+	if err != nil {
+		log.Errorf("Gitea: failed to ListFiles for %v: %v\n", repo.FullName, err)
+		return nil, err
+	}
+
+	for _, file := range projectFiles {
+		fileName := filepath.Base(file.Path)
+		if match(file.Path) {
+			log.Debugf("File: %v in %v from project: %v\n", fileName, file.Path, repo.FullName)
+
+			rawPath := fmt.Sprintf("/repos/%v/%v/raw/%v", url.PathEscape(repo.Owner.Login), url.PathEscape(repo.Name), utils.EscapePath(file.Path))
+			query := url.Values{}
+			query.Set("ref", refName)
+			content, err := g.get(rawPath, query)
+			if err != nil {
+				log.Errorf("Gitea: failed to get raw file %v in %v: %v\n", file.Path, repo.FullName, err)
+				return nil, err
+			}
+
+			retFiles = append(retFiles, &structs.VcsFile{
+				Name:          fileName,
+				Path:          file.Path,
+				Id:            file.Sha,
+				Content:       content,
+				PhylumProject: nil,
+			})
+		}
+	}
+
+	return retFiles, nil
+}
+
+// GetHead returns the commit the branch points at
+func (g *GiteaClient) GetHead(projectId string, branch string) (string, error) {
+	g.ProjectMapMutex.RLock()
+	repo, ok := g.ProjectMap[projectId]
+	g.ProjectMapMutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("Gitea: unknown project ID %v", projectId)
+	}
+
+	body, err := g.get(fmt.Sprintf("/repos/%v/%v/branches/%v", url.PathEscape(repo.Owner.Login), url.PathEscape(repo.Name), utils.EscapePath(branch)), nil)
+	if err != nil {
+		log.Errorf("Gitea: failed to get branch %v of %v: %v\n", branch, repo.FullName, err)
+		return "", err
+	}
+	var giteaBranch struct {
+		Commit struct {
+			Id string `json:"id"`
+		} `json:"commit"`
+	}
+	if err := json.Unmarshal(body, &giteaBranch); err != nil {
+		return "", err
+	}
+	return giteaBranch.Commit.Id, nil
+}
+
+// ListRefs lists the names of a repo's branches and tags
+func (g *GiteaClient) ListRefs(projectId string) ([]string, []string, error) {
+	g.ProjectMapMutex.RLock()
+	repo, ok := g.ProjectMap[projectId]
+	g.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("Gitea: unknown project ID %v", projectId)
+	}
+	repoPath := fmt.Sprintf("/repos/%v/%v", url.PathEscape(repo.Owner.Login), url.PathEscape(repo.Name))
+
+	branches, err := g.listRefNames(repoPath + "/branches")
+	if err != nil {
+		log.Errorf("Gitea: failed to list branches for %v: %v\n", repo.FullName, err)
+		return nil, nil, err
+	}
+	tags, err := g.listRefNames(repoPath + "/tags")
+	if err != nil {
+		log.Errorf("Gitea: failed to list tags for %v: %v\n", repo.FullName, err)
+		return nil, nil, err
+	}
+	return branches, tags, nil
+}
+
+// listRefNames pages through a branches or tags listing until a short page is returned
+func (g *GiteaClient) listRefNames(apiPath string) ([]string, error) {
+	var names []string
+
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("page", fmt.Sprintf("%v", page))
+		query.Set("limit", fmt.Sprintf("%v", giteaPageSize))
+
+		body, err := g.get(apiPath, query)
+		if err != nil {
+			return nil, err
+		}
+
+		var refs []struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(body, &refs); err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			names = append(names, ref.Name)
+		}
+
+		if len(refs) < giteaPageSize {
+			break
+		}
+	}
+
+	return names, nil
+}
+
+// ListPullRequests lists a repo's open pull requests
+func (g *GiteaClient) ListPullRequests(projectId string) ([]*structs.PullRequest, error) {
+	var retPullRequests []*structs.PullRequest
+
+	g.ProjectMapMutex.RLock()
+	repo, ok := g.ProjectMap[projectId]
+	g.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Gitea: unknown project ID %v", projectId)
+	}
+	pullsPath := fmt.Sprintf("/repos/%v/%v/pulls", url.PathEscape(repo.Owner.Login), url.PathEscape(repo.Name))
+
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("state", "open")
+		query.Set("page", fmt.Sprintf("%v", page))
+		query.Set("limit", fmt.Sprintf("%v", giteaPageSize))
+
+		body, err := g.get(pullsPath, query)
+		if err != nil {
+			log.Errorf("Gitea: failed to list pull requests for %v: %v\n", repo.FullName, err)
+			return nil, err
+		}
+
+		var pulls []struct {
+			Number  int    `json:"number"`
+			Title   string `json:"title"`
+			HtmlUrl string `json:"html_url"`
+			Head    struct {
+				Ref  string           `json:"ref"`
+				Sha  string           `json:"sha"`
+				Repo *GiteaRepository `json:"repo"`
+			} `json:"head"`
+		}
+		if err := json.Unmarshal(body, &pulls); err != nil {
+			return nil, err
+		}
+		for _, pull := range pulls {
+			pr := &structs.PullRequest{
+				Id:         strconv.Itoa(pull.Number),
+				Title:      pull.Title,
+				Url:        pull.HtmlUrl,
+				HeadBranch: pull.Head.Ref,
+				HeadSha:    pull.Head.Sha,
+			}
+			// The head repo is gone when a fork has been deleted
+			if pull.Head.Repo != nil {
+				pr.HeadRepo = pull.Head.Repo.FullName
+			}
+			retPullRequests = append(retPullRequests, pr)
+		}
+
+		if len(pulls) < giteaPageSize {
+			break
+		}
+	}
+
+	return retPullRequests, nil
+}
+
+// GetPullRequestLockfiles fetches the lockfiles a pull request adds or modifies at its head commit,
+// from the fork when the pull request comes from one
+func (g *GiteaClient) GetPullRequestLockfiles(projectId string, pullRequest *structs.PullRequest) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	g.ProjectMapMutex.RLock()
+	repo, ok := g.ProjectMap[projectId]
+	g.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Gitea: unknown project ID %v", projectId)
+	}
+	filesPath := fmt.Sprintf("/repos/%v/%v/pulls/%v/files", url.PathEscape(repo.Owner.Login), url.PathEscape(repo.Name), pullRequest.Id)
+
+	var paths []string
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("page", fmt.Sprintf("%v", page))
+		query.Set("limit", fmt.Sprintf("%v", giteaPageSize))
+
+		body, err := g.get(filesPath, query)
+		if err != nil {
+			log.Errorf("Gitea: failed to list files of pull request %v in %v: %v\n", pullRequest.Id, repo.FullName, err)
+			return nil, err
+		}
+
+		var files []struct {
+			Filename string `json:"filename"`
+			Status   string `json:"status"`
+		}
+		if err := json.Unmarshal(body, &files); err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if file.Status != "removed" && file.Status != "deleted" && utils.IsLockfile(file.Filename) {
+				paths = append(paths, file.Filename)
+			}
+		}
+
+		if len(files) < giteaPageSize {
+			break
+		}
+	}
+
+	headOwner, headName := repo.Owner.Login, repo.Name
+	if owner, name, found := strings.Cut(pullRequest.HeadRepo, "/"); found {
+		headOwner, headName = owner, name
+	}
+	for _, filePath := range paths {
+		rawPath := fmt.Sprintf("/repos/%v/%v/raw/%v", url.PathEscape(headOwner), url.PathEscape(headName), utils.EscapePath(filePath))
+		content, err := g.get(rawPath, url.Values{"ref": {pullRequest.HeadSha}})
+		if err != nil {
+			log.Errorf("Gitea: failed to get raw file %v at %v: %v\n", filePath, pullRequest.HeadSha, err)
+			return nil, err
+		}
+		retFiles = append(retFiles, &structs.VcsFile{
+			Name:    filepath.Base(filePath),
+			Path:    filePath,
+			Id:      pullRequest.HeadSha,
+			Content: content,
+		})
+	}
+
+	return retFiles, nil
+}
+
+func (g *GiteaClient) GetCloneTarget(projectId string) (*CloneTarget, error) {
+	g.ProjectMapMutex.RLock()
+	repo, ok := g.ProjectMap[projectId]
+	g.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Gitea: unknown project ID %v", projectId)
+	}
+
+	return &CloneTarget{
+		Url:        repo.CloneUrl,
+		AuthHeader: fmt.Sprintf("Authorization: token %v", g.Token),
+	}, nil
+}
@@ -0,0 +1,217 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+)
+
+func newTestGiteaServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/orgs/testorg/repos", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token testtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("page") != "1" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		fmt.Fprint(w, `[
+			{"id": 1, "name": "api", "full_name": "testorg/api", "default_branch": "main", "owner": {"login": "testorg"}},
+			{"id": 2, "name": "empty", "full_name": "testorg/empty", "default_branch": "", "empty": true, "owner": {"login": "testorg"}}
+		]`)
+	})
+	mux.HandleFunc("/api/v1/repos/testorg/api/git/trees/main", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "1":
+			fmt.Fprint(w, `{"sha": "abc", "truncated": true, "page": 1, "total_count": 4, "tree": [
+				{"path": "src", "type": "tree", "sha": "t1"},
+				{"path": "package-lock.json", "type": "blob", "sha": "b1"}
+			]}`)
+		default:
+			fmt.Fprint(w, `{"sha": "abc", "truncated": false, "page": 2, "total_count": 4, "tree": [
+				{"path": "src/main.go", "type": "blob", "sha": "b2"},
+				{"path": "src/web/yarn.lock", "type": "blob", "sha": "b3"}
+			]}`)
+		}
+	})
+	// The commit ID is the branch name as the server decoded it
+	mux.HandleFunc("/api/v1/repos/testorg/api/branches/", func(w http.ResponseWriter, r *http.Request) {
+		commit, _ := json.Marshal(strings.TrimPrefix(r.URL.Path, "/api/v1/repos/testorg/api/branches/"))
+		fmt.Fprintf(w, `{"name": "main", "commit": {"id": %s}}`, commit)
+	})
+	mux.HandleFunc("/api/v1/repos/testorg/api/raw/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "contents of %v", r.URL.Path)
+	})
+	return httptest.NewServer(mux)
+}
+
+func newTestGiteaClient(serverUrl string) *GiteaClient {
+	return NewGiteaClient(&structs.ConfigThing{
+		VcsType:  "gitea",
+		VcsToken: "testtoken",
+		Associated: map[string]string{
+			"giteaUrl": serverUrl,
+			"giteaOrg": "testorg",
+		},
+	}, &structs.SyringeOptions{})
+}
+
+func TestGiteaClient_ListProjects(t *testing.T) {
+	server := newTestGiteaServer()
+	defer server.Close()
+	g := newTestGiteaClient(server.URL)
+
+	tests := []struct {
+		name    string
+		want    *[]*structs.SyringeProject
+		wantLen int
+		wantErr bool
+	}{
+		{"testorg", nil, 2, false},
+		{"listed again", nil, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.ListProjects()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ListProjects() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("GiteaClient_ListProjects() TypeOf got = %v, want %v", got, tt.want)
+			}
+
+			if len(*got) != tt.wantLen {
+				t.Errorf("GiteaClient_ListProjects() len(projects) got = %v, want %v", len(*got), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestGiteaClient_ListFiles(t *testing.T) {
+	server := newTestGiteaServer()
+	defer server.Close()
+	g := newTestGiteaClient(server.URL)
+
+	type args struct {
+		owner    string
+		repoName string
+		branch   string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantLen int
+		wantErr bool
+	}{
+		{"paged tree", args{"testorg", "api", "main"}, 3, false},
+		{"missing repo", args{"testorg", "nope", "main"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.ListFiles(tt.args.owner, tt.args.repoName, tt.args.branch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ListFiles() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != tt.wantLen {
+				t.Errorf("GiteaClient_ListFiles() len(files) got = %v, want %v", len(got), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestGiteaClient_GetLockfilesByProject(t *testing.T) {
+	server := newTestGiteaServer()
+	defer server.Close()
+	g := newTestGiteaClient(server.URL)
+
+	// populate with projects
+	_, _ = g.ListProjects()
+
+	host := utils.UrlHost(server.URL)
+
+	type args struct {
+		projectId      string
+		mainBranchName string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []*structs.VcsFile
+		wantLen int
+		wantErr bool
+	}{
+		{"api", args{structs.NewProjectKey("gitea", host, "testorg", "1"), "main"}, nil, 2, false},
+		{"empty", args{structs.NewProjectKey("gitea", host, "testorg", "2"), ""}, nil, 0, false},
+		{"unknown", args{structs.NewProjectKey("gitea", host, "testorg", "3"), "main"}, nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.GetLockfilesByProject(tt.args.projectId, tt.args.mainBranchName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetLockfilesByProject() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("GiteaClient_GetLockfilesByProject() TypeOf got = %v, want %v", got, tt.want)
+			}
+
+			if len(got) != tt.wantLen {
+				t.Errorf("GiteaClient_GetLockfilesByProject() len(files) got = %v, want %v", len(got), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestGiteaClient_GetHead(t *testing.T) {
+	server := newTestGiteaServer()
+	defer server.Close()
+	g := newTestGiteaClient(server.URL)
+
+	// populate with projects
+	_, _ = g.ListProjects()
+	projectId := structs.NewProjectKey("gitea", utils.UrlHost(server.URL), "testorg", "1")
+
+	for _, branch := range []string{"main", "release/1.0", "fix#12", "what?", "100%"} {
+		t.Run(branch, func(t *testing.T) {
+			got, err := g.GetHead(projectId, branch)
+			if err != nil {
+				t.Fatalf("GetHead() error = %v", err)
+			}
+			if got != branch {
+				t.Errorf("GetHead() requested branch %q, want %q", got, branch)
+			}
+		})
+	}
+}
+
+func TestGiteaClient_HttpOptions(t *testing.T) {
+	var gotUserAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserAgent = r.Header.Get("User-Agent")
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	g := NewGiteaClient(&structs.ConfigThing{
+		VcsType:    "gitea",
+		VcsToken:   "testtoken",
+		Associated: map[string]string{"giteaUrl": server.URL, "giteaOrg": "testorg"},
+	}, &structs.SyringeOptions{Http: structs.HttpOptions{UserAgent: "Syringe-test"}})
+	if _, err := g.ListProjects(); err != nil {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+	if gotUserAgent != "Syringe-test" {
+		t.Errorf("ListProjects() sent User-Agent = %v, want Syringe-test", gotUserAgent)
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,76 +21,375 @@ import (
 )
 
 type GithubClient struct {
-	Client  *github.Client
-	Ctx     context.Context
-	OrgName string
+	Client      *github.Client
+	Ctx         context.Context
+	OrgName     string
+	MineOnly    bool
+	TokenSource oauth2.TokenSource
+	HttpClient  *http.Client // unauthenticated, for the signed archive links the API hands out
+	Filter      structs.RepoFilter
+	Skipped     []*structs.SkippedProject
+	// authenticated as a GitHub App installation, whose tokens can't use the user endpoints
+	AppInstallation bool
+	// delay between code search requests, see SearchFiles
+	SearchInterval time.Duration
+	lastSearch     time.Time
 }
 
+// githubRepository adds the fields go-github v17 predates to the repository listing
+type githubRepository struct {
+	github.Repository
+	IsTemplate *bool   `json:"is_template,omitempty"`
+	Visibility *string `json:"visibility,omitempty"`
+}
+
+// Topics and is_template are still behind preview media types on older GHES releases
+const githubRepoListAccept = "application/vnd.github.mercy-preview+json, application/vnd.github.baptiste-preview+json"
+
 // func NewGithubClient(envMap map[string]string, opts *structs.SyringeOptions) *GithubClient {
 func NewGithubClient(configData *structs.ConfigThing, opts *structs.SyringeOptions) *GithubClient {
-	ctx := context.Background()
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: configData.VcsToken},
-	)
+	var ts oauth2.TokenSource
+	// Installation tokens expire hourly, so the cache keys on the installation instead
+	appId := configData.Associated["githubAppId"]
+	cacheIdentity := configData.VcsToken
+	if appId != "" {
+		cacheIdentity = fmt.Sprintf("github-app:%v:%v", appId, configData.Associated["githubInstallationId"])
+	}
+	httpClient := newHttpClient(opts, cacheIdentity)
+	// oauth2.NewClient builds on the client in the context
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+	baseUrl, uploadUrl := GithubEnterpriseUrls(configData.Associated["githubUrl"], configData.Associated["githubUploadUrl"])
+
+	// Authenticate as a GitHub App installation when an app is configured, otherwise use the token
+	if appId != "" {
+		var err error
+		ts, err = NewGithubAppTokenSource(appId, configData.Associated["githubInstallationId"], configData.Associated["githubPrivateKeyPath"], baseUrl, httpClient)
+		if err != nil {
+			log.Fatalf("Failed to create github app token source: %v\n", err)
+		}
+	} else {
+		ts = oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: configData.VcsToken},
+		)
+	}
 
 	oac := oauth2.NewClient(ctx, ts)
+	oac.Timeout = httpClient.Timeout
 
-	oac.Transport = utils.NewEtagTransport(oac.Transport)
 	oac.Transport = utils.NewRateLimitTransport(oac.Transport, utils.WithWriteDelay(5), utils.WithReadDelay(1))
 
-	gh := github.NewClient(oac)
+	var gh *github.Client
+	if baseUrl == "" {
+		gh = github.NewClient(oac)
+	} else {
+		var err error
+		gh, err = github.NewEnterpriseClient(baseUrl, uploadUrl, oac)
+		if err != nil {
+			log.Fatalf("Failed to create github enterprise client for %v: %v\n", baseUrl, err)
+		}
+	}
+
 	return &GithubClient{
-		Client:  gh,
-		Ctx:     ctx,
-		OrgName: configData.Associated["githubOrg"],
+		Client:          gh,
+		Ctx:             ctx,
+		OrgName:         configData.Associated["githubOrg"],
+		MineOnly:        opts != nil && opts.MineOnly,
+		TokenSource:     ts,
+		HttpClient:      httpClient,
+		Filter:          GithubRepoFilter(configData.Associated, opts),
+		AppInstallation: appId != "",
+		SearchInterval:  githubSearchInterval,
 	}
 }
 
-func (g *GithubClient) ListProjects() (*[]*structs.SyringeProject, error) {
-	var localProjects []*structs.SyringeProject
-	opt := &github.RepositoryListByOrgOptions{
-		ListOptions: github.ListOptions{PerPage: 200},
+// GithubRepoFilter reads the repo filter from the config (githubExcludeForks, githubExcludeArchived,
+// githubExcludeTemplates, githubVisibility, githubIncludeTopics, githubExcludeTopics). Filters set
+// on the command line take precedence.
+func GithubRepoFilter(associated map[string]string, opts *structs.SyringeOptions) structs.RepoFilter {
+	filter := structs.RepoFilter{
+		ExcludeForks:     utils.ParseBool(associated["githubExcludeForks"]),
+		ExcludeArchived:  utils.ParseBool(associated["githubExcludeArchived"]),
+		ExcludeTemplates: utils.ParseBool(associated["githubExcludeTemplates"]),
+		Visibility:       strings.ToLower(strings.TrimSpace(associated["githubVisibility"])),
+		IncludeTopics:    utils.SplitList(associated["githubIncludeTopics"]),
+		ExcludeTopics:    utils.SplitList(associated["githubExcludeTopics"]),
+	}
+	if opts == nil {
+		return filter
 	}
 
-	_, resp, err := g.Client.Repositories.ListByOrg(g.Ctx, g.OrgName, opt)
+	filter.ExcludeForks = filter.ExcludeForks || opts.Filter.ExcludeForks
+	filter.ExcludeArchived = filter.ExcludeArchived || opts.Filter.ExcludeArchived
+	filter.ExcludeTemplates = filter.ExcludeTemplates || opts.Filter.ExcludeTemplates
+	if opts.Filter.Visibility != "" {
+		filter.Visibility = strings.ToLower(opts.Filter.Visibility)
+	}
+	if len(opts.Filter.IncludeTopics) > 0 {
+		filter.IncludeTopics = opts.Filter.IncludeTopics
+	}
+	if len(opts.Filter.ExcludeTopics) > 0 {
+		filter.ExcludeTopics = opts.Filter.ExcludeTopics
+	}
+	return filter
+}
+
+// githubVisibility falls back to the private flag for servers that don't return visibility
+func githubVisibility(repo *githubRepository) string {
+	if repo.Visibility != nil {
+		return strings.ToLower(*repo.Visibility)
+	}
+	if repo.GetPrivate() {
+		return "private"
+	}
+	return "public"
+}
+
+// githubSkipReason returns why filter excludes repo, or "" to keep it
+func githubSkipReason(filter structs.RepoFilter, repo *githubRepository) string {
+	if filter.ExcludeForks && repo.GetFork() {
+		return "fork"
+	}
+	if filter.ExcludeArchived && repo.GetArchived() {
+		return "archived"
+	}
+	if filter.ExcludeTemplates && repo.IsTemplate != nil && *repo.IsTemplate {
+		return "template"
+	}
+	if visibility := githubVisibility(repo); filter.Visibility != "" && filter.Visibility != "all" && visibility != filter.Visibility {
+		return fmt.Sprintf("visibility is %v", visibility)
+	}
+	for _, topic := range filter.ExcludeTopics {
+		if slices.Contains(repo.Topics, topic) {
+			return fmt.Sprintf("has excluded topic %v", topic)
+		}
+	}
+	if len(filter.IncludeTopics) > 0 {
+		included := false
+		for _, topic := range filter.IncludeTopics {
+			if slices.Contains(repo.Topics, topic) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return "has none of the included topics"
+		}
+	}
+	return ""
+}
+
+// SkippedProjects returns the repos the last ListProjects filtered out
+func (g *GithubClient) SkippedProjects() []*structs.SkippedProject {
+	return g.Skipped
+}
+
+// GithubEnterpriseUrls returns the API and upload URLs for a GitHub Enterprise Server instance.
+// Both are "" for github.com. A bare host like https://ghe.example.com gets the /api/v3/ and
+// /api/uploads/ paths GHES serves its API from; an empty upload URL is derived from the API URL.
+func GithubEnterpriseUrls(githubUrl string, uploadUrl string) (string, string) {
+	githubUrl = strings.TrimSpace(githubUrl)
+	uploadUrl = strings.TrimSpace(uploadUrl)
+	if githubUrl == "" {
+		return "", ""
+	}
+
+	parsed, err := url.Parse(githubUrl)
+	if err != nil || parsed.Host == "" {
+		log.Errorf("Failed to parse github url %v: %v\n", githubUrl, err)
+		return "", ""
+	}
+	if parsed.Host == "github.com" || parsed.Host == "api.github.com" || parsed.Host == "www.github.com" {
+		return "", ""
+	}
+
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+	if !strings.HasSuffix(parsed.Path, "/api/v3") {
+		parsed.Path = parsed.Path + "/api/v3"
+	}
+	parsed.Path = parsed.Path + "/"
+	baseUrl := parsed.String()
+
+	if uploadUrl == "" {
+		parsed.Path = strings.TrimSuffix(parsed.Path, "/api/v3/") + "/api/uploads/"
+		uploadUrl = parsed.String()
+	}
+
+	return baseUrl, uploadUrl
+}
+
+// Host names the GitHub instance in project keys: github.com, or the GHES host
+func (g *GithubClient) Host() string {
+	if g.Client.BaseURL == nil || g.Client.BaseURL.Host == "api.github.com" {
+		return "github.com"
+	}
+	return g.Client.BaseURL.Host
+}
+
+// getRepoByKey fetches the repo named by a project key from ListProjects
+func (g *GithubClient) getRepoByKey(projectId string) (*github.Repository, error) {
+	_, _, _, id, err := structs.ParseProjectKey(projectId)
 	if err != nil {
-		log.Errorf("Failed to get github repositories: %v\n", err)
 		return nil, err
 	}
-	count := resp.LastPage
-	listProjectsPB := progressbar.New64(int64(count))
+	repoId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid github repo id in %v: %v", projectId, err)
+	}
 
+	repo, _, err := g.Client.Repositories.GetByID(g.Ctx, repoId)
+	if err != nil {
+		log.Errorf("Failed to GetRepoByID %v: %v\n", repoId, err)
+		return nil, err
+	}
+	return repo, nil
+}
+
+// Orgs returns the organizations configured in OrgName, which may be a comma-separated list
+func (g *GithubClient) Orgs() []string {
+	var retOrgs []string
+	for _, org := range strings.Split(g.OrgName, ",") {
+		org = strings.TrimSpace(org)
+		if org != "" && !slices.Contains(retOrgs, org) {
+			retOrgs = append(retOrgs, org)
+		}
+	}
+	return retOrgs
+}
+
+// ListProjects lists repositories from every configured org, plus the authenticated user's own
+// repositories when MineOnly is set or no org is configured. A GitHub App installation has no user,
+// so it lists the repositories the installation can access instead. When more than one owner is
+// scanned, project names are qualified as owner/repo so same-named repos stay distinct in Phylum.
+func (g *GithubClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	var localProjects []*structs.SyringeProject
+	var githubRepos []*githubRepository
+
+	orgs := g.Orgs()
+	for _, org := range orgs {
+		orgRepos, err := g.listOrgRepos(org)
+		if err != nil {
+			return nil, err
+		}
+		githubRepos = append(githubRepos, orgRepos...)
+	}
+
+	includeUser := g.MineOnly || len(orgs) == 0
+	if includeUser && g.AppInstallation {
+		installationRepos, err := g.listInstallationRepos()
+		if err != nil {
+			return nil, err
+		}
+		githubRepos = append(githubRepos, installationRepos...)
+	} else if includeUser {
+		userRepos, err := g.listUserRepos()
+		if err != nil {
+			return nil, err
+		}
+		githubRepos = append(githubRepos, userRepos...)
+	}
+
+	g.Skipped = nil
+	qualifyNames := len(orgs) > 1 || (includeUser && len(orgs) > 0)
+	seen := make(map[int64]bool, len(githubRepos))
+
+	for _, repo := range githubRepos {
+		if seen[repo.GetID()] {
+			continue
+		}
+		seen[repo.GetID()] = true
+
+		owner := repo.GetOwner().GetLogin()
+		name := repo.GetName()
+		if qualifyNames {
+			name = fmt.Sprintf("%v/%v", owner, name)
+		}
+
+		if reason := githubSkipReason(g.Filter, repo); reason != "" {
+			log.Debugf("Skipping %v: %v\n", name, reason)
+			g.Skipped = append(g.Skipped, &structs.SkippedProject{Name: name, Reason: reason})
+			continue
+		}
+
+		localProjects = append(localProjects, &structs.SyringeProject{
+			Id:        structs.NewProjectKey("github", g.Host(), owner, strconv.FormatInt(repo.GetID(), 10)),
+			Name:      name,
+			Owner:     owner,
+			Branch:    repo.GetDefaultBranch(),
+			Lockfiles: []*structs.VcsFile{},
+			CiFiles:   []*structs.VcsFile{},
+			Hydrated:  false,
+		})
+	}
+
+	return &localProjects, nil
+}
+
+func (g *GithubClient) listOrgRepos(org string) ([]*githubRepository, error) {
+	return g.listRepos(fmt.Sprintf("orgs/%v/repos", org), url.Values{}, true, false)
+}
+
+// listUserRepos lists repositories owned by the authenticated user
+func (g *GithubClient) listUserRepos() ([]*githubRepository, error) {
+	return g.listRepos("user/repos", url.Values{"affiliation": {"owner"}}, false, false)
+}
+
+// listInstallationRepos lists the repositories the authenticated GitHub App installation can access
+func (g *GithubClient) listInstallationRepos() ([]*githubRepository, error) {
+	return g.listRepos("installation/repositories", url.Values{}, false, true)
+}
+
+// listRepos pages through a repository listing endpoint. go-github's list helpers can't be used
+// because they drop is_template and visibility, which the repo filter needs. The installation
+// listing wraps each page's repositories in an object.
+func (g *GithubClient) listRepos(apiPath string, query url.Values, showProgress bool, wrapped bool) ([]*githubRepository, error) {
+	var retRepos []*githubRepository
+	var listProjectsPB *progressbar.ProgressBar
+	page := 1
+
+	query.Set("per_page", "100")
 	for {
-		listProjectsPB.Add(1)
+		query.Set("page", fmt.Sprintf("%d", page))
+		req, err := g.Client.NewRequest("GET", fmt.Sprintf("%v?%v", apiPath, query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", githubRepoListAccept)
 
-		githubRepos, resp, err := g.Client.Repositories.ListByOrg(g.Ctx, g.OrgName, opt)
+		var githubRepos []*githubRepository
+		var resp *github.Response
+		if wrapped {
+			var listing struct {
+				Repositories []*githubRepository `json:"repositories"`
+			}
+			resp, err = g.Client.Do(g.Ctx, req, &listing)
+			githubRepos = listing.Repositories
+		} else {
+			resp, err = g.Client.Do(g.Ctx, req, &githubRepos)
+		}
 		if rl_err, ok := err.(*github.RateLimitError); ok {
-			log.Printf("ListByOrg ratelimited. Pausing until %s", rl_err.Rate.Reset.Time.String())
+			log.Printf("%v ratelimited. Pausing until %s", apiPath, rl_err.Rate.Reset.Time.String())
 			time.Sleep(time.Until(rl_err.Rate.Reset.Time))
 			continue
 		} else if err != nil {
-			log.Errorf("Failed to get github repositories: %v\n", err)
+			log.Errorf("Failed to get github repositories from %v: %v\n", apiPath, err)
 			return nil, err
 		}
+		retRepos = append(retRepos, githubRepos...)
 
-		for _, repo := range githubRepos {
-			localProjects = append(localProjects, &structs.SyringeProject{
-				Id:        *repo.ID,
-				Name:      *repo.Name,
-				Branch:    *repo.DefaultBranch,
-				Lockfiles: []*structs.VcsFile{},
-				CiFiles:   []*structs.VcsFile{},
-				Hydrated:  false,
-			})
+		if showProgress {
+			if listProjectsPB == nil {
+				listProjectsPB = progressbar.New64(int64(resp.LastPage))
+			}
+			listProjectsPB.Add(1)
 		}
 
 		if resp.NextPage == 0 {
 			break
 		}
-		opt.Page = resp.NextPage
+		page = resp.NextPage
 	}
 
-	return &localProjects, nil
+	return retRepos, nil
 }
 
 func handleErr(callerName string, err error) bool {
@@ -100,67 +402,124 @@ func handleErr(callerName string, err error) bool {
 	}
 }
 
-// unfinished
-func (g *GithubClient) SearchOrgForFilename(orgRepo string, filename string, fileExtension string) (*github.CodeSearchResult, error) {
-	orgNameQuery := fmt.Sprintf("org:%v", orgRepo)
-	//filenameQuery := fmt.Sprintf("filename:%v", filename)
-	//filenameQuery := "package-lock.json"
-	fileExtenionQuery := fmt.Sprintf("extension:%v", fileExtension)
-	//fileContentsQuery := "in:path"
-
-	searchQuery := fmt.Sprintf("%s %s",
-		orgNameQuery,
-		//filenameQuery,
-		fileExtenionQuery,
-		//fileContentsQuery,
-	)
-
-	result, resp, err := g.Client.Search.Code(g.Ctx, searchQuery, nil)
-	if err != nil {
-		log.Printf("search failed: %v\n", err)
-		return nil, err
+// githubSearchLimit is the most results the search API pages through for one query
+const githubSearchLimit = 1000
+
+// githubSearchInterval paces code search requests: GitHub allows 10 a minute, counted apart from the
+// core rate limit, and answers faster bursts with abuse rate limit errors
+const githubSearchInterval = 6 * time.Second
+
+// SearchFiles finds the files match accepts in every org and user ListProjects covers, with a few
+// paginated code search queries per file name instead of a tree walk per repo. A GitHub App
+// installation has no user to search, so only its orgs are searched. Paths are keyed by
+// repo ID. Code search only indexes default branches and skips most forks; a query with more
+// results than the API pages through returns ErrSearchIncomplete.
+func (g *GithubClient) SearchFiles(match func(string) bool) (map[string][]string, error) {
+	var owners []string
+	for _, org := range g.Orgs() {
+		owners = append(owners, "org:"+org)
+	}
+	if g.AppInstallation && len(owners) == 0 {
+		// Without an org there is nothing to scope the search to; discovery falls back to the API
+		return nil, fmt.Errorf("code search needs githubOrg when authenticated as a GitHub App installation")
+	}
+	if (g.MineOnly || len(owners) == 0) && !g.AppInstallation {
+		user, _, err := g.Client.Users.Get(g.Ctx, "")
+		if err != nil {
+			log.Errorf("Failed to get the authenticated github user: %v\n", err)
+			return nil, err
+		}
+		owners = append(owners, "user:"+user.GetLogin())
+	}
+
+	hits := newSearchHits(match)
+	for _, owner := range owners {
+		for _, qualifier := range searchQualifiers(match) {
+			if err := g.searchCode(owner+" "+qualifier, hits); err != nil {
+				return nil, err
+			}
+		}
 	}
-	_ = resp
+	return hits.paths, nil
+}
+
+// searchCode pages through the results of one code search query
+func (g *GithubClient) searchCode(query string, hits *searchHits) error {
+	opt := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		time.Sleep(time.Until(g.lastSearch.Add(g.SearchInterval)))
+		g.lastSearch = time.Now()
 
-	return result, nil
+		result, resp, err := g.Client.Search.Code(g.Ctx, query, opt)
+		if handleErr("GH_SearchCode", err) {
+			continue
+		} else if err != nil {
+			log.Errorf("Failed to search code for %v: %v\n", query, err)
+			return err
+		}
+		if result.GetTotal() > githubSearchLimit || result.GetIncompleteResults() {
+			return fmt.Errorf("%w: %v has %v results", ErrSearchIncomplete, query, result.GetTotal())
+		}
+		log.Debugf("Code search %v: %v results\n", query, result.GetTotal())
+		for _, codeResult := range result.CodeResults {
+			hits.add(strconv.FormatInt(codeResult.GetRepository().GetID(), 10), codeResult.GetPath())
+		}
+
+		// The search rate limit is separate from the core one, so wait out its window here
+		if resp.Rate.Remaining == 0 && resp.NextPage != 0 {
+			log.Debugf("Code search rate limit reached, pausing until %v\n", resp.Rate.Reset.Time)
+			time.Sleep(time.Until(resp.Rate.Reset.Time))
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opt.Page = resp.NextPage
+	}
 }
 
-// unfinished
-func (g *GithubClient) SearchRepoForFilename(org string, repo string, filename string, fileExtension string) (*github.CodeSearchResult, error) {
-	orgNameQuery := fmt.Sprintf("org:%v", org)
-	repoQuery := fmt.Sprintf("repo:%v", repo)
-	filenameQuery := fmt.Sprintf("filename:%v", filename)
-	//filenameQuery := "package-lock.json"
-	fileExtenionQuery := fmt.Sprintf("extension:%v", fileExtension)
-	//fileContentsQuery := "in:path"
-
-	searchQuery := fmt.Sprintf("%s %s %s %s",
-		orgNameQuery,
-		repoQuery,
-		filenameQuery,
-		fileExtenionQuery,
-		//fileContentsQuery,
-	)
-
-	result, resp, err := g.Client.Search.Code(g.Ctx, searchQuery, nil)
+// GetFiles fetches the files at paths on ref, e.g. the ones SearchFiles found. The search index can
+// lag the repo, so a path that can't be fetched is logged and left out.
+func (g *GithubClient) GetFiles(projectId string, ref string, paths []string) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	repo, err := g.getRepoByKey(projectId)
 	if err != nil {
-		log.Printf("search failed: %v\n", err)
 		return nil, err
 	}
-	_ = resp
+	owner := repo.GetOwner().GetLogin()
+	refName, _ := utils.SplitRef(ref)
 
-	return result, nil
+	for _, path := range paths {
+		contentHandle, err := g.Client.Repositories.DownloadContents(g.Ctx, owner, repo.GetName(), path, &github.RepositoryContentGetOptions{Ref: refName})
+		if err != nil {
+			log.Warnf("Failed to DownloadContents for %v in repo:%v, skipping: %v\n", path, repo.GetName(), err)
+			continue
+		}
+		content, err := ioutil.ReadAll(contentHandle)
+		contentHandle.Close()
+		if err != nil {
+			log.Errorf("Failed to read bytes from %v: %v\n", path, err)
+			return nil, err
+		}
+		retFiles = append(retFiles, &structs.VcsFile{
+			Name:    filepath.Base(path),
+			Path:    path,
+			Id:      gitBlobSHA(content),
+			Content: content,
+		})
+	}
+	return retFiles, nil
 }
 
 // GetTree: only to be used when Truncated is set in ListFiles and we have to do it iteratively
-func (g *GithubClient) GetTree(repoName string, commitSHA string, treePath string) (*github.Tree, error) {
+func (g *GithubClient) GetTree(owner string, repoName string, commitSHA string, treePath string) (*github.Tree, error) {
 	var resultsTree github.Tree
 
 	// note that we're descending
 	log.Warnf("GH_GetTree for repo:%v\n", repoName)
 
 	// first try a recurisve request to GetTree
-	ghTree, _, err := g.Client.Git.GetTree(g.Ctx, g.OrgName, repoName, commitSHA, true)
+	ghTree, _, err := g.Client.Git.GetTree(g.Ctx, owner, repoName, commitSHA, true)
 	if err != nil {
 		if !handleErr("GetTree", err) {
 			log.Errorf("Failed to GetTree from %v: %v\n", repoName, err)
@@ -170,7 +529,7 @@ func (g *GithubClient) GetTree(repoName string, commitSHA string, treePath strin
 
 	// If the response is truncated, go iterative
 	if *ghTree.Truncated {
-		ghTree, _, err = g.Client.Git.GetTree(g.Ctx, g.OrgName, repoName, commitSHA, false)
+		ghTree, _, err = g.Client.Git.GetTree(g.Ctx, owner, repoName, commitSHA, false)
 		if err != nil {
 			if !handleErr("GetTree", err) {
 				log.Errorf("Failed to GetTree from %v: %v\n", repoName, err)
@@ -195,7 +554,7 @@ func (g *GithubClient) GetTree(repoName string, commitSHA string, treePath strin
 			resultsTree.Entries = append(resultsTree.Entries, *tempEntry)
 		case "tree": // directory
 			if *ghTree.Truncated {
-				tempTree, err := g.GetTree(repoName, *treeEntry.SHA, *treeEntry.Path)
+				tempTree, err := g.GetTree(owner, repoName, *treeEntry.SHA, *treeEntry.Path)
 				if err != nil {
 					if !handleErr("GetTree (subtree)", err) {
 						log.Errorf("Failed to GetTree (subtree) from %v: %v\n", repoName, err)
@@ -214,21 +573,29 @@ func (g *GithubClient) GetTree(repoName string, commitSHA string, treePath strin
 	return &resultsTree, nil
 }
 
-func (g *GithubClient) ListFiles(repoName string, branch string) (*github.Tree, error) {
+// ListFiles lists every file in the tree at the tip of branch, which may be a branch name or a
+// utils.TagRef
+func (g *GithubClient) ListFiles(owner string, repoName string, branch string) (*github.Tree, error) {
 	var resultsTree github.Tree
 
-	commits, resp, err := g.Client.Repositories.ListCommits(g.Ctx, g.OrgName, repoName, &github.CommitsListOptions{})
+	refName, _ := utils.SplitRef(branch)
+	commits, _, err := g.Client.Repositories.ListCommits(g.Ctx, owner, repoName, &github.CommitsListOptions{
+		SHA:         refName,
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
 	if err != nil {
-		log.Errorf("GH_ListFiles: failed to ListCommits from %v: %v\n", repoName, err)
-		log.Errorf("%v\n", resp.StatusCode)
+		log.Errorf("GH_ListFiles: failed to ListCommits from %v at %v: %v\n", repoName, refName, err)
 		return nil, err
 	}
+	if len(commits) == 0 {
+		return nil, nil
+	}
 
 	// Get the latest commmit SHA for the repo and branch
 	lastCommitSHA := *commits[0].SHA
 
 	// Get the tree of objects based on the commit SHA
-	ghTree, resp, err := g.Client.Git.GetTree(g.Ctx, g.OrgName, repoName, lastCommitSHA, true)
+	ghTree, _, err := g.Client.Git.GetTree(g.Ctx, owner, repoName, lastCommitSHA, true)
 	if err != nil {
 		log.Errorf("Failed to GetTree from %v: %v\n", repoName, err)
 		return nil, err
@@ -236,7 +603,7 @@ func (g *GithubClient) ListFiles(repoName string, branch string) (*github.Tree,
 	//resultsTree.Truncated = ghTree.Truncated
 
 	if *ghTree.Truncated {
-		repo, _, err := g.Client.Repositories.Get(g.Ctx, g.OrgName, repoName)
+		repo, _, err := g.Client.Repositories.Get(g.Ctx, owner, repoName)
 		if err != nil {
 			log.Errorf("GH_ListFiles: Failed to Get Repo %v: %v\n", repoName, err)
 			return nil, err
@@ -250,7 +617,7 @@ func (g *GithubClient) ListFiles(repoName string, branch string) (*github.Tree,
 
 		// No, this is just a monster project
 		log.Infof("GH_ListFiles: Found an incredibly large GitTree: %v - descending\n", repoName)
-		tempTree, err := g.GetTree(repoName, lastCommitSHA, "")
+		tempTree, err := g.GetTree(owner, repoName, lastCommitSHA, "")
 		if err != nil {
 			log.Errorf("Failed to GetTree from %v: %v\n", repoName, err)
 			return nil, err
@@ -274,17 +641,25 @@ func (g *GithubClient) ListFiles(repoName string, branch string) (*github.Tree,
 }
 
 // func (g *GithubClient) GetLockfilesByProject(repoName string, mainBranchName string) ([]*structs.VcsFile, error) {
-func (g *GithubClient) GetLockfilesByProject(projectId int64, mainBranchName string) ([]*structs.VcsFile, error) {
-	var retLockfiles []*structs.VcsFile
+func (g *GithubClient) GetLockfilesByProject(projectId string, mainBranchName string) ([]*structs.VcsFile, error) {
+	return g.GetFilesByProject(projectId, mainBranchName, utils.IsLockfile)
+}
+
+// GetFilesByProject fetches every file on the branch whose path satisfies match
+func (g *GithubClient) GetFilesByProject(projectId string, mainBranchName string, match func(string) bool) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
 
 	// Get Repo name via ID
-	repo, _, err := g.Client.Repositories.GetByID(g.Ctx, projectId)
+	repo, err := g.getRepoByKey(projectId)
 	if err != nil {
-		log.Errorf("Failed to GetRepoByID %v: %v\n", projectId, err)
 		return nil, err
 	}
 
-	projectTree, err := g.ListFiles(*repo.Name, mainBranchName)
+	// The repo's own owner, not the configured org, so repos from other orgs and users resolve
+	owner := repo.GetOwner().GetLogin()
+
+	refName, _ := utils.SplitRef(mainBranchName)
+	projectTree, err := g.ListFiles(owner, *repo.Name, mainBranchName)
 	if err != nil {
 		log.Errorf("Failed to ListFiles for %v: %v\n", *repo.Name, err)
 		return nil, err
@@ -293,13 +668,11 @@ func (g *GithubClient) GetLockfilesByProject(projectId int64, mainBranchName str
 		return nil, nil
 	}
 
-	supportedLockfiles := utils.GetSupportedLockfiles()
-
 	for _, file := range projectTree.Entries {
 		fileName := filepath.Base(*file.Path)
-		if slices.Contains(supportedLockfiles, fileName) || strings.HasSuffix(fileName, ".csproj") {
-			log.Debugf("Lockfile: %v in %v from project: %v\n", fileName, *file.Path, *repo.Name)
-			contentHandle, err := g.Client.Repositories.DownloadContents(g.Ctx, g.OrgName, *repo.Name, *file.Path, &github.RepositoryContentGetOptions{})
+		if match(*file.Path) {
+			log.Debugf("File: %v in %v from project: %v\n", fileName, *file.Path, *repo.Name)
+			contentHandle, err := g.Client.Repositories.DownloadContents(g.Ctx, owner, *repo.Name, *file.Path, &github.RepositoryContentGetOptions{Ref: refName})
 			if err != nil {
 				log.Errorf("Failed to DownloadContents for %v in repo:%v: %v", *file.Path, *repo.Name, err)
 				return nil, err
@@ -309,7 +682,7 @@ func (g *GithubClient) GetLockfilesByProject(projectId int64, mainBranchName str
 				log.Errorf("Failed to read bytes from %v: %v\n", *file.Path, err)
 				return nil, err
 			}
-			retLockfiles = append(retLockfiles, &structs.VcsFile{
+			retFiles = append(retFiles, &structs.VcsFile{
 				Name:          fileName,
 				Path:          *file.Path,
 				Id:            *file.SHA,
@@ -319,5 +692,264 @@ func (g *GithubClient) GetLockfilesByProject(projectId int64, mainBranchName str
 
 		}
 	}
-	return retLockfiles, nil
+	return retFiles, nil
+}
+
+// GetHead returns the commit SHA the branch points at
+func (g *GithubClient) GetHead(projectId string, branch string) (string, error) {
+	repo, err := g.getRepoByKey(projectId)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		ghBranch, _, err := g.Client.Repositories.GetBranch(g.Ctx, repo.GetOwner().GetLogin(), repo.GetName(), branch)
+		if handleErr("GH_GetBranch", err) {
+			continue
+		} else if err != nil {
+			log.Errorf("Failed to GetBranch %v for %v: %v\n", branch, repo.GetName(), err)
+			return "", err
+		}
+		return ghBranch.GetCommit().GetSHA(), nil
+	}
+}
+
+// ListRefs lists the names of a repo's branches and tags
+func (g *GithubClient) ListRefs(projectId string) ([]string, []string, error) {
+	var branches, tags []string
+
+	repo, err := g.getRepoByKey(projectId)
+	if err != nil {
+		return nil, nil, err
+	}
+	owner := repo.GetOwner().GetLogin()
+
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		githubBranches, resp, err := g.Client.Repositories.ListBranches(g.Ctx, owner, repo.GetName(), opts)
+		if handleErr("GH_ListBranches", err) {
+			continue
+		} else if err != nil {
+			log.Errorf("Failed to ListBranches for %v: %v\n", repo.GetName(), err)
+			return nil, nil, err
+		}
+		for _, branch := range githubBranches {
+			branches = append(branches, branch.GetName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	opts = &github.ListOptions{PerPage: 100}
+	for {
+		githubTags, resp, err := g.Client.Repositories.ListTags(g.Ctx, owner, repo.GetName(), opts)
+		if handleErr("GH_ListTags", err) {
+			continue
+		} else if err != nil {
+			log.Errorf("Failed to ListTags for %v: %v\n", repo.GetName(), err)
+			return nil, nil, err
+		}
+		for _, tag := range githubTags {
+			tags = append(tags, tag.GetName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return branches, tags, nil
+}
+
+// ListPullRequests lists a repo's open pull requests
+func (g *GithubClient) ListPullRequests(projectId string) ([]*structs.PullRequest, error) {
+	var retPullRequests []*structs.PullRequest
+
+	repo, err := g.getRepoByKey(projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &github.PullRequestListOptions{State: "open", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		pulls, resp, err := g.Client.PullRequests.List(g.Ctx, repo.GetOwner().GetLogin(), repo.GetName(), opts)
+		if handleErr("GH_ListPullRequests", err) {
+			continue
+		} else if err != nil {
+			log.Errorf("Failed to list pull requests for %v: %v\n", repo.GetName(), err)
+			return nil, err
+		}
+		for _, pull := range pulls {
+			retPullRequests = append(retPullRequests, &structs.PullRequest{
+				Id:         strconv.Itoa(pull.GetNumber()),
+				Title:      pull.GetTitle(),
+				Url:        pull.GetHTMLURL(),
+				HeadBranch: pull.GetHead().GetRef(),
+				HeadSha:    pull.GetHead().GetSHA(),
+				HeadRepo:   pull.GetHead().GetRepo().GetFullName(),
+			})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return retPullRequests, nil
+}
+
+// GetPullRequestLockfiles fetches the lockfiles a pull request adds or modifies at its head
+// commit. The base repo has the head commits of pull requests from forks too.
+func (g *GithubClient) GetPullRequestLockfiles(projectId string, pullRequest *structs.PullRequest) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	repo, err := g.getRepoByKey(projectId)
+	if err != nil {
+		return nil, err
+	}
+	owner := repo.GetOwner().GetLogin()
+	number, err := strconv.Atoi(pullRequest.Id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pull request number %v: %v", pullRequest.Id, err)
+	}
+
+	var changedPaths []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := g.Client.PullRequests.ListFiles(g.Ctx, owner, repo.GetName(), number, opts)
+		if handleErr("GH_ListPullRequestFiles", err) {
+			continue
+		} else if err != nil {
+			log.Errorf("Failed to list files of pull request %v in %v: %v\n", number, repo.GetName(), err)
+			return nil, err
+		}
+		for _, file := range files {
+			if file.GetStatus() != "removed" && utils.IsLockfile(file.GetFilename()) {
+				changedPaths = append(changedPaths, file.GetFilename())
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	for _, path := range changedPaths {
+		contentHandle, err := g.Client.Repositories.DownloadContents(g.Ctx, owner, repo.GetName(), path, &github.RepositoryContentGetOptions{Ref: pullRequest.HeadSha})
+		if err != nil {
+			log.Errorf("Failed to DownloadContents for %v at %v in repo:%v: %v", path, pullRequest.HeadSha, repo.GetName(), err)
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(contentHandle)
+		contentHandle.Close()
+		if err != nil {
+			log.Errorf("Failed to read bytes from %v: %v\n", path, err)
+			return nil, err
+		}
+		retFiles = append(retFiles, &structs.VcsFile{
+			Name:    filepath.Base(path),
+			Path:    path,
+			Id:      pullRequest.HeadSha,
+			Content: b,
+		})
+	}
+	return retFiles, nil
+}
+
+// OpenChangeRequest branches from change.BaseBranch, commits each file through the contents API
+// and opens a pull request
+func (g *GithubClient) OpenChangeRequest(projectId string, change *structs.ChangeRequest) (string, error) {
+	repo, err := g.getRepoByKey(projectId)
+	if err != nil {
+		return "", err
+	}
+	owner := repo.GetOwner().GetLogin()
+
+	baseRef, _, err := g.Client.Git.GetRef(g.Ctx, owner, repo.GetName(), "heads/"+change.BaseBranch)
+	if err != nil {
+		log.Errorf("Failed to GetRef %v in %v: %v\n", change.BaseBranch, repo.GetName(), err)
+		return "", err
+	}
+	_, _, err = g.Client.Git.CreateRef(g.Ctx, owner, repo.GetName(), &github.Reference{
+		Ref:    github.String("refs/heads/" + change.HeadBranch),
+		Object: &github.GitObject{SHA: baseRef.GetObject().SHA},
+	})
+	if err != nil {
+		log.Errorf("Failed to CreateRef %v in %v: %v\n", change.HeadBranch, repo.GetName(), err)
+		return "", err
+	}
+
+	for _, file := range change.Files {
+		fileOpts := &github.RepositoryContentFileOptions{
+			Message: github.String(change.CommitMessage),
+			Content: file.Content,
+			Branch:  github.String(change.HeadBranch),
+		}
+		if file.Previous == nil {
+			_, _, err = g.Client.Repositories.CreateFile(g.Ctx, owner, repo.GetName(), file.Path, fileOpts)
+		} else {
+			fileOpts.SHA = github.String(file.Previous.Id)
+			_, _, err = g.Client.Repositories.UpdateFile(g.Ctx, owner, repo.GetName(), file.Path, fileOpts)
+		}
+		if err != nil {
+			log.Errorf("Failed to commit %v to %v: %v\n", file.Path, repo.GetName(), err)
+			return "", err
+		}
+	}
+
+	pull, _, err := g.Client.PullRequests.Create(g.Ctx, owner, repo.GetName(), &github.NewPullRequest{
+		Title: github.String(change.Title),
+		Head:  github.String(change.HeadBranch),
+		Base:  github.String(change.BaseBranch),
+		Body:  github.String(change.Body),
+	})
+	if err != nil {
+		log.Errorf("Failed to create pull request in %v: %v\n", repo.GetName(), err)
+		return "", err
+	}
+	return pull.GetHTMLURL(), nil
+}
+
+// DownloadArchive downloads a tarball of the ref from the signed link the archive API redirects to
+func (g *GithubClient) DownloadArchive(projectId string, ref string) (*Archive, error) {
+	repo, err := g.getRepoByKey(projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	refName, _ := utils.SplitRef(ref)
+	archiveUrl, _, err := g.Client.Repositories.GetArchiveLink(g.Ctx, repo.GetOwner().GetLogin(), repo.GetName(), github.Tarball, &github.RepositoryContentGetOptions{Ref: refName})
+	if err != nil {
+		log.Errorf("Failed to GetArchiveLink for %v at %v: %v\n", repo.GetName(), refName, err)
+		return nil, err
+	}
+	resp, err := g.HttpClient.Get(archiveUrl.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET archive of %v returned %v", repo.GetName(), resp.Status)
+	}
+	return &Archive{Body: resp.Body, Format: ArchiveTarGz, StripPrefix: true}, nil
+}
+
+func (g *GithubClient) GetCloneTarget(projectId string) (*CloneTarget, error) {
+	repo, err := g.getRepoByKey(projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := g.TokenSource.Token()
+	if err != nil {
+		log.Errorf("Failed to get github token for clone: %v\n", err)
+		return nil, err
+	}
+
+	return &CloneTarget{
+		Url:        repo.GetCloneURL(),
+		AuthHeader: basicAuthHeader("x-access-token", token.AccessToken),
+	}, nil
 }
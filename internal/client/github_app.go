@@ -0,0 +1,162 @@
+package client
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// GitHub rejects app JWTs that expire more than 10 minutes out
+	githubAppJWTLifetime = 9 * time.Minute
+	// Refresh installation tokens a little before GitHub expires them so in-flight requests don't fail
+	githubInstallationTokenMargin = 5 * time.Minute
+)
+
+// GithubAppTokenSource mints installation access tokens for a GitHub App. Wrap it in
+// oauth2.ReuseTokenSource so a token is only exchanged again once the previous one expires.
+type GithubAppTokenSource struct {
+	AppId          string
+	InstallationId string
+	PrivateKey     *rsa.PrivateKey
+	BaseUrl        string
+	Client         *http.Client
+}
+
+type githubInstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewGithubAppTokenSource reads the app's private key PEM and returns a refreshing token source.
+// baseUrl is the REST API root, "" for api.github.com. httpClient is used for the token exchange;
+// nil uses a plain client.
+func NewGithubAppTokenSource(appId string, installationId string, privateKeyPath string, baseUrl string, httpClient *http.Client) (oauth2.TokenSource, error) {
+	pemData, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read github app private key %v: %v", privateKeyPath, err)
+	}
+	privateKey, err := ParseGithubAppPrivateKey(pemData)
+	if err != nil {
+		return nil, err
+	}
+
+	if baseUrl == "" {
+		baseUrl = "https://api.github.com/"
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	source := &GithubAppTokenSource{
+		AppId:          appId,
+		InstallationId: installationId,
+		PrivateKey:     privateKey,
+		BaseUrl:        strings.TrimSuffix(baseUrl, "/"),
+		Client:         httpClient,
+	}
+	return oauth2.ReuseTokenSource(nil, source), nil
+}
+
+// ParseGithubAppPrivateKey accepts the PKCS#1 key GitHub generates as well as PKCS#8
+func ParseGithubAppPrivateKey(pemData []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("github app private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse github app private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("github app private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// AppJWT returns a signed RS256 JWT identifying the app itself
+func (s *GithubAppTokenSource) AppJWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	// iat is backdated to allow for clock drift, per GitHub's docs
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(githubAppJWTLifetime).Unix(),
+		"iss": s.AppId,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := fmt.Sprintf("%v.%v", base64.RawURLEncoding.EncodeToString(header), base64.RawURLEncoding.EncodeToString(claims))
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%v.%v", signingInput, base64.RawURLEncoding.EncodeToString(signature)), nil
+}
+
+// Token exchanges a fresh app JWT for an installation access token
+func (s *GithubAppTokenSource) Token() (*oauth2.Token, error) {
+	appJWT, err := s.AppJWT(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign github app JWT: %v", err)
+	}
+
+	tokenUrl := fmt.Sprintf("%v/app/installations/%v/access_tokens", s.BaseUrl, s.InstallationId)
+	req, err := http.NewRequest(http.MethodPost, tokenUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", appJWT))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request github installation token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("github installation token request returned %v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var installationToken githubInstallationToken
+	if err := json.Unmarshal(body, &installationToken); err != nil {
+		return nil, fmt.Errorf("failed to parse github installation token: %v", err)
+	}
+	log.Debugf("Minted github installation token for installation %v, expires %v\n", s.InstallationId, installationToken.ExpiresAt)
+
+	return &oauth2.Token{
+		AccessToken: installationToken.Token,
+		TokenType:   "Bearer",
+		Expiry:      installationToken.ExpiresAt.Add(-githubInstallationTokenMargin),
+	}, nil
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestPrivateKey(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "app.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyPath, pemData, 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return key, keyPath
+}
+
+func TestGithubAppTokenSource_Token(t *testing.T) {
+	key, keyPath := writeTestPrivateKey(t)
+
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		wantMints int
+	}{
+		// A long-lived token is reused for the second call
+		{"reused", time.Hour, 1},
+		// A token inside the refresh margin is minted again
+		{"refreshed", time.Minute, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mints := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/app/installations/42/access_tokens" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				// Verify the app JWT was signed with the app's key and names the app as issuer
+				parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+				if len(parts) != 3 {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+				digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+				if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				claimsJson, _ := base64.RawURLEncoding.DecodeString(parts[1])
+				var claims map[string]interface{}
+				if err := json.Unmarshal(claimsJson, &claims); err != nil || claims["iss"] != "1234" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				mints++
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"token": "ghs_%d", "expires_at": "%v"}`, mints, time.Now().Add(tt.expiresIn).UTC().Format(time.RFC3339))
+			}))
+			defer server.Close()
+
+			ts, err := NewGithubAppTokenSource("1234", "42", keyPath, server.URL+"/", nil)
+			if err != nil {
+				t.Errorf("NewGithubAppTokenSource() error = %v", err)
+				return
+			}
+
+			for i := 0; i < 2; i++ {
+				token, err := ts.Token()
+				if err != nil {
+					t.Errorf("Token() error = %v", err)
+					return
+				}
+				if !strings.HasPrefix(token.AccessToken, "ghs_") {
+					t.Errorf("Token() got = %v", token.AccessToken)
+				}
+			}
+			if mints != tt.wantMints {
+				t.Errorf("Token() installation tokens minted got = %v, want %v", mints, tt.wantMints)
+			}
+		})
+	}
+}
+
+func TestParseGithubAppPrivateKey(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	tests := []struct {
+		name    string
+		pemData []byte
+		wantErr bool
+	}{
+		{"pkcs1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), false},
+		{"pkcs8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), false},
+		{"not pem", []byte("nope"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseGithubAppPrivateKey(tt.pemData)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseGithubAppPrivateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
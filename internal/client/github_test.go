@@ -1,9 +1,15 @@
 package client
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/go-github/github"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/peterjmorgan/Syringe/internal/utils"
@@ -54,6 +60,7 @@ func TestGithubClient_ListFiles(t *testing.T) {
 	g.OrgName = "phylum-dev"
 
 	type args struct {
+		owner    string
 		repoName string
 		branch   string
 	}
@@ -65,11 +72,11 @@ func TestGithubClient_ListFiles(t *testing.T) {
 		wantLen int
 		wantErr bool
 	}{
-		{"phylum-dev/cli", args{"cli", "main"}, nil, 191, false},
+		{"phylum-dev/cli", args{"phylum-dev", "cli", "main"}, nil, 191, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := g.ListFiles(tt.args.repoName, tt.args.branch)
+			got, err := g.ListFiles(tt.args.owner, tt.args.repoName, tt.args.branch)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ListFiles() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -96,7 +103,7 @@ func TestGithubClient_GetLockfilesByProject(t *testing.T) {
 	g.OrgName = "Updater"
 
 	type args struct {
-		projectId int64
+		projectId string
 		branch    string
 	}
 
@@ -108,7 +115,7 @@ func TestGithubClient_GetLockfilesByProject(t *testing.T) {
 		wantErr bool
 	}{
 		// Only has 1 yarn.lock
-		{"Updater/pactpoc", args{423562774, "master"}, nil, 1, false},
+		{"Updater/pactpoc", args{structs.NewProjectKey("github", "github.com", "Updater", "423562774"), "master"}, nil, 1, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -143,33 +150,612 @@ func TestGithubClient_GetLockfilesByProject(t *testing.T) {
 //	_ = testResult
 //
 //}
-//
-//func TestGithubClient_SearchOrgForFilename(t *testing.T) {
-//	configData, err := utils.ReadConfigFile()
-//	if err != nil {
-//		fmt.Printf("failed to read config file: %v\n", err)
-//	}
-//
-//	g := NewGithubClient(configData, &structs.SyringeOptions{})
-//	testResult, err := g.SearchOrgForFilename("Updater", "package-lock.json", "csproj")
-//	if err != nil {
-//		fmt.Printf("failed to search")
-//	}
-//	_ = testResult
-//
-//}
-//
-//func TestGithubClient_SearchRepoForFilename(t *testing.T) {
-//	configData, err := utils.ReadConfigFile()
-//	if err != nil {
-//		fmt.Printf("failed to read config file: %v\n", err)
-//	}
-//
-//	g := NewGithubClient(configData, &structs.SyringeOptions{})
-//	testResult, err := g.SearchRepoForFilename("Updater", "pactpoc", "yarn.lock", "lock")
-//	if err != nil {
-//		fmt.Printf("failed to search")
-//	}
-//	_ = testResult
-//
-//}
+
+func TestGithubEnterpriseUrls(t *testing.T) {
+	type args struct {
+		githubUrl string
+		uploadUrl string
+	}
+	tests := []struct {
+		name          string
+		args          args
+		wantBaseUrl   string
+		wantUploadUrl string
+	}{
+		{"github.com", args{"", ""}, "", ""},
+		{"api.github.com", args{"https://api.github.com/", ""}, "", ""},
+		{"bare host", args{"https://ghe.example.com", ""}, "https://ghe.example.com/api/v3/", "https://ghe.example.com/api/uploads/"},
+		{"api path", args{"https://ghe.example.com/api/v3", ""}, "https://ghe.example.com/api/v3/", "https://ghe.example.com/api/uploads/"},
+		{"explicit upload", args{"https://ghe.example.com/api/v3/", "https://uploads.example.com/"}, "https://ghe.example.com/api/v3/", "https://uploads.example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotBaseUrl, gotUploadUrl := GithubEnterpriseUrls(tt.args.githubUrl, tt.args.uploadUrl)
+			if gotBaseUrl != tt.wantBaseUrl {
+				t.Errorf("GithubEnterpriseUrls() baseUrl got = %v, want %v", gotBaseUrl, tt.wantBaseUrl)
+			}
+			if gotUploadUrl != tt.wantUploadUrl {
+				t.Errorf("GithubEnterpriseUrls() uploadUrl got = %v, want %v", gotUploadUrl, tt.wantUploadUrl)
+			}
+		})
+	}
+}
+
+func TestGithubClient_ListProjectsEnterprise(t *testing.T) {
+	// GHES with rate limiting disabled sends no X-RateLimit-* headers
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/orgs/acme/repos", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 1, "name": "api", "default_branch": "main"}, {"id": 2, "name": "web", "default_branch": "master"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	g := NewGithubClient(&structs.ConfigThing{
+		VcsType:  "github",
+		VcsToken: "testtoken",
+		Associated: map[string]string{
+			"githubUrl": server.URL,
+			"githubOrg": "acme",
+		},
+	}, &structs.SyringeOptions{})
+
+	tests := []struct {
+		name    string
+		want    *[]*structs.SyringeProject
+		wantLen int
+		wantErr bool
+	}{
+		{"acme", nil, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.ListProjects()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ListProjects() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("GithubClient_ListProjectsEnterprise() TypeOf got = %v, want %v", got, tt.want)
+			}
+
+			if len(*got) != tt.wantLen {
+				t.Errorf("GithubClient_ListProjectsEnterprise() len(projects) got = %v, want %v", len(*got), tt.wantLen)
+			}
+		})
+	}
+}
+
+// newTestGithubServer fakes two orgs that each have a repo named "api", plus a repo owned by the
+// authenticated user. Repo 20 (b/api) has a single package-lock.json at its root.
+func newTestGithubServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/a/repos", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 10, "name": "api", "default_branch": "main", "owner": {"login": "a"}}]`)
+	})
+	mux.HandleFunc("/orgs/b/repos", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 20, "name": "api", "default_branch": "main", "owner": {"login": "b"}}]`)
+	})
+	mux.HandleFunc("/user/repos", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"id": 30, "name": "dotfiles", "default_branch": "main", "owner": {"login": "me"}},
+			{"id": 20, "name": "api", "default_branch": "main", "owner": {"login": "b"}}
+		]`)
+	})
+	mux.HandleFunc("/installation/repositories", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"total_count": 2, "repositories": [
+			{"id": 40, "name": "app", "default_branch": "main", "owner": {"login": "c"}},
+			{"id": 10, "name": "api", "default_branch": "main", "owner": {"login": "a"}}
+		]}`)
+	})
+	mux.HandleFunc("/repositories/20", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 20, "name": "api", "default_branch": "main", "owner": {"login": "b"}}`)
+	})
+	mux.HandleFunc("/repos/b/api/commits", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"sha": "c0ffee"}]`)
+	})
+	mux.HandleFunc("/repos/b/api/git/trees/c0ffee", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"sha": "c0ffee", "truncated": false, "tree": [
+			{"path": "package-lock.json", "type": "blob", "sha": "b1"},
+			{"path": "src", "type": "tree", "sha": "t1"},
+			{"path": "src/index.js", "type": "blob", "sha": "b2"}
+		]}`)
+	})
+	mux.HandleFunc("/repos/b/api/contents/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"name": "package-lock.json", "path": "package-lock.json", "type": "file", "download_url": "http://%v/raw/b/api/package-lock.json"}]`, r.Host)
+	})
+	mux.HandleFunc("/raw/b/api/package-lock.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name": "b-api"}`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func newTestGithubClient(serverUrl string, orgs string, mineOnly bool) *GithubClient {
+	g := NewGithubClient(&structs.ConfigThing{
+		VcsType:    "github",
+		VcsToken:   "testtoken",
+		Associated: map[string]string{"githubOrg": orgs},
+	}, &structs.SyringeOptions{MineOnly: mineOnly})
+	g.Client.BaseURL, _ = url.Parse(serverUrl + "/")
+	return g
+}
+
+func TestGithubClient_ListProjectsMultiOwner(t *testing.T) {
+	server := newTestGithubServer()
+	defer server.Close()
+
+	tests := []struct {
+		name            string
+		orgs            string
+		mineOnly        bool
+		appInstallation bool
+		wantNames       map[string]string
+	}{
+		{"single org", "b", false, false, map[string]string{"b/20": "api"}},
+		{"two orgs", "a, b", false, false, map[string]string{"a/10": "a/api", "b/20": "b/api"}},
+		{"org and user", "a", true, false, map[string]string{"a/10": "a/api", "b/20": "b/api", "me/30": "me/dotfiles"}},
+		{"user only", "", false, false, map[string]string{"b/20": "api", "me/30": "dotfiles"}},
+		{"app installation", "", false, true, map[string]string{"a/10": "api", "c/40": "app"}},
+		{"app installation and org", "b", true, true, map[string]string{"a/10": "a/api", "b/20": "b/api", "c/40": "c/app"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGithubClient(server.URL, tt.orgs, tt.mineOnly)
+			g.AppInstallation = tt.appInstallation
+			got, err := g.ListProjects()
+			if err != nil {
+				t.Errorf("ListProjects() error = %v", err)
+				return
+			}
+
+			gotNames := make(map[string]string, len(*got))
+			for _, p := range *got {
+				_, _, owner, id, _ := structs.ParseProjectKey(p.Id)
+				gotNames[owner+"/"+id] = p.Name
+				if p.Owner == "" {
+					t.Errorf("ListProjects() missing owner for %v", p.Name)
+				}
+			}
+			if !reflect.DeepEqual(gotNames, tt.wantNames) {
+				t.Errorf("ListProjects() got = %v, want %v", gotNames, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestGithubClient_GetLockfilesByProjectOwner(t *testing.T) {
+	server := newTestGithubServer()
+	defer server.Close()
+
+	// The configured org is "a", but repo 20 belongs to "b"
+	g := newTestGithubClient(server.URL, "a", true)
+
+	got, err := g.GetLockfilesByProject(structs.NewProjectKey("github", g.Host(), "b", "20"), "main")
+	if err != nil {
+		t.Errorf("GetLockfilesByProject() error = %v", err)
+		return
+	}
+	if len(got) != 1 || string(got[0].Content) != `{"name": "b-api"}` {
+		t.Errorf("GetLockfilesByProject() got = %v", got)
+	}
+}
+
+func TestGithubClient_ListProjectsFilter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orgs/acme/repos" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `[
+			{"id": 1, "name": "app", "private": true, "visibility": "internal", "topics": ["team-a"], "owner": {"login": "acme"}},
+			{"id": 2, "name": "fork", "fork": true, "topics": ["team-a"], "owner": {"login": "acme"}},
+			{"id": 3, "name": "old", "archived": true, "topics": ["team-a"], "owner": {"login": "acme"}},
+			{"id": 4, "name": "starter", "is_template": true, "topics": ["team-a"], "owner": {"login": "acme"}},
+			{"id": 5, "name": "site", "topics": ["team-b"], "owner": {"login": "acme"}},
+			{"id": 6, "name": "scratch", "topics": ["team-a", "sandbox"], "owner": {"login": "acme"}}
+		]`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		filter      structs.RepoFilter
+		wantNames   []string
+		wantSkipped map[string]string
+	}{
+		{"no filter", structs.RepoFilter{}, []string{"app", "fork", "old", "starter", "site", "scratch"}, map[string]string{}},
+		{"forks archived templates", structs.RepoFilter{ExcludeForks: true, ExcludeArchived: true, ExcludeTemplates: true},
+			[]string{"app", "site", "scratch"}, map[string]string{"fork": "fork", "old": "archived", "starter": "template"}},
+		{"visibility", structs.RepoFilter{Visibility: "public"},
+			[]string{"fork", "old", "starter", "site", "scratch"}, map[string]string{"app": "visibility is internal"}},
+		{"topics", structs.RepoFilter{IncludeTopics: []string{"team-a"}, ExcludeTopics: []string{"sandbox"}},
+			[]string{"app", "fork", "old", "starter"}, map[string]string{"site": "has none of the included topics", "scratch": "has excluded topic sandbox"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGithubClient(server.URL, "acme", false)
+			g.Filter = tt.filter
+
+			got, err := g.ListProjects()
+			if err != nil {
+				t.Errorf("ListProjects() error = %v", err)
+				return
+			}
+
+			var gotNames []string
+			for _, p := range *got {
+				gotNames = append(gotNames, p.Name)
+			}
+			gotSkipped := map[string]string{}
+			for _, skipped := range g.SkippedProjects() {
+				gotSkipped[skipped.Name] = skipped.Reason
+			}
+			if !reflect.DeepEqual(gotNames, tt.wantNames) {
+				t.Errorf("ListProjects() got = %v, want %v", gotNames, tt.wantNames)
+			}
+			if !reflect.DeepEqual(gotSkipped, tt.wantSkipped) {
+				t.Errorf("SkippedProjects() got = %v, want %v", gotSkipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestGithubRepoFilter(t *testing.T) {
+	associated := map[string]string{
+		"githubExcludeForks":  "true",
+		"githubVisibility":    "Private",
+		"githubIncludeTopics": "team-a, team-b",
+	}
+
+	got := GithubRepoFilter(associated, &structs.SyringeOptions{Filter: structs.RepoFilter{
+		ExcludeArchived: true,
+		IncludeTopics:   []string{"team-c"},
+	}})
+	want := structs.RepoFilter{
+		ExcludeForks:    true,
+		ExcludeArchived: true,
+		Visibility:      "private",
+		IncludeTopics:   []string{"team-c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GithubRepoFilter() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestGithubClient_OpenChangeRequest(t *testing.T) {
+	var gotRequests []string
+	var gotRef, gotContent, gotSha string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repositories/20", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 20, "name": "api", "default_branch": "main", "owner": {"login": "b"}}`)
+	})
+	mux.HandleFunc("/repos/b/api/git/refs/heads/main", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ref": "refs/heads/main", "object": {"type": "commit", "sha": "c0ffee"}}`)
+	})
+	mux.HandleFunc("/repos/b/api/git/refs", func(w http.ResponseWriter, r *http.Request) {
+		var ref struct{ Ref, Sha string }
+		json.NewDecoder(r.Body).Decode(&ref)
+		gotRequests = append(gotRequests, r.Method+" refs")
+		gotRef = ref.Ref + "@" + ref.Sha
+		fmt.Fprint(w, `{"ref": "refs/heads/syringe/phylum-ci", "object": {"type": "commit", "sha": "c0ffee"}}`)
+	})
+	mux.HandleFunc("/repos/b/api/contents/", func(w http.ResponseWriter, r *http.Request) {
+		var opts github.RepositoryContentFileOptions
+		json.NewDecoder(r.Body).Decode(&opts)
+		gotRequests = append(gotRequests, r.Method+" "+r.URL.Path)
+		gotContent = string(opts.Content)
+		gotSha = opts.GetSHA()
+		fmt.Fprint(w, `{}`)
+	})
+	mux.HandleFunc("/repos/b/api/pulls", func(w http.ResponseWriter, r *http.Request) {
+		var pull github.NewPullRequest
+		json.NewDecoder(r.Body).Decode(&pull)
+		gotRequests = append(gotRequests, r.Method+" pulls "+pull.GetHead()+"->"+pull.GetBase())
+		fmt.Fprint(w, `{"number": 7, "html_url": "https://github.example.com/b/api/pull/7"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	g := newTestGithubClient(server.URL, "b", false)
+	got, err := g.OpenChangeRequest(structs.NewProjectKey("github", g.Host(), "b", "20"), &structs.ChangeRequest{
+		BaseBranch:    "main",
+		HeadBranch:    "syringe/phylum-ci",
+		Title:         "Add Phylum",
+		CommitMessage: "Add Phylum",
+		Files: []*structs.FileChange{{
+			Path:     ".github/workflows/ci.yml",
+			Content:  []byte("name: ci\n"),
+			Previous: &structs.VcsFile{Id: "b1", Path: ".github/workflows/ci.yml"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("OpenChangeRequest() error = %v", err)
+	}
+	if got != "https://github.example.com/b/api/pull/7" {
+		t.Errorf("OpenChangeRequest() got = %v", got)
+	}
+
+	wantRequests := []string{"POST refs", "PUT /repos/b/api/contents/.github/workflows/ci.yml", "POST pulls syringe/phylum-ci->main"}
+	if !reflect.DeepEqual(gotRequests, wantRequests) {
+		t.Errorf("OpenChangeRequest() requests = %v, want %v", gotRequests, wantRequests)
+	}
+	if gotRef != "refs/heads/syringe/phylum-ci@c0ffee" || gotContent != "name: ci\n" || gotSha != "b1" {
+		t.Errorf("OpenChangeRequest() sent ref = %v, content = %q, sha = %v", gotRef, gotContent, gotSha)
+	}
+}
+
+func TestGithubClient_Refs(t *testing.T) {
+	var gotContentRefs []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repositories/20", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 20, "name": "api", "default_branch": "main", "owner": {"login": "b"}}`)
+	})
+	mux.HandleFunc("/repos/b/api/commits", func(w http.ResponseWriter, r *http.Request) {
+		// Each ref's tip commit has its own tree
+		switch r.URL.Query().Get("sha") {
+		case "release/1.0":
+			fmt.Fprint(w, `[{"sha": "re1ea5e"}]`)
+		case "v1.0.0":
+			fmt.Fprint(w, `[{"sha": "7a9"}]`)
+		default:
+			fmt.Fprint(w, `[{"sha": "c0ffee"}]`)
+		}
+	})
+	mux.HandleFunc("/repos/b/api/git/trees/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/c0ffee") {
+			fmt.Fprint(w, `{"truncated": false, "tree": [{"path": "package-lock.json", "type": "blob", "sha": "b1"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"truncated": false, "tree": [{"path": "yarn.lock", "type": "blob", "sha": "b2"}]}`)
+	})
+	mux.HandleFunc("/repos/b/api/contents/", func(w http.ResponseWriter, r *http.Request) {
+		gotContentRefs = append(gotContentRefs, r.URL.Query().Get("ref"))
+		fmt.Fprintf(w, `[{"name": "yarn.lock", "path": "yarn.lock", "type": "file", "download_url": "http://%v/raw/yarn.lock"}]`, r.Host)
+	})
+	mux.HandleFunc("/raw/yarn.lock", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `# yarn`)
+	})
+	mux.HandleFunc("/repos/b/api/branches", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `[{"name": "release/1.0"}]`)
+			return
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<http://%v/repos/b/api/branches?page=2>; rel="next"`, r.Host))
+		fmt.Fprint(w, `[{"name": "main"}]`)
+	})
+	mux.HandleFunc("/repos/b/api/tags", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"name": "v1.0.0"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	g := newTestGithubClient(server.URL, "b", false)
+	projectId := structs.NewProjectKey("github", g.Host(), "b", "20")
+
+	gotBranches, gotTags, err := g.ListRefs(projectId)
+	if err != nil {
+		t.Fatalf("ListRefs() error = %v", err)
+	}
+	if !reflect.DeepEqual(gotBranches, []string{"main", "release/1.0"}) || !reflect.DeepEqual(gotTags, []string{"v1.0.0"}) {
+		t.Errorf("ListRefs() got = %v, %v", gotBranches, gotTags)
+	}
+
+	for _, ref := range []string{"release/1.0", utils.TagRef("v1.0.0")} {
+		got, err := g.GetLockfilesByProject(projectId, ref)
+		if err != nil {
+			t.Fatalf("GetLockfilesByProject(%v) error = %v", ref, err)
+		}
+		if len(got) != 1 || got[0].Path != "yarn.lock" {
+			t.Errorf("GetLockfilesByProject(%v) got = %v, want the ref's yarn.lock", ref, got)
+		}
+	}
+	if !reflect.DeepEqual(gotContentRefs, []string{"release/1.0", "v1.0.0"}) {
+		t.Errorf("DownloadContents refs = %v", gotContentRefs)
+	}
+}
+
+func TestGithubClient_PullRequests(t *testing.T) {
+	var gotContentRefs []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repositories/20", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 20, "name": "api", "default_branch": "main", "owner": {"login": "b"}}`)
+	})
+	mux.HandleFunc("/repos/b/api/pulls", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("state") != "open" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `[{"number": 12, "title": "Bump lodash", "html_url": "https://github.example.com/b/api/pull/12",
+			"head": {"ref": "bump-lodash", "sha": "fea7", "repo": {"full_name": "fork/api"}}}]`)
+	})
+	mux.HandleFunc("/repos/b/api/pulls/12/files", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"filename": "web/package-lock.json", "status": "modified"},
+			{"filename": "yarn.lock", "status": "removed"},
+			{"filename": "README.md", "status": "modified"}
+		]`)
+	})
+	mux.HandleFunc("/repos/b/api/contents/web", func(w http.ResponseWriter, r *http.Request) {
+		gotContentRefs = append(gotContentRefs, r.URL.Query().Get("ref"))
+		fmt.Fprintf(w, `[{"name": "package-lock.json", "path": "web/package-lock.json", "type": "file", "download_url": "http://%v/raw/package-lock.json"}]`, r.Host)
+	})
+	mux.HandleFunc("/raw/package-lock.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"lockfileVersion": 2}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	g := newTestGithubClient(server.URL, "b", false)
+	projectId := structs.NewProjectKey("github", g.Host(), "b", "20")
+
+	got, err := g.ListPullRequests(projectId)
+	if err != nil {
+		t.Fatalf("ListPullRequests() error = %v", err)
+	}
+	want := []*structs.PullRequest{{Id: "12", Title: "Bump lodash", Url: "https://github.example.com/b/api/pull/12", HeadBranch: "bump-lodash", HeadSha: "fea7", HeadRepo: "fork/api"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ListPullRequests() got = %v, want %v", got[0], want[0])
+	}
+
+	lockfiles, err := g.GetPullRequestLockfiles(projectId, got[0])
+	if err != nil {
+		t.Fatalf("GetPullRequestLockfiles() error = %v", err)
+	}
+	if len(lockfiles) != 1 || lockfiles[0].Path != "web/package-lock.json" || string(lockfiles[0].Content) != `{"lockfileVersion": 2}` {
+		t.Errorf("GetPullRequestLockfiles() got = %v", lockfiles)
+	}
+	if !reflect.DeepEqual(gotContentRefs, []string{"fea7"}) {
+		t.Errorf("GetPullRequestLockfiles() read contents at %v, want fea7", gotContentRefs)
+	}
+}
+
+func TestGithubClient_DownloadArchive(t *testing.T) {
+	tarball := makeTarGz(t, "b-api-c0ffee", map[string]string{"package-lock.json": "{}", "README.md": "readme"})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repositories/20", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 20, "name": "api", "default_branch": "main", "owner": {"login": "b"}}`)
+	})
+	mux.HandleFunc("/repos/b/api/tarball/v1.0.0", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", fmt.Sprintf("http://%v/codeload/b/api/tar.gz/v1.0.0?token=signed", r.Host))
+		w.WriteHeader(http.StatusFound)
+	})
+	mux.HandleFunc("/codeload/b/api/tar.gz/v1.0.0", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != "signed" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(tarball)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	g := newTestGithubClient(server.URL, "b", false)
+	archive, err := g.DownloadArchive(structs.NewProjectKey("github", g.Host(), "b", "20"), utils.TagRef("v1.0.0"))
+	if err != nil {
+		t.Fatalf("DownloadArchive() error = %v", err)
+	}
+	got, err := ArchiveFiles(archive, utils.IsLockfile)
+	if err != nil {
+		t.Fatalf("ArchiveFiles() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Path != "package-lock.json" || string(got[0].Content) != "{}" {
+		t.Errorf("DownloadArchive() files = %v", got)
+	}
+}
+
+func TestGithubClient_SearchFiles(t *testing.T) {
+	var gotQueries []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search/code", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("q")
+		gotQueries = append(gotQueries, query)
+		switch query {
+		case "org:b filename:package-lock.json":
+			// Two pages, the second repeating a hit and adding a near miss
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `{"total_count": 3, "items": [
+					{"path": "package-lock.json", "repository": {"id": 20}},
+					{"path": "package-lock.json.orig", "repository": {"id": 20}}
+				]}`)
+				return
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<http://%v/search/code?page=2>; rel="next"`, r.Host))
+			fmt.Fprint(w, `{"total_count": 3, "items": [
+				{"path": "package-lock.json", "repository": {"id": 20}},
+				{"path": "web/package-lock.json", "repository": {"id": 21}}
+			]}`)
+		case "org:b filename:Pipfile":
+			fmt.Fprint(w, `{"total_count": 2, "items": [
+				{"path": "Pipfile", "repository": {"id": 20}},
+				{"path": "Pipfile.lock", "repository": {"id": 20}}
+			]}`)
+		default:
+			fmt.Fprint(w, `{"total_count": 0, "items": []}`)
+		}
+	})
+	mux.HandleFunc("/repositories/20", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 20, "name": "api", "default_branch": "main", "owner": {"login": "b"}}`)
+	})
+	mux.HandleFunc("/repos/b/api/contents/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"name": "Pipfile", "path": "Pipfile", "type": "file", "download_url": "http://%v/raw/Pipfile"}]`, r.Host)
+	})
+	mux.HandleFunc("/raw/Pipfile", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[packages]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	g := newTestGithubClient(server.URL, "b", false)
+	g.SearchInterval = 0
+
+	got, err := g.SearchFiles(utils.IsLockfile)
+	if err != nil {
+		t.Fatalf("SearchFiles() error = %v", err)
+	}
+	want := map[string][]string{
+		"20": {"package-lock.json", "Pipfile", "Pipfile.lock"},
+		"21": {"web/package-lock.json"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchFiles() got = %v, want %v", got, want)
+	}
+	// Lockfiles only, so no CI file queries
+	for _, query := range gotQueries {
+		if strings.Contains(query, ".github/workflows") || strings.Contains(query, ".gitlab-ci") {
+			t.Errorf("SearchFiles() searched for CI files: %v", query)
+		}
+	}
+
+	// Pipfile.lock is gone since it was indexed, and is left out
+	files, err := g.GetFiles(structs.NewProjectKey("github", g.Host(), "b", "20"), "main", []string{"Pipfile", "Pipfile.lock"})
+	if err != nil {
+		t.Fatalf("GetFiles() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "Pipfile" || string(files[0].Content) != "[packages]" || files[0].Id != gitBlobSHA([]byte("[packages]")) {
+		t.Errorf("GetFiles() got = %v", files)
+	}
+}
+
+func TestGithubClient_SearchFilesIncomplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"total_count": 1500, "items": [{"path": "package-lock.json", "repository": {"id": 20}}]}`)
+	}))
+	defer server.Close()
+
+	g := newTestGithubClient(server.URL, "b", false)
+	g.SearchInterval = 0
+
+	if _, err := g.SearchFiles(utils.IsLockfile); !errors.Is(err, ErrSearchIncomplete) {
+		t.Errorf("SearchFiles() error = %v, want ErrSearchIncomplete", err)
+	}
+}
+
+func TestGithubClient_SearchFilesAppInstallation(t *testing.T) {
+	var gotPaths, gotQueries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPaths = append(gotPaths, r.URL.Path)
+		gotQueries = append(gotQueries, r.URL.Query().Get("q"))
+		fmt.Fprint(w, `{"total_count": 0, "items": []}`)
+	}))
+	defer server.Close()
+
+	// Installation tokens can't look up a user, so only the org is searched
+	g := newTestGithubClient(server.URL, "b", true)
+	g.AppInstallation = true
+	g.SearchInterval = 0
+	if _, err := g.SearchFiles(utils.IsLockfile); err != nil {
+		t.Fatalf("SearchFiles() error = %v", err)
+	}
+	for idx, path := range gotPaths {
+		if path != "/search/code" || !strings.HasPrefix(gotQueries[idx], "org:b ") {
+			t.Errorf("SearchFiles() requested %v %q", path, gotQueries[idx])
+		}
+	}
+
+	// Without an org the search has no scope and fails, falling back to the API
+	gotPaths = nil
+	g = newTestGithubClient(server.URL, "", false)
+	g.AppInstallation = true
+	if _, err := g.SearchFiles(utils.IsLockfile); err == nil || len(gotPaths) != 0 {
+		t.Errorf("SearchFiles() error = %v after %v requests, want an error and none", err, len(gotPaths))
+	}
+}
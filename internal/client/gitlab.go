@@ -1,11 +1,13 @@
 package client
 
 import (
-	"crypto/tls"
+	"context"
+	"fmt"
+	"io"
 	"net/http"
-	"net/url"
+	"path/filepath"
 	"reflect"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
@@ -14,13 +16,32 @@ import (
 	"github.com/schollz/progressbar/v3"
 	log "github.com/sirupsen/logrus"
 	"github.com/xanzy/go-gitlab"
-	"golang.org/x/exp/slices"
 	"golang.org/x/time/rate"
 )
 
 type GitlabClient struct {
 	Client   *gitlab.Client
 	MineOnly bool
+	Token    string
+	Groups   []string
+	Skipped  []*structs.SkippedProject
+	// paces requests by GitLab's RateLimit-* headers, see RateLimiter
+	rateLimiter *utils.AdaptiveRateLimitTransport
+	// delay between search requests, see SearchFiles
+	SearchInterval time.Duration
+	lastSearch     time.Time
+}
+
+// gitlabSearchInterval paces search requests: GitLab.com allows 30 a minute per user
+const gitlabSearchInterval = 2 * time.Second
+
+// gitlabRetryPolicy retries like retryablehttp, except for throttled requests: the rate limit
+// transport below has already waited for the reset and retried those
+func gitlabRetryPolicy(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if err == nil && resp != nil && utils.IsThrottled(resp) {
+		return false, nil
+	}
+	return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
 }
 
 // func NewGitlabClient(envMap map[string]string, mineOnly bool, ratelimit int, proxyUrl string) *GitlabClient {
@@ -31,7 +52,7 @@ func NewGitlabClient(configData *structs.ConfigThing, opts *structs.SyringeOptio
 	var mineOnly bool = false
 
 	clientOptions := []gitlab.ClientOptionFunc{
-		gitlab.WithCustomRetry(retryablehttp.DefaultRetryPolicy),
+		gitlab.WithCustomRetry(gitlabRetryPolicy),
 	}
 
 	if vcsUrl, ok := configData.Associated["vcsUrl"]; ok {
@@ -39,22 +60,11 @@ func NewGitlabClient(configData *structs.ConfigThing, opts *structs.SyringeOptio
 		clientOptions = append(clientOptions, gitlab.WithBaseURL(vcsUrl))
 	}
 
+	httpClient, rateLimiter := newRateLimitedHttpClient(opts, configData.VcsToken)
+	clientOptions = append(clientOptions, gitlab.WithHTTPClient(httpClient))
+
 	v := reflect.ValueOf(opts)
 	if v.Kind() == reflect.Ptr && !v.IsNil() {
-		if opts.ProxyUrl != "" {
-			theProxyUrl, err := url.Parse(opts.ProxyUrl)
-			if err != nil {
-				log.Errorf("failed to parse burpurl: %v\n", err)
-			}
-
-			proxyHttp := &http.Client{
-				Transport: &http.Transport{
-					Proxy:           http.ProxyURL(theProxyUrl),
-					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-				},
-			}
-			clientOptions = append(clientOptions, gitlab.WithHTTPClient(proxyHttp))
-		}
 		if opts.RateLimit != 0 {
 			clientOptions = append(clientOptions, gitlab.WithCustomLimiter(rate.NewLimiter(rate.Every(time.Second), opts.RateLimit)))
 		}
@@ -69,8 +79,12 @@ func NewGitlabClient(configData *structs.ConfigThing, opts *structs.SyringeOptio
 	}
 
 	return &GitlabClient{
-		Client:   gitlabClient,
-		MineOnly: mineOnly,
+		Client:         gitlabClient,
+		MineOnly:       mineOnly,
+		Token:          configData.VcsToken,
+		Groups:         utils.SplitList(configData.Associated["gitlabGroups"]),
+		rateLimiter:    rateLimiter,
+		SearchInterval: gitlabSearchInterval,
 	}
 }
 
@@ -78,7 +92,14 @@ func (g *GitlabClient) CheckResponse(resp *gitlab.Response) error {
 	return nil
 }
 
+// ListProjects lists the projects in the configured groups and their subgroups, or every project
+// the token can see when no groups are configured
 func (g *GitlabClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	g.Skipped = nil
+	if len(g.Groups) > 0 {
+		return g.listGroupsProjects()
+	}
+
 	var localProjects []*structs.SyringeProject
 	opt := &gitlab.ListProjectsOptions{
 		ListOptions: gitlab.ListOptions{
@@ -106,14 +127,10 @@ func (g *GitlabClient) ListProjects() (*[]*structs.SyringeProject, error) {
 
 		// Iterate through gitlabProjects and create SyringeProjects for each
 		for _, gitlabProject := range gitlabProjects {
-			localProjects = append(localProjects, &structs.SyringeProject{
-				Id:        int64(gitlabProject.ID),
-				Name:      gitlabProject.Name,
-				Branch:    gitlabProject.DefaultBranch,
-				Lockfiles: []*structs.VcsFile{},
-				CiFiles:   []*structs.VcsFile{},
-				Hydrated:  false,
-			})
+			if g.skipProject(gitlabProject, gitlabProject.Name) {
+				continue
+			}
+			localProjects = append(localProjects, g.syringeProject(gitlabProject, gitlabProject.Name))
 		}
 
 		if resp.NextPage == 0 {
@@ -127,44 +144,490 @@ func (g *GitlabClient) ListProjects() (*[]*structs.SyringeProject, error) {
 	return &localProjects, nil
 }
 
+// listGroupsProjects lists each configured group (by path or ID) including all of its subgroups.
+// Projects are named by their full path, e.g. acme/platform/api, so they stay unique across groups.
+func (g *GitlabClient) listGroupsProjects() (*[]*structs.SyringeProject, error) {
+	var localProjects []*structs.SyringeProject
+	seen := make(map[int]bool)
+
+	for _, group := range g.Groups {
+		opt := &gitlab.ListGroupProjectsOptions{
+			ListOptions: gitlab.ListOptions{
+				PerPage: 50,
+				Page:    0,
+			},
+			IncludeSubGroups: gitlab.Bool(true),
+			WithShared:       gitlab.Bool(false),
+			Owned:            gitlab.Bool(g.MineOnly),
+		}
+		listProjectsPB := progressbar.NewOptions(-1, progressbar.OptionSetDescription(fmt.Sprintf("Getting Projects in %v", group)))
+
+		for {
+			listProjectsPB.Add(1)
+
+			gitlabProjects, resp, err := g.Client.Groups.ListGroupProjects(group, opt)
+			if err != nil {
+				log.Errorf("Failed to list gitlab projects for group %v: %v\n", group, err)
+				return nil, err
+			}
+
+			for _, gitlabProject := range gitlabProjects {
+				// A group listed alongside one of its own subgroups returns the same projects twice
+				if seen[gitlabProject.ID] {
+					continue
+				}
+				seen[gitlabProject.ID] = true
+				if g.skipProject(gitlabProject, gitlabProject.PathWithNamespace) {
+					continue
+				}
+				localProjects = append(localProjects, g.syringeProject(gitlabProject, gitlabProject.PathWithNamespace))
+			}
+
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+			log.Debugf("listGroupsProjects() paging %v to page #%v\n", group, opt.Page)
+		}
+		listProjectsPB.Finish()
+	}
+
+	log.Debugf("Len of gitlab group projects: %v\n", len(localProjects))
+	return &localProjects, nil
+}
+
+// skipProject records projects that have no code to scan: empty repositories, and projects
+// without a default branch (e.g. repository disabled)
+func (g *GitlabClient) skipProject(gitlabProject *gitlab.Project, name string) bool {
+	var reason string
+	if gitlabProject.EmptyRepo {
+		reason = "empty repository"
+	} else if gitlabProject.DefaultBranch == "" {
+		reason = "no default branch"
+	} else {
+		return false
+	}
+
+	log.Debugf("Skipping %v: %v\n", name, reason)
+	g.Skipped = append(g.Skipped, &structs.SkippedProject{Name: name, Reason: reason})
+	return true
+}
+
+// SkippedProjects returns the projects the last ListProjects left out
+func (g *GitlabClient) SkippedProjects() []*structs.SkippedProject {
+	return g.Skipped
+}
+
+// RateLimiter returns the limiter pacing the client's requests
+func (g *GitlabClient) RateLimiter() *utils.AdaptiveRateLimitTransport {
+	return g.rateLimiter
+}
+
+func (g *GitlabClient) syringeProject(gitlabProject *gitlab.Project, name string) *structs.SyringeProject {
+	var namespace string
+	if gitlabProject.Namespace != nil {
+		namespace = gitlabProject.Namespace.FullPath
+	}
+
+	return &structs.SyringeProject{
+		Id:        structs.NewProjectKey("gitlab", g.Client.BaseURL().Host, namespace, strconv.Itoa(gitlabProject.ID)),
+		Name:      name,
+		Namespace: namespace,
+		Branch:    gitlabProject.DefaultBranch,
+		Lockfiles: []*structs.VcsFile{},
+		CiFiles:   []*structs.VcsFile{},
+		Hydrated:  false,
+	}
+}
+
+// ListFiles returns every blob in the project's tree at branch, following all pages
 func (g *GitlabClient) ListFiles(projectId int64, branch string) ([]*gitlab.TreeNode, error) {
-	files, _, err := g.Client.Repositories.ListTree(int(projectId), &gitlab.ListTreeOptions{
+	var retFiles []*gitlab.TreeNode
+	opt := &gitlab.ListTreeOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+			Page:    0,
+		},
 		Path:      gitlab.String("/"),
 		Ref:       gitlab.String(branch),
 		Recursive: gitlab.Bool(true),
-	})
+	}
+
+	for {
+		files, resp, err := g.Client.Repositories.ListTree(int(projectId), opt)
+		if err != nil {
+			// log.Warnf("Failed to ListTree from %v: %v\n", projectId, err)
+			return nil, err
+		}
+		for _, file := range files {
+			if file.Type == "blob" {
+				retFiles = append(retFiles, file)
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return retFiles, nil
+}
+
+// gitlabProjectId returns the numeric project ID from a project key
+func gitlabProjectId(projectKey string) (int64, error) {
+	_, _, _, id, err := structs.ParseProjectKey(projectKey)
 	if err != nil {
-		// log.Warnf("Failed to ListTree from %v: %v\n", projectId, err)
-		return nil, err
+		return 0, err
 	}
-	return files, nil
+	projectId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid gitlab project id in %v: %v", projectKey, err)
+	}
+	return projectId, nil
+}
+
+func (g *GitlabClient) GetLockfilesByProject(projectKey string, mainBranchName string) ([]*structs.VcsFile, error) {
+	return g.GetFilesByProject(projectKey, mainBranchName, utils.IsLockfile)
 }
 
-func (g *GitlabClient) GetLockfilesByProject(projectId int64, mainBranchName string) ([]*structs.VcsFile, error) {
-	// TODO: check if mainBranchName isn't set or is "". Bail if that's the case, there are repos without code and will not have a branch
-	var retLockFiles []*structs.VcsFile
+// GetFilesByProject fetches every file on the branch whose path satisfies match
+func (g *GitlabClient) GetFilesByProject(projectKey string, mainBranchName string, match func(string) bool) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
 
-	projectFiles, err := g.ListFiles(projectId, mainBranchName)
+	projectId, err := gitlabProjectId(projectKey)
 	if err != nil {
-		// log.Errorf("Failed to ListFiles for %v on branch %v\n", projectId, mainBranchName)
 		return nil, err
 	}
 
-	supportedLockfiles := utils.GetSupportedLockfiles()
+	// Repos without code have no branch; ListProjects reports them as skipped
+	if mainBranchName == "" {
+		log.Debugf("Skipping lockfiles for projectID %v: no default branch\n", projectId)
+		return nil, nil
+	}
+
+	// GitLab resolves tag names wherever it takes a ref
+	refName, _ := utils.SplitRef(mainBranchName)
+	projectFiles, err := g.ListFiles(projectId, refName)
+	if err != nil {
+		// An empty repository has no tree to list
+		if errResp, ok := err.(*gitlab.ErrorResponse); ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+			log.Warnf("No tree for projectID %v on branch %v, skipping\n", projectId, mainBranchName)
+			return nil, nil
+		}
+		// log.Errorf("Failed to ListFiles for %v on branch %v\n", projectId, mainBranchName)
+		return nil, err
+	}
 
 	for _, file := range projectFiles {
-		if slices.Contains(supportedLockfiles, file.Name) || strings.HasSuffix(file.Name, ".csproj") {
-			log.Debugf("Lockfile: %v in %v from projectID: %v\n", file.Name, file.Path, projectId)
-			data, _, err := g.Client.RepositoryFiles.GetRawFile(int(projectId), file.Path, &gitlab.GetRawFileOptions{&mainBranchName})
+		if match(file.Path) {
+			log.Debugf("File: %v in %v from projectID: %v\n", file.Name, file.Path, projectId)
+			data, _, err := g.Client.RepositoryFiles.GetRawFile(int(projectId), file.Path, &gitlab.GetRawFileOptions{Ref: &refName})
 			if err != nil {
 				log.Errorf("Failed to GetRawFile for %v in projectId %v: %v\n", file.Name, projectId, err)
 			}
 
-			rec := structs.VcsFile{file.Name, file.Path, file.ID, data, nil}
-			retLockFiles = append(retLockFiles, &rec)
+			rec := structs.VcsFile{Name: file.Name, Path: file.Path, Id: file.ID, Content: data}
+			retFiles = append(retFiles, &rec)
+		}
+	}
+	return retFiles, nil
+}
+
+// SearchFiles finds the files match accepts in the configured groups, or the whole instance, with
+// advanced search's blobs scope: a few paginated queries per file name instead of a tree walk per
+// project. Paths are keyed by project ID. Instances without advanced search (Elasticsearch) reject
+// group and global blob searches with an error.
+func (g *GitlabClient) SearchFiles(match func(string) bool) (map[string][]string, error) {
+	hits := newSearchHits(match)
+	for _, qualifier := range searchQualifiers(match) {
+		opt := &gitlab.SearchOptions{ListOptions: gitlab.ListOptions{PerPage: 100, Page: 0}}
+		for {
+			time.Sleep(time.Until(g.lastSearch.Add(g.SearchInterval)))
+			g.lastSearch = time.Now()
+
+			var blobs []*gitlab.Blob
+			var resp *gitlab.Response
+			var err error
+			if len(g.Groups) == 0 {
+				blobs, resp, err = g.Client.Search.Blobs(qualifier, opt)
+			} else {
+				blobs, resp, err = g.searchGroupsBlobs(qualifier, opt)
+			}
+			if err != nil {
+				log.Errorf("Failed to search blobs for %v: %v\n", qualifier, err)
+				return nil, err
+			}
+			for _, blob := range blobs {
+				hits.add(strconv.Itoa(blob.ProjectID), blob.Filename)
+			}
+
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+	}
+	return hits.paths, nil
+}
+
+// searchGroupsBlobs runs one page of a blob search in every configured group. The returned response
+// has a next page while any group does.
+func (g *GitlabClient) searchGroupsBlobs(query string, opt *gitlab.SearchOptions) ([]*gitlab.Blob, *gitlab.Response, error) {
+	var retBlobs []*gitlab.Blob
+	var retResp *gitlab.Response
+	for _, group := range g.Groups {
+		blobs, resp, err := g.Client.Search.BlobsByGroup(group, query, opt)
+		if err != nil {
+			return nil, nil, err
+		}
+		retBlobs = append(retBlobs, blobs...)
+		if retResp == nil || resp.NextPage != 0 {
+			retResp = resp
+		}
+	}
+	return retBlobs, retResp, nil
+}
+
+// GetFiles fetches the files at paths on ref, e.g. the ones SearchFiles found. The search index can
+// lag the repo, so a path that can't be fetched is logged and left out.
+func (g *GitlabClient) GetFiles(projectKey string, ref string, paths []string) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	projectId, err := gitlabProjectId(projectKey)
+	if err != nil {
+		return nil, err
+	}
+	refName, _ := utils.SplitRef(ref)
+
+	for _, path := range paths {
+		data, _, err := g.Client.RepositoryFiles.GetRawFile(int(projectId), path, &gitlab.GetRawFileOptions{Ref: &refName})
+		if err != nil {
+			log.Warnf("Failed to GetRawFile for %v in projectId %v, skipping: %v\n", path, projectId, err)
+			continue
+		}
+		retFiles = append(retFiles, &structs.VcsFile{Name: filepath.Base(path), Path: path, Id: gitBlobSHA(data), Content: data})
+	}
+	return retFiles, nil
+}
+
+// GetHead returns the commit SHA the branch points at
+func (g *GitlabClient) GetHead(projectKey string, branch string) (string, error) {
+	projectId, err := gitlabProjectId(projectKey)
+	if err != nil {
+		return "", err
+	}
+
+	gitlabBranch, _, err := g.Client.Branches.GetBranch(int(projectId), branch)
+	if err != nil {
+		log.Errorf("Failed to GetBranch %v for projectId %v: %v\n", branch, projectId, err)
+		return "", err
+	}
+	if gitlabBranch.Commit == nil {
+		return "", fmt.Errorf("branch %v of projectId %v has no commit", branch, projectId)
+	}
+	return gitlabBranch.Commit.ID, nil
+}
+
+// ListRefs lists the names of a project's branches and tags
+func (g *GitlabClient) ListRefs(projectKey string) ([]string, []string, error) {
+	var branches, tags []string
+
+	projectId, err := gitlabProjectId(projectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	branchOpts := &gitlab.ListBranchesOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+	for {
+		gitlabBranches, resp, err := g.Client.Branches.ListBranches(int(projectId), branchOpts)
+		if err != nil {
+			log.Errorf("Failed to ListBranches for projectId %v: %v\n", projectId, err)
+			return nil, nil, err
+		}
+		for _, branch := range gitlabBranches {
+			branches = append(branches, branch.Name)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		branchOpts.Page = resp.NextPage
+	}
+
+	tagOpts := &gitlab.ListTagsOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+	for {
+		gitlabTags, resp, err := g.Client.Tags.ListTags(int(projectId), tagOpts)
+		if err != nil {
+			log.Errorf("Failed to ListTags for projectId %v: %v\n", projectId, err)
+			return nil, nil, err
+		}
+		for _, tag := range gitlabTags {
+			tags = append(tags, tag.Name)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		tagOpts.Page = resp.NextPage
+	}
+
+	return branches, tags, nil
+}
+
+// ListPullRequests lists a project's open merge requests
+func (g *GitlabClient) ListPullRequests(projectKey string) ([]*structs.PullRequest, error) {
+	var retPullRequests []*structs.PullRequest
+
+	projectId, err := gitlabProjectId(projectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &gitlab.ListProjectMergeRequestsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		State:       gitlab.String("opened"),
+	}
+	for {
+		mergeRequests, resp, err := g.Client.MergeRequests.ListProjectMergeRequests(int(projectId), opts)
+		if err != nil {
+			log.Errorf("Failed to list merge requests for projectId %v: %v\n", projectId, err)
+			return nil, err
+		}
+		for _, mergeRequest := range mergeRequests {
+			retPullRequests = append(retPullRequests, &structs.PullRequest{
+				Id:         strconv.Itoa(mergeRequest.IID),
+				Title:      mergeRequest.Title,
+				Url:        mergeRequest.WebURL,
+				HeadBranch: mergeRequest.SourceBranch,
+				HeadSha:    mergeRequest.SHA,
+			})
+		}
+		if resp.NextPage == 0 {
+			break
 		}
+		opts.Page = resp.NextPage
 	}
-	return retLockFiles, nil
+
+	return retPullRequests, nil
+}
+
+// GetPullRequestLockfiles fetches the lockfiles a merge request adds or modifies at its head
+// commit. The target project has the head commits of merge requests from forks too.
+func (g *GitlabClient) GetPullRequestLockfiles(projectKey string, pullRequest *structs.PullRequest) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	projectId, err := gitlabProjectId(projectKey)
+	if err != nil {
+		return nil, err
+	}
+	iid, err := strconv.Atoi(pullRequest.Id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge request iid %v: %v", pullRequest.Id, err)
+	}
+
+	mergeRequest, _, err := g.Client.MergeRequests.GetMergeRequestChanges(int(projectId), iid, &gitlab.GetMergeRequestChangesOptions{})
+	if err != nil {
+		log.Errorf("Failed to get changes of merge request %v in projectId %v: %v\n", iid, projectId, err)
+		return nil, err
+	}
+
+	for _, change := range mergeRequest.Changes {
+		if change.DeletedFile || !utils.IsLockfile(change.NewPath) {
+			continue
+		}
+		data, _, err := g.Client.RepositoryFiles.GetRawFile(int(projectId), change.NewPath, &gitlab.GetRawFileOptions{Ref: &pullRequest.HeadSha})
+		if err != nil {
+			log.Errorf("Failed to GetRawFile for %v at %v in projectId %v: %v\n", change.NewPath, pullRequest.HeadSha, projectId, err)
+			return nil, err
+		}
+		retFiles = append(retFiles, &structs.VcsFile{
+			Name:    filepath.Base(change.NewPath),
+			Path:    change.NewPath,
+			Id:      pullRequest.HeadSha,
+			Content: data,
+		})
+	}
+	return retFiles, nil
+}
+
+// OpenChangeRequest commits every file in one commit on a new branch and opens a merge request
+func (g *GitlabClient) OpenChangeRequest(projectKey string, change *structs.ChangeRequest) (string, error) {
+	projectId, err := gitlabProjectId(projectKey)
+	if err != nil {
+		return "", err
+	}
+
+	var actions []*gitlab.CommitActionOptions
+	for _, file := range change.Files {
+		action := gitlab.FileCreate
+		if file.Previous != nil {
+			action = gitlab.FileUpdate
+		}
+		actions = append(actions, &gitlab.CommitActionOptions{
+			Action:   gitlab.FileAction(action),
+			FilePath: gitlab.String(file.Path),
+			Content:  gitlab.String(string(file.Content)),
+		})
+	}
+	_, _, err = g.Client.Commits.CreateCommit(int(projectId), &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String(change.HeadBranch),
+		StartBranch:   gitlab.String(change.BaseBranch),
+		CommitMessage: gitlab.String(change.CommitMessage),
+		Actions:       actions,
+	})
+	if err != nil {
+		log.Errorf("Failed to CreateCommit in projectId %v: %v\n", projectId, err)
+		return "", err
+	}
+
+	mergeRequest, _, err := g.Client.MergeRequests.CreateMergeRequest(int(projectId), &gitlab.CreateMergeRequestOptions{
+		Title:              gitlab.String(change.Title),
+		Description:        gitlab.String(change.Body),
+		SourceBranch:       gitlab.String(change.HeadBranch),
+		TargetBranch:       gitlab.String(change.BaseBranch),
+		RemoveSourceBranch: gitlab.Bool(true),
+	})
+	if err != nil {
+		log.Errorf("Failed to CreateMergeRequest in projectId %v: %v\n", projectId, err)
+		return "", err
+	}
+	return mergeRequest.WebURL, nil
+}
+
+// DownloadArchive streams a tarball of the ref. Errors from the request surface when the archive is read.
+func (g *GitlabClient) DownloadArchive(projectKey string, ref string) (*Archive, error) {
+	projectId, err := gitlabProjectId(projectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	refName, _ := utils.SplitRef(ref)
+	format := ArchiveTarGz
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := g.Client.Repositories.StreamArchive(int(projectId), pipeWriter, &gitlab.ArchiveOptions{Format: &format, SHA: &refName})
+		if err != nil {
+			log.Errorf("Failed to StreamArchive for projectId %v at %v: %v\n", projectId, refName, err)
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	return &Archive{Body: pipeReader, Format: ArchiveTarGz, StripPrefix: true}, nil
+}
+
+func (g *GitlabClient) GetCloneTarget(projectKey string) (*CloneTarget, error) {
+	projectId, err := gitlabProjectId(projectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	project, _, err := g.Client.Projects.GetProject(int(projectId), &gitlab.GetProjectOptions{})
+	if err != nil {
+		log.Errorf("Failed to GetProject %v: %v\n", projectId, err)
+		return nil, err
+	}
+
+	return &CloneTarget{
+		Url:        project.HTTPURLToRepo,
+		AuthHeader: basicAuthHeader("oauth2", g.Token),
+	}, nil
 }
 
 // func (g *GitlabClient) PrintProjectVariables(projectId int) error {
@@ -1,9 +1,13 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/peterjmorgan/Syringe/internal/structs"
 	"github.com/peterjmorgan/Syringe/internal/utils"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 )
@@ -20,7 +24,7 @@ import (
 var testingSyringeOpts structs.SyringeOptions = structs.SyringeOptions{
 	MineOnly:  true,
 	RateLimit: 0,
-	ProxyUrl:  "",
+	Http:      structs.HttpOptions{},
 }
 
 func TestGitlabClient_ListProjects(t *testing.T) {
@@ -64,7 +68,7 @@ func TestGitlabClient_GetLockfiles(t *testing.T) {
 	g := NewGitlabClient(configData, &testingSyringeOpts)
 
 	type args struct {
-		projectId      int64
+		projectId      string
 		mainBranchName string
 	}
 	tests := []struct {
@@ -74,7 +78,7 @@ func TestGitlabClient_GetLockfiles(t *testing.T) {
 		wantLen int
 		wantErr bool
 	}{
-		{"one", args{38265422, "master"}, nil, 4, false},
+		{"one", args{structs.NewProjectKey("gitlab", "gitlab.com", "", "38265422"), "master"}, nil, 4, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -93,3 +97,379 @@ func TestGitlabClient_GetLockfiles(t *testing.T) {
 		})
 	}
 }
+
+func newTestGitlabClient(serverUrl string, groups string) *GitlabClient {
+	return NewGitlabClient(&structs.ConfigThing{
+		VcsType:    "gitlab",
+		VcsToken:   "testtoken",
+		Associated: map[string]string{"vcsUrl": serverUrl, "gitlabGroups": groups},
+	}, &structs.SyringeOptions{})
+}
+
+func TestGitlabClient_ListProjectsGroups(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("include_subgroups") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.EscapedPath() {
+		case "/api/v4/groups/acme/projects":
+			// Two pages, the second holding a project from a nested subgroup
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `[{"id": 3, "name": "api", "path_with_namespace": "acme/platform/backend/api", "default_branch": "main", "namespace": {"full_path": "acme/platform/backend"}}]`)
+				return
+			}
+			w.Header().Set("X-Next-Page", "2")
+			fmt.Fprint(w, `[
+				{"id": 1, "name": "api", "path_with_namespace": "acme/api", "default_branch": "main", "namespace": {"full_path": "acme"}},
+				{"id": 2, "name": "web", "path_with_namespace": "acme/platform/web", "default_branch": "main", "namespace": {"full_path": "acme/platform"}}
+			]`)
+		case "/api/v4/groups/42/projects":
+			fmt.Fprint(w, `[
+				{"id": 2, "name": "web", "path_with_namespace": "acme/platform/web", "default_branch": "main", "namespace": {"full_path": "acme/platform"}},
+				{"id": 4, "name": "api", "path_with_namespace": "other/api", "default_branch": "master", "namespace": {"full_path": "other"}}
+			]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	g := newTestGitlabClient(server.URL, "acme, 42")
+	got, err := g.ListProjects()
+	if err != nil {
+		t.Errorf("ListProjects() error = %v", err)
+		return
+	}
+
+	host := utils.UrlHost(server.URL)
+	gotNames := map[string]string{}
+	gotNamespaces := map[string]string{}
+	for _, p := range *got {
+		gotNames[p.Id] = p.Name
+		gotNamespaces[p.Id] = p.Namespace
+	}
+	wantNames := map[string]string{
+		structs.NewProjectKey("gitlab", host, "acme", "1"):                  "acme/api",
+		structs.NewProjectKey("gitlab", host, "acme/platform", "2"):         "acme/platform/web",
+		structs.NewProjectKey("gitlab", host, "acme/platform/backend", "3"): "acme/platform/backend/api",
+		structs.NewProjectKey("gitlab", host, "other", "4"):                 "other/api",
+	}
+	wantNamespaces := map[string]string{
+		structs.NewProjectKey("gitlab", host, "acme", "1"):                  "acme",
+		structs.NewProjectKey("gitlab", host, "acme/platform", "2"):         "acme/platform",
+		structs.NewProjectKey("gitlab", host, "acme/platform/backend", "3"): "acme/platform/backend",
+		structs.NewProjectKey("gitlab", host, "other", "4"):                 "other",
+	}
+	if len(*got) != len(wantNames) || !reflect.DeepEqual(gotNames, wantNames) {
+		t.Errorf("ListProjects() names got = %v, want %v", gotNames, wantNames)
+	}
+	if !reflect.DeepEqual(gotNamespaces, wantNamespaces) {
+		t.Errorf("ListProjects() namespaces got = %v, want %v", gotNamespaces, wantNamespaces)
+	}
+}
+
+func TestGitlabClient_GetLockfilesByProjectPaged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects":
+			fmt.Fprint(w, `[
+				{"id": 1, "name": "monorepo", "default_branch": "main"},
+				{"id": 2, "name": "empty", "empty_repo": true},
+				{"id": 3, "name": "nobranch", "default_branch": ""}
+			]`)
+		case "/api/v4/projects/1/repository/tree":
+			// The lockfile is only on the last page
+			switch r.URL.Query().Get("page") {
+			case "", "1":
+				w.Header().Set("X-Next-Page", "2")
+				fmt.Fprint(w, `[{"id": "t1", "name": "services", "type": "tree", "path": "services"}, {"id": "b1", "name": "README.md", "type": "blob", "path": "README.md"}]`)
+			case "2":
+				w.Header().Set("X-Next-Page", "3")
+				fmt.Fprint(w, `[{"id": "b2", "name": "main.py", "type": "blob", "path": "services/api/main.py"}]`)
+			case "3":
+				fmt.Fprint(w, `[{"id": "b3", "name": "poetry.lock", "type": "blob", "path": "services/api/poetry.lock"}]`)
+			}
+		case "/api/v4/projects/1/repository/files/services%2Fapi%2Fpoetry.lock/raw":
+			fmt.Fprint(w, "poetry")
+		case "/api/v4/projects/2/repository/tree":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "404 Tree Not Found"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	g := newTestGitlabClient(server.URL, "")
+
+	projects, err := g.ListProjects()
+	if err != nil {
+		t.Errorf("ListProjects() error = %v", err)
+		return
+	}
+	if len(*projects) != 1 || (*projects)[0].Name != "monorepo" {
+		t.Errorf("ListProjects() got = %v, want only monorepo", len(*projects))
+	}
+	gotSkipped := map[string]string{}
+	for _, skipped := range g.SkippedProjects() {
+		gotSkipped[skipped.Name] = skipped.Reason
+	}
+	wantSkipped := map[string]string{"empty": "empty repository", "nobranch": "no default branch"}
+	if !reflect.DeepEqual(gotSkipped, wantSkipped) {
+		t.Errorf("SkippedProjects() got = %v, want %v", gotSkipped, wantSkipped)
+	}
+
+	host := utils.UrlHost(server.URL)
+
+	type args struct {
+		projectId string
+		branch    string
+	}
+	tests := []struct {
+		name      string
+		args      args
+		wantPaths []string
+	}{
+		{"paged", args{structs.NewProjectKey("gitlab", host, "", "1"), "main"}, []string{"services/api/poetry.lock"}},
+		{"empty repo", args{structs.NewProjectKey("gitlab", host, "", "2"), "main"}, nil},
+		{"no branch", args{structs.NewProjectKey("gitlab", host, "", "3"), ""}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.GetLockfilesByProject(tt.args.projectId, tt.args.branch)
+			if err != nil {
+				t.Errorf("GetLockfilesByProject() error = %v", err)
+				return
+			}
+			var gotPaths []string
+			for _, lockfile := range got {
+				gotPaths = append(gotPaths, lockfile.Path)
+			}
+			if !reflect.DeepEqual(gotPaths, tt.wantPaths) {
+				t.Errorf("GetLockfilesByProject() got = %v, want %v", gotPaths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestGitlabClient_OpenChangeRequest(t *testing.T) {
+	var gotCommit map[string]interface{}
+	var gotMergeRequest map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/1/repository/commits":
+			json.NewDecoder(r.Body).Decode(&gotCommit)
+			fmt.Fprint(w, `{"id": "c0ffee"}`)
+		case "/api/v4/projects/1/merge_requests":
+			json.NewDecoder(r.Body).Decode(&gotMergeRequest)
+			fmt.Fprint(w, `{"iid": 3, "web_url": "https://gitlab.example.com/acme/api/-/merge_requests/3"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	g := newTestGitlabClient(server.URL, "")
+	got, err := g.OpenChangeRequest(structs.NewProjectKey("gitlab", utils.UrlHost(server.URL), "", "1"), &structs.ChangeRequest{
+		BaseBranch:    "main",
+		HeadBranch:    "syringe/phylum-ci",
+		Title:         "Add Phylum",
+		CommitMessage: "Add Phylum",
+		Files: []*structs.FileChange{
+			{Path: ".gitlab/phylum.yml", Content: []byte("phylum:\n")},
+			{Path: ".gitlab-ci.yml", Content: []byte("include:\n"), Previous: &structs.VcsFile{Path: ".gitlab-ci.yml"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("OpenChangeRequest() error = %v", err)
+	}
+	if got != "https://gitlab.example.com/acme/api/-/merge_requests/3" {
+		t.Errorf("OpenChangeRequest() got = %v", got)
+	}
+
+	if gotCommit["branch"] != "syringe/phylum-ci" || gotCommit["start_branch"] != "main" {
+		t.Errorf("OpenChangeRequest() commit = %v", gotCommit)
+	}
+	var gotActions []string
+	for _, action := range gotCommit["actions"].([]interface{}) {
+		action := action.(map[string]interface{})
+		gotActions = append(gotActions, fmt.Sprintf("%v %v", action["action"], action["file_path"]))
+	}
+	wantActions := []string{"create .gitlab/phylum.yml", "update .gitlab-ci.yml"}
+	if !reflect.DeepEqual(gotActions, wantActions) {
+		t.Errorf("OpenChangeRequest() actions = %v, want %v", gotActions, wantActions)
+	}
+	if gotMergeRequest["source_branch"] != "syringe/phylum-ci" || gotMergeRequest["target_branch"] != "main" {
+		t.Errorf("OpenChangeRequest() merge request = %v", gotMergeRequest)
+	}
+}
+
+func TestGitlabClient_PullRequests(t *testing.T) {
+	var gotRawRefs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/1/merge_requests":
+			if r.URL.Query().Get("state") != "opened" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `[{"iid": 4, "title": "Bump rails", "web_url": "https://gitlab.example.com/acme/api/-/merge_requests/4", "source_branch": "bump-rails", "sha": "fea7"}]`)
+		case "/api/v4/projects/1/merge_requests/4/changes":
+			fmt.Fprint(w, `{"iid": 4, "changes": [
+				{"old_path": "Gemfile.lock", "new_path": "Gemfile.lock"},
+				{"old_path": "yarn.lock", "new_path": "yarn.lock", "deleted_file": true},
+				{"old_path": "app.rb", "new_path": "app.rb"}
+			]}`)
+		case "/api/v4/projects/1/repository/files/Gemfile.lock/raw":
+			gotRawRefs = append(gotRawRefs, r.URL.Query().Get("ref"))
+			fmt.Fprint(w, "GEM\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	g := newTestGitlabClient(server.URL, "")
+	projectId := structs.NewProjectKey("gitlab", utils.UrlHost(server.URL), "", "1")
+
+	got, err := g.ListPullRequests(projectId)
+	if err != nil {
+		t.Fatalf("ListPullRequests() error = %v", err)
+	}
+	want := []*structs.PullRequest{{Id: "4", Title: "Bump rails", Url: "https://gitlab.example.com/acme/api/-/merge_requests/4", HeadBranch: "bump-rails", HeadSha: "fea7"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ListPullRequests() got = %v, want %v", got, want)
+	}
+
+	lockfiles, err := g.GetPullRequestLockfiles(projectId, got[0])
+	if err != nil {
+		t.Fatalf("GetPullRequestLockfiles() error = %v", err)
+	}
+	if len(lockfiles) != 1 || lockfiles[0].Path != "Gemfile.lock" || string(lockfiles[0].Content) != "GEM\n" {
+		t.Errorf("GetPullRequestLockfiles() got = %v", lockfiles)
+	}
+	if !reflect.DeepEqual(gotRawRefs, []string{"fea7"}) {
+		t.Errorf("GetPullRequestLockfiles() read raw files at %v, want fea7", gotRawRefs)
+	}
+}
+
+func TestGitlabClient_DownloadArchive(t *testing.T) {
+	tarball := makeTarGz(t, "api-main-c0ffee", map[string]string{"Gemfile.lock": "GEM\n", "app.rb": "puts"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/projects/1/repository/archive.tar.gz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("sha") != "main" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write(tarball)
+	}))
+	defer server.Close()
+
+	g := newTestGitlabClient(server.URL, "")
+	archive, err := g.DownloadArchive(structs.NewProjectKey("gitlab", utils.UrlHost(server.URL), "", "1"), "main")
+	if err != nil {
+		t.Fatalf("DownloadArchive() error = %v", err)
+	}
+	got, err := ArchiveFiles(archive, utils.IsLockfile)
+	if err != nil {
+		t.Fatalf("ArchiveFiles() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Path != "Gemfile.lock" || string(got[0].Content) != "GEM\n" {
+		t.Errorf("DownloadArchive() files = %v", got)
+	}
+
+	// A failed download surfaces when the archive is read
+	archive, err = g.DownloadArchive(structs.NewProjectKey("gitlab", utils.UrlHost(server.URL), "", "2"), "main")
+	if err != nil {
+		t.Fatalf("DownloadArchive() error = %v", err)
+	}
+	if _, err := ArchiveFiles(archive, utils.IsLockfile); err == nil {
+		t.Errorf("ArchiveFiles() of a missing project returned no error")
+	}
+}
+
+func TestGitlabClient_SearchFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("scope") != "blobs" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		search := r.URL.Query().Get("search")
+		switch r.URL.Path {
+		case "/api/v4/groups/acme/-/search":
+			if search == "filename:yarn.lock" {
+				fmt.Fprint(w, `[{"project_id": 1, "filename": "yarn.lock"}, {"project_id": 1, "filename": "yarn.lock"}]`)
+				return
+			}
+			if search == "filename:.gitlab-ci.yml" {
+				fmt.Fprint(w, `[{"project_id": 1, "filename": ".gitlab-ci.yml"}]`)
+				return
+			}
+			fmt.Fprint(w, `[]`)
+		case "/api/v4/groups/tools/-/search":
+			if search == "extension:csproj" {
+				fmt.Fprint(w, `[{"project_id": 7, "filename": "src/App/App.csproj"}]`)
+				return
+			}
+			fmt.Fprint(w, `[]`)
+		case "/api/v4/search":
+			// No advanced search on this instance
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"message": "400 Bad request - Scope not supported without Elasticsearch!"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	g := newTestGitlabClient(server.URL, "acme,tools")
+	g.SearchInterval = 0
+	got, err := g.SearchFiles(utils.IsLockfileOrCiFile)
+	if err != nil {
+		t.Fatalf("SearchFiles() error = %v", err)
+	}
+	want := map[string][]string{"1": {"yarn.lock", ".gitlab-ci.yml"}, "7": {"src/App/App.csproj"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchFiles() got = %v, want %v", got, want)
+	}
+
+	g = newTestGitlabClient(server.URL, "")
+	g.SearchInterval = 0
+	if _, err := g.SearchFiles(utils.IsLockfile); err == nil {
+		t.Errorf("SearchFiles() without advanced search got no error")
+	}
+}
+
+func TestGitlabRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		want       bool
+	}{
+		{"ok", http.StatusOK, "", false},
+		{"too many requests", http.StatusTooManyRequests, "", false},
+		{"unavailable with Retry-After", http.StatusServiceUnavailable, "5", false},
+		{"unavailable", http.StatusServiceUnavailable, "", true},
+		{"server error", http.StatusInternalServerError, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			got, err := gitlabRetryPolicy(context.Background(), resp, nil)
+			if err != nil {
+				t.Fatalf("gitlabRetryPolicy() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("gitlabRetryPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"net/http"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// newHttpClient returns the shared HTTP client configured by opts.Http, caching responses in
+// opts.Http.CacheDir when it is set. The cache sits below the clients' auth and keys its entries by
+// cacheIdentity, a stable name for the configured credentials such as the token or the GitHub App
+// installation. NewSyringe has already checked the options, so a failure here is fatal like the
+// other client setup errors.
+func newHttpClient(opts *structs.SyringeOptions, cacheIdentity string) *http.Client {
+	httpClient, _ := newHttpClientWithLimiter(opts, cacheIdentity, false)
+	return httpClient
+}
+
+// newRateLimitedHttpClient is newHttpClient paced by the rate limit headers of the VCS, for clients
+// without a rate limiter of their own. The limiter sits below the cache, so it sees each response
+// the VCS actually sent.
+func newRateLimitedHttpClient(opts *structs.SyringeOptions, cacheIdentity string) (*http.Client, *utils.AdaptiveRateLimitTransport) {
+	return newHttpClientWithLimiter(opts, cacheIdentity, true)
+}
+
+func newHttpClientWithLimiter(opts *structs.SyringeOptions, cacheIdentity string, rateLimited bool) (*http.Client, *utils.AdaptiveRateLimitTransport) {
+	var httpOptions structs.HttpOptions
+	if opts != nil {
+		httpOptions = opts.Http
+	}
+	httpClient, err := utils.NewHttpClient(httpOptions)
+	if err != nil {
+		log.Fatalf("Failed to create HTTP client: %v\n", err)
+	}
+	var limiter *utils.AdaptiveRateLimitTransport
+	if rateLimited {
+		limiter = utils.NewAdaptiveRateLimitTransport(httpClient.Transport)
+		httpClient.Transport = limiter
+	}
+	if httpOptions.CacheDir != "" {
+		httpClient.Transport, err = utils.NewCacheTransport(httpClient.Transport, httpOptions.CacheDir, cacheIdentity)
+		if err != nil {
+			log.Fatalf("Failed to create HTTP cache in %v: %v\n", httpOptions.CacheDir, err)
+		}
+	}
+	return httpClient, limiter
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+type LocalClient struct {
+	RootPath        string
+	ProjectMap      map[string]*LocalRepository
+	ProjectMapMutex sync.RWMutex
+}
+
+// LocalRepository is a checked-out directory on disk that Syringe treats as a project
+type LocalRepository struct {
+	Name string
+	Path string
+}
+
+func NewLocalClient(configData *structs.ConfigThing, opts *structs.SyringeOptions) *LocalClient {
+	rootPath := configData.Associated["localPath"]
+	if rootPath == "" {
+		log.Fatalf("NewLocalClient: 'localPath' is not configured\n")
+	}
+
+	return &LocalClient{
+		RootPath:   rootPath,
+		ProjectMap: make(map[string]*LocalRepository, 0),
+	}
+}
+
+// findGitDir returns the git directory for a working tree, following `gitdir:` files used by worktrees and submodules
+func findGitDir(repoPath string) (string, bool) {
+	dotGit := filepath.Join(repoPath, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		return "", false
+	}
+	if info.IsDir() {
+		return dotGit, true
+	}
+
+	data, err := os.ReadFile(dotGit)
+	if err != nil {
+		return "", false
+	}
+	gitDir := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(data)), "gitdir:"))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(repoPath, gitDir)
+	}
+	return gitDir, true
+}
+
+// ReadGitHead returns the checked-out branch of the repository at repoPath.
+// A detached HEAD returns the commit SHA, a directory without .git returns "".
+func ReadGitHead(repoPath string) string {
+	gitDir, ok := findGitDir(repoPath)
+	if !ok {
+		return ""
+	}
+
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		log.Debugf("Local: failed to read HEAD for %v: %v\n", repoPath, err)
+		return ""
+	}
+
+	head := strings.TrimSpace(string(data))
+	if strings.HasPrefix(head, "ref:") {
+		ref := strings.TrimSpace(strings.TrimPrefix(head, "ref:"))
+		return strings.TrimPrefix(ref, "refs/heads/")
+	}
+	return head
+}
+
+// findRepositories returns the directories under dirPath that contain .git, not descending into them
+func findRepositories(dirPath string) []string {
+	var retPaths []string
+
+	filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if _, ok := findGitDir(path); ok {
+			retPaths = append(retPaths, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	return retPaths
+}
+
+func (l *LocalClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	var retProjects []*structs.SyringeProject
+	var repoPaths []string
+
+	entries, err := os.ReadDir(l.RootPath)
+	if err != nil {
+		log.Errorf("Local: failed to read %v: %v\n", l.RootPath, err)
+		return nil, err
+	}
+
+	// Each top-level directory is a project, unless it only groups nested git checkouts
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == ".git" {
+			continue
+		}
+		topLevelPath := filepath.Join(l.RootPath, entry.Name())
+		nested := findRepositories(topLevelPath)
+		if len(nested) == 0 {
+			repoPaths = append(repoPaths, topLevelPath)
+		} else {
+			repoPaths = append(repoPaths, nested...)
+		}
+	}
+	sort.Strings(repoPaths)
+
+	for _, repoPath := range repoPaths {
+		name, err := filepath.Rel(l.RootPath, repoPath)
+		if err != nil {
+			name = filepath.Base(repoPath)
+		}
+		name = filepath.ToSlash(name)
+		id := structs.NewProjectKey("local", "", filepath.ToSlash(l.RootPath), name)
+
+		retProjects = append(retProjects, &structs.SyringeProject{
+			Id:        id,
+			Name:      name,
+			Branch:    ReadGitHead(repoPath),
+			Lockfiles: []*structs.VcsFile{},
+			CiFiles:   []*structs.VcsFile{},
+			Hydrated:  false,
+		})
+		l.ProjectMapMutex.Lock()
+		l.ProjectMap[id] = &LocalRepository{
+			Name: name,
+			Path: repoPath,
+		}
+		l.ProjectMapMutex.Unlock()
+	}
+
+	return &retProjects, nil
+}
+
+// ListFiles returns the paths of all files in the checkout relative to repoPath, skipping .git
+func (l *LocalClient) ListFiles(repoPath string) ([]string, error) {
+	var retFiles []string
+
+	err := filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relPath, err := filepath.Rel(repoPath, path)
+		if err != nil {
+			return err
+		}
+		retFiles = append(retFiles, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return retFiles, nil
+}
+
+// gitBlobSHA computes the same object ID git assigns to a blob with this content
+func gitBlobSHA(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func (l *LocalClient) GetLockfilesByProject(projectId string, mainBranchName string) ([]*structs.VcsFile, error) {
+	return l.GetFilesByProject(projectId, mainBranchName, utils.IsLockfile)
+}
+
+// GetFilesByProject fetches every file on the branch whose path satisfies match
+func (l *LocalClient) GetFilesByProject(projectId string, mainBranchName string, match func(string) bool) ([]*structs.VcsFile, error) {
+	var retFiles []*structs.VcsFile
+
+	l.ProjectMapMutex.RLock()
+	repo, ok := l.ProjectMap[projectId]
+	l.ProjectMapMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Local: unknown project ID %v", projectId)
+	}
+
+	projectFiles, err := l.ListFiles(repo.Path)
+	if err != nil {
+		log.Errorf("Local: failed to ListFiles for %v: %v\n", repo.Path, err)
+		return nil, err
+	}
+
+	for _, filePath := range projectFiles {
+		fileName := filepath.Base(filePath)
+		if match(filePath) {
+			log.Debugf("File: %v in %v from project: %v\n", fileName, filePath, repo.Name)
+			content, err := os.ReadFile(filepath.Join(repo.Path, filepath.FromSlash(filePath)))
+			if err != nil {
+				log.Errorf("Local: failed to read %v in %v: %v\n", filePath, repo.Name, err)
+				return nil, err
+			}
+
+			retFiles = append(retFiles, &structs.VcsFile{
+				Name:          fileName,
+				Path:          filePath,
+				Id:            gitBlobSHA(content),
+				Content:       content,
+				PhylumProject: nil,
+			})
+		}
+	}
+
+	return retFiles, nil
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+)
+
+// setupLocalTree lays out a mirror-style directory:
+//
+//	plain/            top-level directory without .git
+//	repo-a/.git       checkout on branch main
+//	group/repo-b/.git checkout with a detached HEAD
+func setupLocalTree(t *testing.T) string {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"plain/requirements.txt":            "requests==2.28.1\n",
+		"repo-a/.git/HEAD":                  "ref: refs/heads/main\n",
+		"repo-a/package-lock.json":          "{}",
+		"repo-a/web/yarn.lock":              "",
+		"repo-a/.git/objects/yarn.lock":     "not a lockfile",
+		"repo-a/src/main.go":                "package main",
+		"group/repo-b/.git/HEAD":            "0123456789abcdef0123456789abcdef01234567\n",
+		"group/repo-b/svc/app/app.csproj":   "<Project/>",
+		"group/repo-b/svc/app/Gemfile.lock": "GEM",
+	}
+	for path, content := range files {
+		fullPath := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("failed to create %v: %v", path, err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %v: %v", path, err)
+		}
+	}
+	return root
+}
+
+func TestLocalClient_ListProjects(t *testing.T) {
+	root := setupLocalTree(t)
+	l := NewLocalClient(&structs.ConfigThing{
+		VcsType:    "local",
+		Associated: map[string]string{"localPath": root},
+	}, &structs.SyringeOptions{})
+
+	tests := []struct {
+		name       string
+		want       *[]*structs.SyringeProject
+		wantBranch map[string]string
+		wantErr    bool
+	}{
+		{"mirror", nil, map[string]string{
+			"group/repo-b": "0123456789abcdef0123456789abcdef01234567",
+			"plain":        "",
+			"repo-a":       "main",
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := l.ListProjects()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ListProjects() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("LocalClient_ListProjects() TypeOf got = %v, want %v", got, tt.want)
+			}
+
+			gotBranch := make(map[string]string, len(*got))
+			for _, p := range *got {
+				gotBranch[p.Name] = p.Branch
+			}
+			if !reflect.DeepEqual(gotBranch, tt.wantBranch) {
+				t.Errorf("LocalClient_ListProjects() got = %v, want %v", gotBranch, tt.wantBranch)
+			}
+		})
+	}
+}
+
+func TestLocalClient_GetLockfilesByProject(t *testing.T) {
+	root := setupLocalTree(t)
+	l := NewLocalClient(&structs.ConfigThing{
+		VcsType:    "local",
+		Associated: map[string]string{"localPath": root},
+	}, &structs.SyringeOptions{})
+
+	projects, err := l.ListProjects()
+	if err != nil {
+		t.Fatalf("failed to ListProjects: %v", err)
+	}
+	projectIds := make(map[string]string, len(*projects))
+	for _, p := range *projects {
+		projectIds[p.Name] = p.Id
+	}
+
+	tests := []struct {
+		name      string
+		project   string
+		want      []*structs.VcsFile
+		wantPaths []string
+		wantErr   bool
+	}{
+		{"plain", "plain", nil, []string{"requirements.txt"}, false},
+		{"repo-a skips .git", "repo-a", nil, []string{"package-lock.json", "web/yarn.lock"}, false},
+		{"nested repo-b", "group/repo-b", nil, []string{"svc/app/Gemfile.lock", "svc/app/app.csproj"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := l.GetLockfilesByProject(projectIds[tt.project], "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetLockfilesByProject() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("LocalClient_GetLockfilesByProject() TypeOf got = %v, want %v", got, tt.want)
+			}
+
+			var gotPaths []string
+			for _, lockfile := range got {
+				gotPaths = append(gotPaths, lockfile.Path)
+			}
+			if !reflect.DeepEqual(gotPaths, tt.wantPaths) {
+				t.Errorf("LocalClient_GetLockfilesByProject() got = %v, want %v", gotPaths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestGitBlobSHA(t *testing.T) {
+	// `printf 'hello\n' | git hash-object --stdin`
+	if got := gitBlobSHA([]byte("hello\n")); got != "ce013625030ba8dba906f756967f9e9ca394464a" {
+		t.Errorf("gitBlobSHA() got = %v", got)
+	}
+}
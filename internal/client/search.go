@@ -0,0 +1,64 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/peterjmorgan/Syringe/internal/utils"
+)
+
+// ErrSearchIncomplete is returned when code search can't promise every match was returned, e.g.
+// when a query has more results than the search API pages through. Callers should fall back to
+// listing each repo's tree.
+var ErrSearchIncomplete = errors.New("code search results are incomplete")
+
+// searchTerm is a code search qualifier for one kind of file, with an example path to test it
+// against the caller's match function
+type searchTerm struct {
+	qualifier string
+	example   string
+}
+
+// searchQualifiers returns the filename, extension and path qualifiers that find every supported
+// lockfile and CI file match accepts. GitHub code search and GitLab advanced search share the
+// syntax. Qualifiers are fuzzy (filename:Pipfile also finds Pipfile.lock), so hits still need
+// filtering with match.
+func searchQualifiers(match func(string) bool) []string {
+	var terms []searchTerm
+	for _, name := range utils.GetSupportedLockfiles() {
+		terms = append(terms, searchTerm{"filename:" + name, "src/" + name})
+	}
+	terms = append(terms, searchTerm{"extension:csproj", "src/App.csproj"})
+	for _, name := range utils.GetSupportedCiFiles() {
+		terms = append(terms, searchTerm{"filename:" + name, name})
+	}
+	terms = append(terms, searchTerm{"path:" + utils.GithubWorkflowsDir, utils.GithubWorkflowsDir + "/ci.yml"})
+
+	var qualifiers []string
+	for _, term := range terms {
+		if match(term.example) {
+			qualifiers = append(qualifiers, term.qualifier)
+		}
+	}
+	return qualifiers
+}
+
+// searchHits collects the paths code search found, keyed by repo ID, keeping only paths match
+// accepts and each path once
+type searchHits struct {
+	match func(string) bool
+	paths map[string][]string
+	seen  map[string]bool
+}
+
+func newSearchHits(match func(string) bool) *searchHits {
+	return &searchHits{match: match, paths: make(map[string][]string, 0), seen: make(map[string]bool, 0)}
+}
+
+func (h *searchHits) add(repoId string, path string) {
+	key := repoId + "\x00" + path
+	if !h.match(path) || h.seen[key] {
+		return
+	}
+	h.seen[key] = true
+	h.paths[repoId] = append(h.paths[repoId], path)
+}
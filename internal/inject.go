@@ -0,0 +1,360 @@
+package syringePackage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+)
+
+// DefaultInjectBranch is the branch inject commits to and opens its pull/merge requests from
+const DefaultInjectBranch = "syringe/phylum-ci"
+
+// ErrInjectUnsupported is returned for VCS types Syringe has no CI snippet for
+var ErrInjectUnsupported = errors.New("injecting Phylum CI is not supported for this VCS")
+
+const injectTitle = "Add Phylum dependency analysis to CI"
+
+const githubWorkflowPath = ".github/workflows/phylum.yml"
+
+const githubWorkflow = `name: Phylum
+on:
+  pull_request:
+jobs:
+  analyze-deps:
+    name: Analyze dependencies with Phylum
+    permissions:
+      contents: read
+      pull-requests: write
+    runs-on: ubuntu-latest
+    steps:
+      - name: Checkout the repo
+        uses: actions/checkout@v3
+        with:
+          fetch-depth: 0
+      - name: Analyze dependencies
+        uses: phylum-dev/phylum-analyze-pr-action@v2
+        with:
+          phylum_token: ${{ secrets.PHYLUM_TOKEN }}
+          cmd: %v
+`
+
+const gitlabJobPath = ".gitlab/phylum.yml"
+
+const gitlabJob = `phylum-analyze:
+  image: docker.io/phylumio/phylum-ci:latest
+  rules:
+    - if: $CI_PIPELINE_SOURCE == "merge_request_event"
+  variables:
+    GIT_DEPTH: 0
+  script:
+    - %v
+`
+
+const gitlabInclude = "- local: " + gitlabJobPath + "\n"
+
+const azureStep = `- script: >-
+    docker run --rm -e PHYLUM_API_KEY -e AZURE_TOKEN
+    -v $(Build.SourcesDirectory):/phylum -w /phylum
+    docker.io/phylumio/phylum-ci:latest %v
+  displayName: Analyze dependencies with Phylum
+  condition: eq(variables['Build.Reason'], 'PullRequest')
+  env:
+    PHYLUM_API_KEY: $(PHYLUM_API_KEY)
+    AZURE_TOKEN: $(System.AccessToken)
+`
+
+const azurePipeline = `trigger: none
+pr:
+  - '*'
+pool:
+  vmImage: ubuntu-latest
+steps:
+  - checkout: self
+    fetchDepth: 0
+`
+
+const bitbucketPullRequests = `pull-requests:
+  '**':
+    - step:
+        name: Analyze dependencies with Phylum
+        image: docker.io/phylumio/phylum-ci:latest
+        script:
+          - %v
+`
+
+var injectSecrets = map[string]string{
+	"github":          "Add a repository secret named `PHYLUM_TOKEN` holding a Phylum API token.",
+	"gitlab":          "Add masked CI/CD variables `PHYLUM_API_KEY` (a Phylum API token) and `GITLAB_TOKEN` (a token that can comment on merge requests).",
+	"azure":           "Add a secret pipeline variable named `PHYLUM_API_KEY` holding a Phylum API token, and register `azure-pipelines.yml` as a pipeline with a build validation policy if it is new.",
+	"bitbucket_cloud": "Enable Pipelines and add secured repository variables `PHYLUM_API_KEY` (a Phylum API token) and `BITBUCKET_TOKEN` (an access token that can comment on pull requests).",
+}
+
+// phylumCiCommand is the phylum-ci invocation for the configured group
+func (s *Syringe) phylumCiCommand() string {
+	if s.PhylumGroupName == "" {
+		return "phylum-ci -vv"
+	}
+	return fmt.Sprintf("phylum-ci -vv --group %v", s.PhylumGroupName)
+}
+
+// PlanInjection builds the change that adds Phylum to a hydrated project's CI: a GitHub Actions
+// workflow, a GitLab CI include, an Azure Pipelines step or a Bitbucket Pipelines step, depending
+// on the project's VCS. Existing CI files are edited in place.
+func (s *Syringe) PlanInjection(project *structs.SyringeProject, headBranch string) (*structs.ChangeRequest, error) {
+	vcsType, _, _, _, err := structs.ParseProjectKey(project.Id)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]*structs.VcsFile, len(project.CiFiles))
+	for _, ciFile := range project.CiFiles {
+		existing[strings.TrimPrefix(ciFile.Path, "/")] = ciFile
+	}
+	command := s.phylumCiCommand()
+
+	var files []*structs.FileChange
+	switch vcsType {
+	case "github":
+		if _, ok := existing[githubWorkflowPath]; ok {
+			return nil, fmt.Errorf("%v already exists", githubWorkflowPath)
+		}
+		files = append(files, &structs.FileChange{Path: githubWorkflowPath, Content: []byte(fmt.Sprintf(githubWorkflow, command))})
+
+	case "gitlab":
+		files = append(files, &structs.FileChange{Path: gitlabJobPath, Content: []byte(fmt.Sprintf(gitlabJob, command))})
+		ciFile := existing[".gitlab-ci.yml"]
+		if ciFile == nil {
+			files = append(files, &structs.FileChange{Path: ".gitlab-ci.yml", Content: []byte("include:\n" + indentYaml(gitlabInclude, "  "))})
+			break
+		}
+		content, err := insertYamlBlock(string(ciFile.Content), "include", gitlabInclude)
+		if err != nil {
+			return nil, fmt.Errorf(".gitlab-ci.yml: %v", err)
+		}
+		files = append(files, &structs.FileChange{Path: ciFile.Path, Content: []byte(content), Previous: ciFile})
+
+	case "azure":
+		step := fmt.Sprintf(azureStep, command)
+		ciFile := existing["azure-pipelines.yml"]
+		if ciFile == nil {
+			files = append(files, &structs.FileChange{Path: "azure-pipelines.yml", Content: []byte(azurePipeline + indentYaml(step, "  "))})
+			break
+		}
+		// Steps can't be added to a pipeline made of stages or jobs without knowing which one
+		if !hasYamlKey(string(ciFile.Content), "steps") {
+			return nil, fmt.Errorf("azure-pipelines.yml has no top-level steps to add to")
+		}
+		content, err := insertYamlBlock(string(ciFile.Content), "steps", step)
+		if err != nil {
+			return nil, fmt.Errorf("azure-pipelines.yml: %v", err)
+		}
+		files = append(files, &structs.FileChange{Path: ciFile.Path, Content: []byte(content), Previous: ciFile})
+
+	case "bitbucket_cloud":
+		pullRequests := fmt.Sprintf(bitbucketPullRequests, command)
+		ciFile := existing["bitbucket-pipelines.yml"]
+		if ciFile == nil {
+			files = append(files, &structs.FileChange{Path: "bitbucket-pipelines.yml", Content: []byte("pipelines:\n" + indentYaml(pullRequests, "  "))})
+			break
+		}
+		if regexp.MustCompile(`(?m)^\s+pull-requests:`).MatchString(string(ciFile.Content)) {
+			return nil, fmt.Errorf("bitbucket-pipelines.yml already has pull-requests pipelines")
+		}
+		content, err := insertYamlBlock(string(ciFile.Content), "pipelines", pullRequests)
+		if err != nil {
+			return nil, fmt.Errorf("bitbucket-pipelines.yml: %v", err)
+		}
+		files = append(files, &structs.FileChange{Path: ciFile.Path, Content: []byte(content), Previous: ciFile})
+
+	default:
+		return nil, ErrInjectUnsupported
+	}
+
+	body := "Syringe found lockfiles in this repository that are not analyzed by Phylum. This change runs Phylum on every pull request."
+	if s.PhylumGroupName != "" {
+		body += fmt.Sprintf(" Results are reported to the `%v` Phylum group.", s.PhylumGroupName)
+	}
+	body += "\n\n" + injectSecrets[vcsType]
+
+	return &structs.ChangeRequest{
+		BaseBranch:    project.Branch,
+		HeadBranch:    headBranch,
+		Title:         injectTitle,
+		Body:          body,
+		CommitMessage: injectTitle,
+		Files:         files,
+	}, nil
+}
+
+// Inject opens change as a pull or merge request with the client of the project's source
+func (s *Syringe) Inject(project *structs.SyringeProject, change *structs.ChangeRequest) (string, error) {
+	client, err := s.clientFor(project)
+	if err != nil {
+		return "", err
+	}
+	injector, ok := client.(Injector)
+	if !ok {
+		return "", ErrInjectUnsupported
+	}
+	return injector.OpenChangeRequest(project.Id, change)
+}
+
+// topLevelKeyRegex matches a top-level YAML key, capturing anything after the colon
+func topLevelKeyRegex(key string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`^%v:(.*)$`, regexp.QuoteMeta(key)))
+}
+
+// hasYamlKey reports whether content has key at the top level
+func hasYamlKey(content string, key string) bool {
+	keyRegex := topLevelKeyRegex(key)
+	for _, line := range strings.Split(content, "\n") {
+		if keyRegex.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// indentYaml prefixes every non-empty line of block with indent
+func indentYaml(block string, indent string) string {
+	lines := strings.Split(strings.TrimSuffix(block, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = indent + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// insertYamlBlock adds block, written as if it were at the top level, as the last child of the
+// top-level key, at the indentation of the key's existing children. This edits the text rather
+// than re-encoding the YAML so the rest of the file, comments included, is left untouched. The key
+// is appended when missing; a key with an inline value can't be extended.
+func insertYamlBlock(content string, key string, block string) (string, error) {
+	keyRegex := topLevelKeyRegex(key)
+	lines := strings.Split(content, "\n")
+
+	keyIdx := -1
+	for idx, line := range lines {
+		if match := keyRegex.FindStringSubmatch(line); match != nil {
+			rest := strings.TrimSpace(match[1])
+			if rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", fmt.Errorf("top-level %v has an inline value", key)
+			}
+			keyIdx = idx
+			break
+		}
+	}
+	if keyIdx == -1 {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		return content + key + ":\n" + indentYaml(block, "  "), nil
+	}
+
+	// The key's children are indented lines, or sequence items which may start at column 0
+	lastChild := keyIdx
+	indent := "  "
+	for idx := keyIdx + 1; idx < len(lines); idx++ {
+		line := lines[idx]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "-") {
+			break
+		}
+		if lastChild == keyIdx {
+			indent = line[:len(line)-len(strings.TrimLeft(line, " "))]
+		}
+		lastChild = idx
+	}
+
+	inserted := strings.Split(strings.TrimSuffix(indentYaml(block, indent), "\n"), "\n")
+	retLines := append([]string{}, lines[:lastChild+1]...)
+	retLines = append(retLines, inserted...)
+	retLines = append(retLines, lines[lastChild+1:]...)
+	return strings.Join(retLines, "\n"), nil
+}
+
+// FormatChangePatch renders change as a unified diff, for reviewing an injection before it's made
+func FormatChangePatch(change *structs.ChangeRequest) string {
+	var patch strings.Builder
+	for _, file := range change.Files {
+		path := strings.TrimPrefix(file.Path, "/")
+		var oldLines []string
+		if file.Previous == nil {
+			fmt.Fprintf(&patch, "--- /dev/null\n+++ b/%v\n", path)
+		} else {
+			fmt.Fprintf(&patch, "--- a/%v\n+++ b/%v\n", path, path)
+			oldLines = splitLines(string(file.Previous.Content))
+		}
+		patch.WriteString(diffHunk(oldLines, splitLines(string(file.Content))))
+	}
+	return patch.String()
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// diffHunk renders one hunk covering everything between the common leading and trailing lines,
+// which is exact for the single insertions inject makes
+func diffHunk(oldLines []string, newLines []string) string {
+	const context = 3
+
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+	if prefix == len(oldLines) && prefix == len(newLines) {
+		return ""
+	}
+
+	start := prefix - context
+	if start < 0 {
+		start = 0
+	}
+	oldEnd := len(oldLines) - suffix + context
+	if oldEnd > len(oldLines) {
+		oldEnd = len(oldLines)
+	}
+	newEnd := len(newLines) - suffix + context
+	if newEnd > len(newLines) {
+		newEnd = len(newLines)
+	}
+
+	var hunk strings.Builder
+	fmt.Fprintf(&hunk, "@@ -%v +%v @@\n", hunkRange(start, oldEnd-start), hunkRange(start, newEnd-start))
+	for _, line := range oldLines[start:prefix] {
+		fmt.Fprintf(&hunk, " %v\n", line)
+	}
+	for _, line := range oldLines[prefix : len(oldLines)-suffix] {
+		fmt.Fprintf(&hunk, "-%v\n", line)
+	}
+	for _, line := range newLines[prefix : len(newLines)-suffix] {
+		fmt.Fprintf(&hunk, "+%v\n", line)
+	}
+	for _, line := range oldLines[len(oldLines)-suffix : oldEnd] {
+		fmt.Fprintf(&hunk, " %v\n", line)
+	}
+	return hunk.String()
+}
+
+// hunkRange formats a unified diff range; an empty range starts at the line before it
+func hunkRange(start int, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%v,0", start)
+	}
+	return fmt.Sprintf("%v,%v", start+1, length)
+}
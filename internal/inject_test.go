@@ -0,0 +1,190 @@
+package syringePackage
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+)
+
+func TestInsertYamlBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		key     string
+		block   string
+		want    string
+		wantErr bool
+	}{
+		{"missing key", "stages:\n  - test\n", "include", "- local: a.yml\n",
+			"stages:\n  - test\ninclude:\n  - local: a.yml\n", false},
+		{"missing key without trailing newline", "stages: [test]", "include", "- local: a.yml\n",
+			"stages: [test]\ninclude:\n  - local: a.yml\n", false},
+		{"indented list", "include:\n    - local: b.yml\n# build\nbuild:\n  script: make\n", "include", "- local: a.yml\n",
+			"include:\n    - local: b.yml\n    - local: a.yml\n# build\nbuild:\n  script: make\n", false},
+		{"list at column 0", "steps:\n- script: make\n  displayName: Build\npool:\n  vmImage: ubuntu-latest\n", "steps", "- script: test\n  displayName: Test\n",
+			"steps:\n- script: make\n  displayName: Build\n- script: test\n  displayName: Test\npool:\n  vmImage: ubuntu-latest\n", false},
+		{"mapping", "pipelines:  # ci\n  default:\n    - step:\n        script:\n          - make\n", "pipelines", "branches:\n  main: []\n",
+			"pipelines:  # ci\n  default:\n    - step:\n        script:\n          - make\n  branches:\n    main: []\n", false},
+		{"inline value", "include: 'b.yml'\n", "include", "- local: a.yml\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := insertYamlBlock(tt.content, tt.key, tt.block)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("insertYamlBlock() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("insertYamlBlock() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyringe_PlanInjection(t *testing.T) {
+	s := &Syringe{PhylumGroupName: "acme"}
+	ciFile := func(path string, content string) []*structs.VcsFile {
+		return []*structs.VcsFile{{Name: path, Path: path, Id: "abc123", Content: []byte(content)}}
+	}
+
+	tests := []struct {
+		name      string
+		vcsType   string
+		ciFiles   []*structs.VcsFile
+		wantPaths []string
+		wantErr   bool
+	}{
+		{"github", "github", nil, []string{".github/workflows/phylum.yml"}, false},
+		{"github existing workflow", "github", ciFile(".github/workflows/phylum.yml", "name: x\n"), nil, true},
+		{"gitlab new", "gitlab", nil, []string{".gitlab/phylum.yml", ".gitlab-ci.yml"}, false},
+		{"gitlab existing", "gitlab", ciFile(".gitlab-ci.yml", "test:\n  script: make test\n"), []string{".gitlab/phylum.yml", ".gitlab-ci.yml"}, false},
+		{"azure new", "azure", nil, []string{"azure-pipelines.yml"}, false},
+		{"azure existing", "azure", ciFile("/azure-pipelines.yml", "steps:\n  - script: make\n"), []string{"/azure-pipelines.yml"}, false},
+		{"azure stages", "azure", ciFile("/azure-pipelines.yml", "stages:\n  - stage: build\n"), nil, true},
+		{"bitbucket new", "bitbucket_cloud", nil, []string{"bitbucket-pipelines.yml"}, false},
+		{"bitbucket existing", "bitbucket_cloud", ciFile("bitbucket-pipelines.yml", "pipelines:\n  default:\n    - step:\n        script:\n          - make\n"), []string{"bitbucket-pipelines.yml"}, false},
+		{"bitbucket pull-requests", "bitbucket_cloud", ciFile("bitbucket-pipelines.yml", "pipelines:\n  pull-requests:\n    '**':\n      - step:\n          script:\n            - make\n"), nil, true},
+		{"local", "local", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			project := &structs.SyringeProject{
+				Id:      structs.NewProjectKey(tt.vcsType, "vcs.example.com", "acme", "1"),
+				Name:    "acme/api",
+				Branch:  "main",
+				CiFiles: tt.ciFiles,
+			}
+			got, err := s.PlanInjection(project, DefaultInjectBranch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PlanInjection() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			var gotPaths []string
+			for _, file := range got.Files {
+				gotPaths = append(gotPaths, file.Path)
+				if file.Previous != nil && !strings.Contains(string(file.Content), string(file.Previous.Content)) {
+					t.Errorf("PlanInjection() %v dropped existing content: %q", file.Path, file.Content)
+				}
+			}
+			if !reflect.DeepEqual(gotPaths, tt.wantPaths) {
+				t.Errorf("PlanInjection() paths = %v, want %v", gotPaths, tt.wantPaths)
+			}
+			if !strings.Contains(string(got.Files[0].Content), "phylum-ci -vv --group acme") && !strings.Contains(string(got.Files[0].Content), "include") {
+				t.Errorf("PlanInjection() %v does not run phylum-ci for the group: %q", got.Files[0].Path, got.Files[0].Content)
+			}
+			if got.BaseBranch != "main" || got.HeadBranch != DefaultInjectBranch {
+				t.Errorf("PlanInjection() branches = %v <- %v", got.BaseBranch, got.HeadBranch)
+			}
+		})
+	}
+}
+
+func TestFormatChangePatch(t *testing.T) {
+	change := &structs.ChangeRequest{Files: []*structs.FileChange{
+		{Path: "new.yml", Content: []byte("a: 1\nb: 2\n")},
+		{
+			Path:     ".gitlab-ci.yml",
+			Content:  []byte("one\ntwo\nthree\nfour\nfive\nsix\ninserted\nseven\n"),
+			Previous: &structs.VcsFile{Path: ".gitlab-ci.yml", Content: []byte("one\ntwo\nthree\nfour\nfive\nsix\nseven\n")},
+		},
+	}}
+	want := `--- /dev/null
++++ b/new.yml
+@@ -0,0 +1,2 @@
++a: 1
++b: 2
+--- a/.gitlab-ci.yml
++++ b/.gitlab-ci.yml
+@@ -4,4 +4,5 @@
+ four
+ five
+ six
++inserted
+ seven
+`
+	if got := FormatChangePatch(change); got != want {
+		t.Errorf("FormatChangePatch() got =\n%v\nwant =\n%v", got, want)
+	}
+}
+
+// fakeInjectorClient records the change it is asked to open
+type fakeInjectorClient struct {
+	projectId string
+	change    *structs.ChangeRequest
+}
+
+func (f *fakeInjectorClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	return &[]*structs.SyringeProject{}, nil
+}
+
+func (f *fakeInjectorClient) GetLockfilesByProject(string, string) ([]*structs.VcsFile, error) {
+	return nil, nil
+}
+
+func (f *fakeInjectorClient) OpenChangeRequest(projectId string, change *structs.ChangeRequest) (string, error) {
+	f.projectId = projectId
+	f.change = change
+	return "https://vcs.example.com/acme/api/pull/1", nil
+}
+
+func TestSyringe_Inject(t *testing.T) {
+	injector := &fakeInjectorClient{}
+	s := &Syringe{Sources: []*Source{{Name: "github", Client: injector}}}
+	project := &structs.SyringeProject{Id: structs.NewProjectKey("github", "github.com", "acme", "1"), Name: "acme/api", Source: "github", Branch: "main"}
+
+	change, err := s.PlanInjection(project, DefaultInjectBranch)
+	if err != nil {
+		t.Fatalf("PlanInjection() error = %v", err)
+	}
+	got, err := s.Inject(project, change)
+	if err != nil {
+		t.Fatalf("Inject() error = %v", err)
+	}
+	if got != "https://vcs.example.com/acme/api/pull/1" || injector.projectId != project.Id || injector.change != change {
+		t.Errorf("Inject() got = %v, opened %v for %v", got, injector.change, injector.projectId)
+	}
+
+	// Clients that can't open pull requests are reported as unsupported
+	s.Sources[0].Client = &fakeClient{}
+	if _, err := s.Inject(project, change); !errors.Is(err, ErrInjectUnsupported) {
+		t.Errorf("Inject() error = %v, want %v", err, ErrInjectUnsupported)
+	}
+}
+
+// fakeClient is a Client with no optional capabilities
+type fakeClient struct{}
+
+func (f *fakeClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	return &[]*structs.SyringeProject{}, nil
+}
+
+func (f *fakeClient) GetLockfilesByProject(string, string) ([]*structs.VcsFile, error) {
+	return nil, nil
+}
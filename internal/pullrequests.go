@@ -0,0 +1,103 @@
+package syringePackage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/schollz/progressbar/v3"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+)
+
+// ErrPullRequestsUnsupported is returned for clients that can't list pull/merge requests
+var ErrPullRequestsUnsupported = errors.New("scanning pull/merge requests is not supported for this VCS")
+
+// ErrNoPhylumProject is returned when a pull request's repo has no Phylum project to analyze against
+var ErrNoPhylumProject = errors.New("no Phylum project for this lockfile, run run-phylum first")
+
+// PullRequestLabel is the label a pull request's analyses are submitted with, e.g. pr-12
+func PullRequestLabel(pullRequest *structs.PullRequest) string {
+	return fmt.Sprintf("pr-%v", pullRequest.Id)
+}
+
+// GetPullRequests lists a project's open pull/merge requests that add or modify lockfiles, each
+// with the head version of those lockfiles. A request whose files can't be read is logged and
+// left out.
+func (s *Syringe) GetPullRequests(project *structs.SyringeProject) ([]*structs.PullRequest, error) {
+	var retPullRequests []*structs.PullRequest
+
+	client, err := s.clientFor(project)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := client.(PullRequestLister)
+	if !ok {
+		return nil, ErrPullRequestsUnsupported
+	}
+
+	pullRequests, err := lister.ListPullRequests(project.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, pullRequest := range pullRequests {
+		lockfiles, err := lister.GetPullRequestLockfiles(project.Id, pullRequest)
+		if err != nil {
+			log.Warnf("Failed to get lockfiles of pull request %v in %v: %v\n", pullRequest.Id, project.Name, err)
+			continue
+		}
+		if len(lockfiles) == 0 {
+			continue
+		}
+		pullRequest.Lockfiles = lockfiles
+		retPullRequests = append(retPullRequests, pullRequest)
+	}
+	return retPullRequests, nil
+}
+
+// GetAllPullRequests fills in PullRequests for every project. Projects whose client can't list
+// pull/merge requests are skipped.
+func (s *Syringe) GetAllPullRequests() error {
+	var wg sync.WaitGroup
+	pullRequestsBar := progressbar.NewOptions(len(*s.Projects), progressbar.OptionSetDescription("Getting Pull Requests"))
+	sem := semaphore.NewWeighted(50)
+
+	for _, project := range *s.Projects {
+		wg.Add(1)
+		sem.Acquire(context.Background(), 1)
+		go func(p *structs.SyringeProject) {
+			defer wg.Done()
+			defer sem.Release(1)
+			pullRequests, err := s.GetPullRequests(p)
+			pullRequestsBar.Add(1)
+			if errors.Is(err, ErrPullRequestsUnsupported) {
+				log.Debugf("Client can't list pull requests, skipping %v\n", p.Name)
+				return
+			}
+			if err != nil {
+				log.Warnf("failed to GetPullRequests() for %v: %v\n", p.Name, err)
+				return
+			}
+			s.ProjectsMapMutex.Lock()
+			p.PullRequests = pullRequests
+			s.ProjectsMapMutex.Unlock()
+		}(project)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// PhylumAnalyzePullRequest analyzes the head version of a pull request's lockfile against the
+// Phylum project of the same lockfile on the default branch, labelled with PullRequestLabel. No
+// project is created for it; ErrNoPhylumProject is returned when there isn't one yet.
+func (s *Syringe) PhylumAnalyzePullRequest(project *structs.SyringeProject, pullRequest *structs.PullRequest, lockfile *structs.VcsFile, phylumProjectMap *map[string]structs.PhylumProject) error {
+	phylumProjectName := s.phylumProjectName(project, lockfile)
+	phylumProject, ok := (*phylumProjectMap)[phylumProjectName]
+	if !ok {
+		return ErrNoPhylumProject
+	}
+	return s.phylumAnalyze(phylumProject, lockfile, phylumProjectName, PullRequestLabel(pullRequest))
+}
@@ -0,0 +1,94 @@
+package syringePackage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+)
+
+// fakePullRequestClient serves one project with open pull requests, keyed by id to the lockfiles
+// each one changes
+type fakePullRequestClient struct {
+	lockfiles map[string][]string
+}
+
+func (f *fakePullRequestClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	return &[]*structs.SyringeProject{{Id: structs.NewProjectKey("gitlab", "gitlab.com", "", "1"), Name: "acme/api", Branch: "main"}}, nil
+}
+
+func (f *fakePullRequestClient) GetLockfilesByProject(string, string) ([]*structs.VcsFile, error) {
+	return nil, nil
+}
+
+func (f *fakePullRequestClient) ListPullRequests(projectId string) ([]*structs.PullRequest, error) {
+	return []*structs.PullRequest{
+		{Id: "1", HeadSha: "a1"},
+		{Id: "2", HeadSha: "b2"},
+		{Id: "3", HeadSha: "c3"},
+	}, nil
+}
+
+func (f *fakePullRequestClient) GetPullRequestLockfiles(projectId string, pullRequest *structs.PullRequest) ([]*structs.VcsFile, error) {
+	paths, ok := f.lockfiles[pullRequest.Id]
+	if !ok {
+		return nil, fmt.Errorf("pull request %v is gone", pullRequest.Id)
+	}
+	var files []*structs.VcsFile
+	for _, path := range paths {
+		files = append(files, &structs.VcsFile{Name: path, Path: path, Id: pullRequest.HeadSha})
+	}
+	return files, nil
+}
+
+func TestSyringe_GetAllPullRequests(t *testing.T) {
+	s := &Syringe{
+		Sources: []*Source{
+			{Name: "gitlab", Client: &fakePullRequestClient{lockfiles: map[string][]string{"1": {"Gemfile.lock"}, "2": nil}}},
+			{Name: "other", Client: &fakeClient{}},
+		},
+		ProjectsMap: make(map[string]*structs.SyringeProject, 0),
+	}
+	if err := s.ListProjects(); err != nil {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+	if err := s.GetAllPullRequests(); err != nil {
+		t.Fatalf("GetAllPullRequests() error = %v", err)
+	}
+
+	// Requests without lockfile changes, and ones whose files can't be read, are left out
+	project := (*s.Projects)[0]
+	var gotIds []string
+	for _, pullRequest := range project.PullRequests {
+		gotIds = append(gotIds, pullRequest.Id)
+	}
+	if !reflect.DeepEqual(gotIds, []string{"1"}) {
+		t.Fatalf("GetAllPullRequests() pull requests = %v, want [1]", gotIds)
+	}
+	if lockfiles := project.PullRequests[0].Lockfiles; len(lockfiles) != 1 || lockfiles[0].Path != "Gemfile.lock" || lockfiles[0].Id != "a1" {
+		t.Errorf("GetAllPullRequests() lockfiles = %v", lockfiles)
+	}
+	if label := PullRequestLabel(project.PullRequests[0]); label != "pr-1" {
+		t.Errorf("PullRequestLabel() got = %v, want pr-1", label)
+	}
+
+	// Clients that can't list pull requests are reported as unsupported
+	if _, err := s.GetPullRequests(&structs.SyringeProject{Name: "x", Source: "other"}); !errors.Is(err, ErrPullRequestsUnsupported) {
+		t.Errorf("GetPullRequests() error = %v, want %v", err, ErrPullRequestsUnsupported)
+	}
+}
+
+func TestSyringe_PhylumAnalyzePullRequest(t *testing.T) {
+	s := &Syringe{Sources: []*Source{{Name: "gitlab", Client: &fakePullRequestClient{}}}}
+	project := &structs.SyringeProject{Id: structs.NewProjectKey("gitlab", "gitlab.com", "", "1"), Name: "acme/api", Source: "gitlab"}
+	pullRequest := &structs.PullRequest{Id: "1", HeadSha: "a1"}
+	lockfile := &structs.VcsFile{Name: "Gemfile.lock", Path: "Gemfile.lock", Id: "a1"}
+
+	// Pull requests are only analyzed against the project run-phylum created for the lockfile
+	phylumProjectMap := &map[string]structs.PhylumProject{"SYR-acme/api__yarn.lock": {Name: "SYR-acme/api__yarn.lock"}}
+	if err := s.PhylumAnalyzePullRequest(project, pullRequest, lockfile, phylumProjectMap); !errors.Is(err, ErrNoPhylumProject) {
+		t.Errorf("PhylumAnalyzePullRequest() error = %v, want %v", err, ErrNoPhylumProject)
+	}
+}
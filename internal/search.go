@@ -0,0 +1,44 @@
+package syringePackage
+
+import (
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// SearchResult is the outcome of one source's code search: the lockfile and CI file paths found,
+// keyed by the ID part of the project key, or the error that makes the source fall back to the API
+type SearchResult struct {
+	Paths map[string][]string
+	Err   error
+}
+
+// searchPaths returns the paths code search found in project. The project's source is searched the
+// first time one of its projects asks, and projects of other sources wait for it rather than
+// searching concurrently, which the search rate limits wouldn't allow anyway.
+func (s *Syringe) searchPaths(project *structs.SyringeProject, searcher Searcher) ([]string, error) {
+	s.SearchMutex.Lock()
+	defer s.SearchMutex.Unlock()
+
+	if s.Searches == nil {
+		s.Searches = make(map[string]*SearchResult, 0)
+	}
+	result, ok := s.Searches[project.Source]
+	if !ok {
+		paths, err := searcher.SearchFiles(utils.IsLockfileOrCiFile)
+		if err != nil {
+			log.Warnf("Code search failed for source %v, listing each project instead: %v\n", project.Source, err)
+		}
+		result = &SearchResult{Paths: paths, Err: err}
+		s.Searches[project.Source] = result
+	}
+	if result.Err != nil {
+		return nil, result.Err
+	}
+
+	_, _, _, id, err := structs.ParseProjectKey(project.Id)
+	if err != nil {
+		return nil, err
+	}
+	return result.Paths[id], nil
+}
@@ -0,0 +1,104 @@
+package syringePackage
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+)
+
+// fakeSearchClient serves projects 1 to 3, finding files by code search or by listing each tree
+type fakeSearchClient struct {
+	files     map[string][]string // project ID -> paths
+	oversized map[string][]string // project ID -> paths too large for code search to index
+	searchErr error
+	searches  int
+	listed    []string // project keys whose tree was listed
+	fetched   []string // paths fetched by GetFiles
+}
+
+func (f *fakeSearchClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	var projects []*structs.SyringeProject
+	for _, id := range []string{"1", "2", "3"} {
+		projects = append(projects, &structs.SyringeProject{Id: structs.NewProjectKey("github", "github.com", "acme", id), Name: "repo" + id, Branch: "main"})
+	}
+	return &projects, nil
+}
+
+func (f *fakeSearchClient) GetLockfilesByProject(projectId string, branch string) ([]*structs.VcsFile, error) {
+	f.listed = append(f.listed, projectId)
+	_, _, _, id, _ := structs.ParseProjectKey(projectId)
+	return f.GetFiles(projectId, branch, append(append([]string{}, f.files[id]...), f.oversized[id]...))
+}
+
+func (f *fakeSearchClient) SearchFiles(match func(string) bool) (map[string][]string, error) {
+	f.searches++
+	if f.searchErr != nil {
+		return nil, f.searchErr
+	}
+	return f.files, nil
+}
+
+func (f *fakeSearchClient) GetFiles(projectId string, ref string, paths []string) ([]*structs.VcsFile, error) {
+	var files []*structs.VcsFile
+	for _, path := range paths {
+		f.fetched = append(f.fetched, path)
+		files = append(files, &structs.VcsFile{Name: filepath.Base(path), Path: path})
+	}
+	return files, nil
+}
+
+func TestSyringe_SearchDiscovery(t *testing.T) {
+	files := map[string][]string{
+		"1": {"package-lock.json", ".github/workflows/ci.yml"},
+		"2": {"services/api/poetry.lock"},
+	}
+	oversized := map[string][]string{"3": {"yarn.lock"}}
+
+	tests := []struct {
+		name         string
+		searchErr    error
+		wantSearches int
+		wantListed   int
+	}{
+		// project 3 has no hits, so its tree is listed
+		{"search", nil, 1, 1},
+		{"search failed", errors.New("search unavailable"), 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeSearchClient{files: files, oversized: oversized, searchErr: tt.searchErr}
+			s := &Syringe{
+				Sources:     []*Source{{Name: "github", Client: client}},
+				ProjectsMap: make(map[string]*structs.SyringeProject, 0),
+				Discovery:   "search",
+			}
+			if err := s.ListProjects(); err != nil {
+				t.Fatalf("ListProjects() error = %v", err)
+			}
+			if err := s.GetAllLockfilesSerial(); err != nil {
+				t.Fatalf("GetAllLockfilesSerial() error = %v", err)
+			}
+
+			if client.searches != tt.wantSearches || len(client.listed) != tt.wantListed {
+				t.Errorf("searches = %v, listed %v projects, want %v and %v", client.searches, len(client.listed), tt.wantSearches, tt.wantListed)
+			}
+			fetched := append([]string{}, client.fetched...)
+			sort.Strings(fetched)
+			if want := []string{".github/workflows/ci.yml", "package-lock.json", "services/api/poetry.lock", "yarn.lock"}; !reflect.DeepEqual(fetched, want) {
+				t.Errorf("fetched %v, want %v", fetched, want)
+			}
+
+			project := s.ProjectsMap[structs.NewProjectKey("github", "github.com", "acme", "1")]
+			if len(project.Lockfiles) != 1 || len(project.CiFiles) != 1 {
+				t.Errorf("project 1 lockfiles = %v, CI files = %v", project.Lockfiles, project.CiFiles)
+			}
+			if project := s.ProjectsMap[structs.NewProjectKey("github", "github.com", "acme", "3")]; len(project.Lockfiles) != 1 {
+				t.Errorf("project 3 with an unindexed lockfile got lockfiles %v", project.Lockfiles)
+			}
+		})
+	}
+}
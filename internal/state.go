@@ -0,0 +1,150 @@
+package syringePackage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	log "github.com/sirupsen/logrus"
+)
+
+// LoadScanState reads a state file. A missing file is an empty state, as on the first run.
+func LoadScanState(filename string) (*structs.ScanState, error) {
+	state := &structs.ScanState{}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Debugf("No scan state at %v, scanning everything\n", filename)
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Projects == nil {
+		state.Projects = make(map[string]*structs.ProjectScanState, 0)
+	}
+	return state, nil
+}
+
+// SaveScanState writes a state file through a temp file, so an interrupted run leaves the previous
+// state intact rather than a truncated one
+func SaveScanState(filename string, state *structs.ScanState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), filename)
+}
+
+// SaveState writes the scan state back to the state file it was loaded from
+func (s *Syringe) SaveState() error {
+	if s.State == nil {
+		return nil
+	}
+	s.StateMutex.Lock()
+	defer s.StateMutex.Unlock()
+	return SaveScanState(s.StateFile, s.State)
+}
+
+// projectState returns the project's entry in the scan state, adding it if needed. Callers hold
+// StateMutex.
+func (s *Syringe) projectState(projectId string) *structs.ProjectScanState {
+	projectState, ok := s.State.Projects[projectId]
+	if !ok {
+		projectState = &structs.ProjectScanState{}
+		s.State.Projects[projectId] = projectState
+	}
+	if projectState.Lockfiles == nil {
+		projectState.Lockfiles = make(map[string]string, 0)
+	}
+	return projectState
+}
+
+// checkHead looks up the commit the project's default branch points at and reports whether it is
+// the one the last scan finished at. Projects are always rescanned with --full, when other refs
+// are scanned, or when the client can't report heads, in which case head is empty.
+func (s *Syringe) checkHead(project *structs.SyringeProject) (head string, unchanged bool) {
+	if s.State == nil || project.Branch == "" {
+		return "", false
+	}
+	client, err := s.clientFor(project)
+	if err != nil {
+		return "", false
+	}
+	headGetter, ok := client.(HeadGetter)
+	if !ok {
+		return "", false
+	}
+
+	head, err = headGetter.GetHead(project.Id, project.Branch)
+	if err != nil {
+		log.Warnf("Failed to get head of %v, rescanning it: %v\n", project.Name, err)
+		return "", false
+	}
+
+	if s.Full || len(s.Refs.Branches) > 0 || len(s.Refs.Tags) > 0 {
+		return head, false
+	}
+	s.StateMutex.Lock()
+	defer s.StateMutex.Unlock()
+	projectState, ok := s.State.Projects[project.Id]
+	return head, ok && projectState.Head == head
+}
+
+// LockfileUnchanged reports whether the lockfile was last analyzed with the same blob SHA
+func (s *Syringe) LockfileUnchanged(project *structs.SyringeProject, lockfile *structs.VcsFile) bool {
+	if s.State == nil || s.Full || lockfile.Id == "" {
+		return false
+	}
+	s.StateMutex.Lock()
+	defer s.StateMutex.Unlock()
+	projectState, ok := s.State.Projects[project.Id]
+	return ok && projectState.Lockfiles[s.phylumProjectName(project, lockfile)] == lockfile.Id
+}
+
+// RecordAnalyzed records the blob SHA the lockfile was analyzed with
+func (s *Syringe) RecordAnalyzed(project *structs.SyringeProject, lockfile *structs.VcsFile) {
+	if s.State == nil || lockfile.Id == "" {
+		return
+	}
+	s.StateMutex.Lock()
+	defer s.StateMutex.Unlock()
+	s.projectState(project.Id).Lockfiles[s.phylumProjectName(project, lockfile)] = lockfile.Id
+}
+
+// RecordHead records that every lockfile of a rescanned project has been analyzed at its Head,
+// so the next run can skip the project until its default branch moves. Lockfiles that are gone
+// from the project are forgotten.
+func (s *Syringe) RecordHead(project *structs.SyringeProject) {
+	if s.State == nil || project.Unchanged || project.Head == "" {
+		return
+	}
+	s.StateMutex.Lock()
+	defer s.StateMutex.Unlock()
+
+	projectState := s.projectState(project.Id)
+	projectState.Head = project.Head
+	current := make(map[string]string, len(project.Lockfiles))
+	for _, lockfile := range project.Lockfiles {
+		name := s.phylumProjectName(project, lockfile)
+		if id, ok := projectState.Lockfiles[name]; ok {
+			current[name] = id
+		}
+	}
+	projectState.Lockfiles = current
+}
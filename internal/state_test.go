@@ -0,0 +1,120 @@
+package syringePackage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+)
+
+func TestScanState_SaveLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "syringe_state.json")
+
+	// The first run has no state file yet
+	got, err := LoadScanState(filename)
+	if err != nil {
+		t.Fatalf("LoadScanState() error = %v", err)
+	}
+	if got.Projects == nil || len(got.Projects) != 0 {
+		t.Errorf("LoadScanState() of a missing file got = %v", got)
+	}
+
+	want := &structs.ScanState{Projects: map[string]*structs.ProjectScanState{
+		"github/github.com/acme/1": {Head: "c0ffee", Lockfiles: map[string]string{"SYR-api__package-lock.json": "b1"}},
+	}}
+	if err := SaveScanState(filename, want); err != nil {
+		t.Fatalf("SaveScanState() error = %v", err)
+	}
+	got, err = LoadScanState(filename)
+	if err != nil {
+		t.Fatalf("LoadScanState() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadScanState() got = %v, want %v", got, want)
+	}
+}
+
+// fakeHeadClient serves one project whose head and lockfile contents can be changed between scans
+type fakeHeadClient struct {
+	head      string
+	lockfiles map[string]string // path -> blob SHA
+	fetches   int
+}
+
+func (f *fakeHeadClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	return &[]*structs.SyringeProject{{Id: structs.NewProjectKey("github", "github.com", "acme", "1"), Name: "api", Branch: "main"}}, nil
+}
+
+func (f *fakeHeadClient) GetLockfilesByProject(string, string) ([]*structs.VcsFile, error) {
+	f.fetches++
+	var files []*structs.VcsFile
+	for path, id := range f.lockfiles {
+		files = append(files, &structs.VcsFile{Name: path, Path: path, Id: id})
+	}
+	return files, nil
+}
+
+func (f *fakeHeadClient) GetHead(projectId string, branch string) (string, error) {
+	return f.head, nil
+}
+
+func TestSyringe_IncrementalScan(t *testing.T) {
+	client := &fakeHeadClient{head: "c0ffee", lockfiles: map[string]string{"package-lock.json": "b1", "yarn.lock": "b2"}}
+	state := &structs.ScanState{Projects: make(map[string]*structs.ProjectScanState, 0)}
+
+	// scan lists and fetches like run-phylum, analyzing every lockfile that changed, and returns
+	// the paths analyzed
+	scan := func(full bool) (*structs.SyringeProject, []string) {
+		s := &Syringe{
+			Sources:     []*Source{{Name: "github", Client: client}},
+			ProjectsMap: make(map[string]*structs.SyringeProject, 0),
+			State:       state,
+			Full:        full,
+		}
+		if err := s.ListProjects(); err != nil {
+			t.Fatalf("ListProjects() error = %v", err)
+		}
+		if err := s.GetAllLockfilesSerial(); err != nil {
+			t.Fatalf("GetAllLockfilesSerial() error = %v", err)
+		}
+		project := (*s.Projects)[0]
+		var analyzed []string
+		for _, lockfile := range project.Lockfiles {
+			if !s.LockfileUnchanged(project, lockfile) {
+				analyzed = append(analyzed, lockfile.Path)
+				s.RecordAnalyzed(project, lockfile)
+			}
+		}
+		s.RecordHead(project)
+		return project, analyzed
+	}
+
+	project, analyzed := scan(false)
+	if project.Unchanged || len(analyzed) != 2 || client.fetches != 1 {
+		t.Fatalf("first scan: unchanged = %v, analyzed %v, fetches = %v", project.Unchanged, analyzed, client.fetches)
+	}
+
+	// Nothing is fetched while the head stays put
+	project, analyzed = scan(false)
+	if !project.Unchanged || len(analyzed) != 0 || client.fetches != 1 {
+		t.Errorf("unchanged scan: unchanged = %v, analyzed %v, fetches = %v", project.Unchanged, analyzed, client.fetches)
+	}
+
+	// A new head is rescanned, but only the lockfile with a new blob SHA is analyzed
+	client.head = "fea7"
+	client.lockfiles["yarn.lock"] = "b3"
+	project, analyzed = scan(false)
+	if project.Unchanged || !reflect.DeepEqual(analyzed, []string{"yarn.lock"}) || client.fetches != 2 {
+		t.Errorf("moved scan: unchanged = %v, analyzed %v, fetches = %v", project.Unchanged, analyzed, client.fetches)
+	}
+	if got := state.Projects[project.Id].Head; got != "fea7" {
+		t.Errorf("moved scan recorded head %v, want fea7", got)
+	}
+
+	// --full rescans and reanalyzes everything
+	_, analyzed = scan(true)
+	if len(analyzed) != 2 || client.fetches != 3 {
+		t.Errorf("full scan: analyzed %v, fetches = %v", analyzed, client.fetches)
+	}
+}
@@ -1,25 +1,102 @@
 package structs
 
 import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
 )
 
 type VcsFile struct {
 	Name          string
 	Path          string
-	Id            string
+	Id            string // git blob SHA of the content; the head commit for pull request lockfiles
 	Content       []byte
 	PhylumProject *PhylumProject
+	Ref           string // branch or tag the file was read from when it isn't the default branch
 }
 
 type SyringeProject struct {
-	Id        int64
-	Name      string
-	Branch    string
-	Lockfiles []*VcsFile
-	CiFiles   []*VcsFile
-	Hydrated  bool
-	GUID      uuid.UUID
+	Id         string // see NewProjectKey
+	Name       string
+	Source     string // name of the VcsSource the project was listed from
+	Owner      string
+	Namespace  string
+	Branch     string
+	Lockfiles  []*VcsFile
+	CiFiles    []*VcsFile
+	PhylumInCi bool // a CI file already runs Phylum
+	Hydrated   bool
+	GUID       uuid.UUID
+	Head       string // commit of the default branch the lockfiles were read at, when the client reports it
+	Unchanged  bool   // Head matches the last scan, so lockfiles weren't fetched
+	// open pull/merge requests that change lockfiles, see PullRequestLister
+	PullRequests []*PullRequest
+}
+
+// PullRequest is an open pull or merge request. HeadSha is the commit its changed lockfiles are
+// read at; HeadRepo is the repo that commit lives in when the client needs it, e.g. for forks.
+type PullRequest struct {
+	Id         string // number within the project, e.g. 12 for #12 or !12
+	Title      string
+	Url        string
+	HeadBranch string
+	HeadSha    string
+	HeadRepo   string
+	Lockfiles  []*VcsFile // head versions of the lockfiles the request adds or modifies
+}
+
+// PushEvent is a push webhook reduced to what decides whether a repo needs rescanning
+type PushEvent struct {
+	RepoId     string   // ID part of the project key, see NewProjectKey
+	Refs       []string // full names of the refs pushed, e.g. refs/heads/main; deleted refs are left out
+	Paths      []string // files the pushed commits added or modified
+	PathsKnown bool     // Paths is complete; false when the VCS doesn't list files, truncated the list, or a ref was created
+}
+
+// NewProjectKey builds the string that identifies a project across VCS types, hosts and owners,
+// e.g. github/github.com/acme/1234. Each part is path-escaped so owners like GitLab namespaces
+// (acme/platform) can't be confused for separators.
+func NewProjectKey(vcsType string, host string, owner string, id string) string {
+	return strings.Join([]string{
+		url.PathEscape(vcsType),
+		url.PathEscape(host),
+		url.PathEscape(owner),
+		url.PathEscape(id),
+	}, "/")
+}
+
+// ParseProjectKey splits a key made by NewProjectKey back into its parts
+func ParseProjectKey(key string) (vcsType string, host string, owner string, id string, err error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 {
+		return "", "", "", "", fmt.Errorf("invalid project key: %v", key)
+	}
+	for i, part := range parts {
+		if parts[i], err = url.PathUnescape(part); err != nil {
+			return "", "", "", "", fmt.Errorf("invalid project key %v: %v", key, err)
+		}
+	}
+	return parts[0], parts[1], parts[2], parts[3], nil
+}
+
+// ChangeRequest is a set of file changes proposed on a new branch as a pull or merge request
+type ChangeRequest struct {
+	BaseBranch    string
+	HeadBranch    string
+	Title         string
+	Body          string
+	CommitMessage string
+	Files         []*FileChange
+}
+
+// FileChange is the new content of one file. Previous is the file being replaced, nil for a new file.
+type FileChange struct {
+	Path     string
+	Content  []byte
+	Previous *VcsFile
 }
 
 type PhylumProject struct {
@@ -29,20 +106,109 @@ type PhylumProject struct {
 	Ecosystem string `json:"ecosystem"`
 }
 
+// SkippedProject is a project a VCS client listed but left out, and why
+type SkippedProject struct {
+	Name   string
+	Source string
+	Reason string
+}
+
+// RateLimitStats counts a source's requests and how often its VCS throttled them
+type RateLimitStats struct {
+	Source    string
+	Requests  int           // responses received, retries included
+	Throttled int           // 429s, and 503s with Retry-After
+	Retries   int           // throttled requests sent again
+	Waits     int           // times requests were held back, before or after being throttled
+	Waited    time.Duration // total time requests were held back
+}
+
 type SyringeOptions struct {
 	MineOnly  bool
 	RateLimit int
+	Discovery string
+	Filter    RepoFilter
+	Http      HttpOptions
+	Refs      RefOptions
+	StateFile string // incremental scan state, see ScanState. Empty disables incremental scans
+	Full      bool   // rescan and reanalyze everything, still updating StateFile
+}
+
+// ScanState is what an incremental scan remembers between runs, keyed by project key
+type ScanState struct {
+	Projects map[string]*ProjectScanState `json:"projects"`
+}
+
+// ProjectScanState records the default branch commit a project was last fully analyzed at, and
+// the blob SHA each of its lockfiles was last analyzed with, keyed by Phylum project name
+type ProjectScanState struct {
+	Head      string            `json:"head"`
+	Lockfiles map[string]string `json:"lockfiles"`
+}
+
+// RefOptions picks branches and tags to scan for lockfiles in addition to each project's default
+// branch. Patterns are globs as in path.Match, so release/* matches release/1.0 but not release/1.0/rc.
+type RefOptions struct {
+	Branches []string
+	Tags     []string
+	Label    bool // analyze into the default branch's Phylum project with a label, instead of one project per ref
+}
+
+// HttpOptions configures every HTTP client Syringe creates, for the VCS APIs and Phylum alike.
+// The zero value behaves like Go's default client, including the HTTP(S)_PROXY variables.
+type HttpOptions struct {
 	ProxyUrl  string
+	CaBundle  string // PEM file trusted in addition to the system roots
+	Insecure  bool   // skip TLS certificate verification
+	Timeout   time.Duration
+	UserAgent string
+	CacheDir  string // on-disk cache of VCS API responses, revalidated with ETags. Empty disables it
+}
+
+// RepoFilter selects which repositories a VCS client returns from ListProjects.
+// The zero value includes everything.
+type RepoFilter struct {
+	ExcludeForks     bool
+	ExcludeArchived  bool
+	ExcludeTemplates bool
+	Visibility       string // "", "public", "private" or "internal"
+	IncludeTopics    []string
+	ExcludeTopics    []string
 }
 
 type ConfigThing struct {
 	VcsType     string
 	VcsToken    string
 	Associated  map[string]string
+	Sources     []VcsSource `yaml:"sources,omitempty"`
 	PhylumToken string
 	PhylumGroup string
 }
 
+// VcsSource is one named VCS in a config with several. It takes the place of the top-level
+// VcsType, VcsToken and Associated, which are only used when no sources are listed.
+type VcsSource struct {
+	Name       string
+	VcsType    string
+	VcsToken   string
+	Associated map[string]string
+}
+
+// SourceConfig returns the config a client for source should be created with
+func (c *ConfigThing) SourceConfig(source VcsSource) *ConfigThing {
+	associated := source.Associated
+	if associated == nil {
+		associated = make(map[string]string, 0)
+	}
+	return &ConfigThing{
+		VcsType:     source.VcsType,
+		VcsToken:    source.VcsToken,
+		Associated:  associated,
+		PhylumToken: c.PhylumToken,
+		PhylumGroup: c.PhylumGroup,
+	}
+}
+
 type TestConfigData struct {
 	Filename string
 }
@@ -14,6 +14,7 @@ import (
 	"strings"
 	"sync"
 
+	Client2 "github.com/peterjmorgan/Syringe/internal/client"
 	"github.com/peterjmorgan/Syringe/internal/structs"
 	"github.com/peterjmorgan/Syringe/internal/utils"
 	"github.com/schollz/progressbar/v3"
@@ -40,62 +41,191 @@ func init() {
 }
 
 type Syringe struct {
-	Client           Client
+	Sources          []*Source
 	PhylumToken      string //TODO: remove this
 	PhylumGroupName  string
 	Projects         *[]*structs.SyringeProject
-	ProjectsMap      map[int64]*structs.SyringeProject
+	ProjectsMap      map[string]*structs.SyringeProject
 	ProjectsMapMutex sync.RWMutex
+	Skipped          []*structs.SkippedProject
 	LockfileCount    int
 	PhylumClient     *phylum.PhylumClient
+	Discovery        string
+	Refs             structs.RefOptions
+	State            *structs.ScanState // nil unless incremental scans are enabled
+	StateFile        string
+	StateMutex       sync.Mutex
+	Full             bool
+	Searches         map[string]*SearchResult // code search results by source name, see searchPaths
+	SearchMutex      sync.Mutex
 }
 
 // func NewSyringe(envMap map[string]string, opts *structs.SyringeOptions) (*Syringe, error) {
 func NewSyringe(configData *structs.ConfigThing, opts *structs.SyringeOptions) (*Syringe, error) {
 
-	client, err := NewClient(configData.VcsType, configData, opts)
+	var httpOptions structs.HttpOptions
+	if opts != nil {
+		httpOptions = opts.Http
+	}
+	// Checks the proxy and CA bundle once, before any client uses them
+	if err := utils.InstallDefaultTransport(httpOptions); err != nil {
+		log.Errorf("Failed to configure HTTP: %v\n", err)
+		return nil, err
+	}
+
+	sources, err := NewSources(configData, opts)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v\n", err)
+		log.Errorf("Failed to create clients: %v\n", err)
 		return nil, err
 	}
 
+	var refs structs.RefOptions
+	var state *structs.ScanState
+	var stateFile string
+	var full bool
+	if opts != nil {
+		refs = opts.Refs
+		stateFile = opts.StateFile
+		full = opts.Full
+	}
+	if stateFile != "" {
+		state, err = LoadScanState(stateFile)
+		if err != nil {
+			log.Errorf("Failed to read scan state from %v: %v\n", stateFile, err)
+			return nil, err
+		}
+	}
+
+	discovery := "api"
+	if opts != nil && opts.Discovery != "" {
+		discovery = strings.ToLower(opts.Discovery)
+	}
+	if discovery != "api" && discovery != "clone" && discovery != "archive" && discovery != "search" {
+		return nil, fmt.Errorf("unknown discovery mode: %v", discovery)
+	}
+
 	defaultProjects := make([]*structs.SyringeProject, 0)
-	defaultProjectMap := make(map[int64]*structs.SyringeProject, 0)
+	defaultProjectMap := make(map[string]*structs.SyringeProject, 0)
 
 	phylumClient, err := phylum.NewClient(&phylum.ClientOptions{})
 	if err != nil {
 		log.Fatalf("Failed to create Phylum Client: %v\n", err)
 		return nil, err
 	}
+	phylumHttpClient, err := utils.NewHttpClient(httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	phylumClient.Client.SetTransport(phylumHttpClient.Transport).SetTimeout(httpOptions.Timeout)
 
 	return &Syringe{
-		Client:          client,
+		Sources:         sources,
 		PhylumToken:     configData.PhylumToken,
 		PhylumGroupName: configData.PhylumGroup,
 		Projects:        &defaultProjects,
 		ProjectsMap:     defaultProjectMap,
 		LockfileCount:   0,
 		PhylumClient:    phylumClient,
+		Discovery:       discovery,
+		Refs:            refs,
+		State:           state,
+		StateFile:       stateFile,
+		Full:            full,
 	}, nil
 }
 
+// ListProjects lists every source's projects concurrently and merges them in source order.
+// A source that fails is logged and left out rather than failing the others.
 func (s *Syringe) ListProjects() error {
-
-	syringeProjects, err := s.Client.ListProjects()
-	if err != nil {
-		log.Errorf("Failed to list projects: %v\n", err)
+	results := make([]*[]*structs.SyringeProject, len(s.Sources))
+	var wg sync.WaitGroup
+	for idx, source := range s.Sources {
+		wg.Add(1)
+		go func(idx int, source *Source) {
+			defer wg.Done()
+			syringeProjects, err := source.Client.ListProjects()
+			if err != nil {
+				log.Errorf("Failed to list projects from %v: %v\n", source.Name, err)
+				return
+			}
+			results[idx] = syringeProjects
+		}(idx, source)
 	}
-	s.Projects = syringeProjects
-	s.ProjectsMap = make(map[int64]*structs.SyringeProject, len(*syringeProjects))
-	for _, project := range *syringeProjects {
-		s.ProjectsMap[project.Id] = project
+	wg.Wait()
+
+	mergedProjects := make([]*structs.SyringeProject, 0)
+	s.ProjectsMap = make(map[string]*structs.SyringeProject, 0)
+	s.Skipped = nil
+	for idx, source := range s.Sources {
+		if results[idx] != nil {
+			for _, project := range *results[idx] {
+				// the same repo reachable from two sources is only scanned once
+				if _, ok := s.ProjectsMap[project.Id]; ok {
+					log.Debugf("%v already listed by another source, skipping from %v\n", project.Name, source.Name)
+					continue
+				}
+				project.Source = source.Name
+				s.ProjectsMap[project.Id] = project
+				mergedProjects = append(mergedProjects, project)
+			}
+		}
+		if reporter, ok := source.Client.(SkipReporter); ok {
+			for _, skipped := range reporter.SkippedProjects() {
+				skipped.Source = source.Name
+				s.Skipped = append(s.Skipped, skipped)
+			}
+		}
 	}
+	s.Projects = &mergedProjects
 
 	return nil
 }
 
+// RateLimitStats returns the throttling of each source whose VCS held back or throttled its
+// requests so far. Sources sharing a limiter, like two sources of one Azure DevOps organization,
+// are reported together.
+func (s *Syringe) RateLimitStats() []*structs.RateLimitStats {
+	var throttled []*structs.RateLimitStats
+	reported := make(map[*utils.AdaptiveRateLimitTransport]*structs.RateLimitStats, 0)
+	for _, source := range s.Sources {
+		reporter, ok := source.Client.(RateLimitReporter)
+		if !ok || reporter.RateLimiter() == nil {
+			continue
+		}
+		limiter := reporter.RateLimiter()
+		if stats, ok := reported[limiter]; ok {
+			if stats != nil {
+				stats.Source += ", " + source.Name
+			}
+			continue
+		}
+		stats := limiter.Stats()
+		if stats.Throttled == 0 && stats.Waits == 0 {
+			reported[limiter] = nil
+			continue
+		}
+		stats.Source = source.Name
+		reported[limiter] = &stats
+		throttled = append(throttled, &stats)
+	}
+	return throttled
+}
+
+// clientFor returns the client of the source that listed project
+func (s *Syringe) clientFor(project *structs.SyringeProject) (Client, error) {
+	for _, source := range s.Sources {
+		if source.Name == project.Source {
+			return source.Client, nil
+		}
+	}
+	if len(s.Sources) == 1 && project.Source == "" {
+		return s.Sources[0].Client, nil
+	}
+	return nil, fmt.Errorf("no source named %q for project %v", project.Source, project.Id)
+}
+
 // Returns a pointer to project with the lockfiles in it
-func (s *Syringe) GetLockfilesByProject(projectId int64) (*structs.SyringeProject, error) {
+func (s *Syringe) GetLockfilesByProject(projectId string) (*structs.SyringeProject, error) {
 
 	s.ProjectsMapMutex.RLock()
 	theProject, ok := s.ProjectsMap[projectId]
@@ -110,13 +240,38 @@ func (s *Syringe) GetLockfilesByProject(projectId int64) (*structs.SyringeProjec
 		theProject = &structs.SyringeProject{}
 	}
 
-	lockfiles, err := s.Client.GetLockfilesByProject(theProject.Id, theProject.Branch) // TODO: i think i want s.projects to be a map indexed by projectid
+	head, unchanged := s.checkHead(theProject)
+	if unchanged {
+		log.Debugf("Skipping %v, %v is still at %v\n", theProject.Name, theProject.Branch, head)
+		theProject.Head = head
+		theProject.Unchanged = true
+		s.ProjectsMapMutex.Lock()
+		s.ProjectsMap[projectId] = theProject
+		s.ProjectsMapMutex.Unlock()
+		return theProject, nil
+	}
+
+	files, err := s.discoverFiles(theProject, theProject.Branch, utils.IsLockfileOrCiFile)
 	if err != nil {
 		// log.Warnf("Failed to get lockfiles: %v\n", err)
 		return nil, err
 	}
-	if lockfiles != nil {
+	var lockfiles, ciFiles []*structs.VcsFile
+	for _, file := range files {
+		if utils.IsLockfile(file.Path) {
+			lockfiles = append(lockfiles, file)
+		} else {
+			ciFiles = append(ciFiles, file)
+		}
+	}
+	lockfiles = append(lockfiles, s.discoverRefLockfiles(theProject)...)
+
+	// Only recorded once the files were read, so a failed scan isn't skipped next time
+	theProject.Head = head
+	if lockfiles != nil || ciFiles != nil {
 		theProject.Lockfiles = lockfiles
+		theProject.CiFiles = ciFiles
+		theProject.PhylumInCi = utils.CiRunsPhylum(ciFiles)
 		theProject.Hydrated = true
 	}
 
@@ -127,6 +282,119 @@ func (s *Syringe) GetLockfilesByProject(projectId int64) (*structs.SyringeProjec
 	return theProject, nil
 }
 
+// discoverFiles finds the files on a project's ref whose paths satisfy match, through the VCS API,
+// with a shallow git clone when clone discovery is enabled and the client can provide a clone URL,
+// or from one archive download when archive discovery is enabled and the client can provide one.
+// Search discovery looks the default branch up in the source's code search results, falling back
+// to the API for other refs, for projects without hits and for sources whose search failed.
+// Clients that can't match arbitrary files only return lockfiles.
+func (s *Syringe) discoverFiles(project *structs.SyringeProject, ref string, match func(string) bool) ([]*structs.VcsFile, error) {
+	client, err := s.clientFor(project)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Discovery == "search" && ref == project.Branch {
+		if searcher, ok := client.(Searcher); ok {
+			paths, err := s.searchPaths(project, searcher)
+			if err == nil {
+				var matched []string
+				for _, path := range paths {
+					if match(path) {
+						matched = append(matched, path)
+					}
+				}
+				if len(matched) > 0 {
+					return searcher.GetFiles(project.Id, ref, matched)
+				}
+				// Code search doesn't index large files, which many lockfiles are
+				log.Debugf("Code search found no files in %v, listing its tree instead\n", project.Name)
+			}
+		}
+	}
+
+	cloner, canClone := client.(Client2.Cloner)
+	archiver, canArchive := client.(Client2.Archiver)
+	fileGetter, canGetFiles := client.(FileGetter)
+	switch {
+	case s.Discovery == "clone" && canClone:
+		target, err := cloner.GetCloneTarget(project.Id)
+		if err != nil {
+			return nil, err
+		}
+		// git clone --branch takes tag names too
+		refName, _ := utils.SplitRef(ref)
+		return Client2.CloneFiles(target, refName, match)
+	case s.Discovery == "archive" && canArchive:
+		// Empty repos have no branch to archive
+		if ref == "" {
+			return nil, nil
+		}
+		archive, err := archiver.DownloadArchive(project.Id, ref)
+		if err != nil {
+			return nil, err
+		}
+		return Client2.ArchiveFiles(archive, match)
+	case canGetFiles:
+		if s.Discovery != "api" {
+			log.Debugf("Client does not support %v discovery, using API for %v\n", s.Discovery, project.Name)
+		}
+		return fileGetter.GetFilesByProject(project.Id, ref, match)
+	default:
+		return client.GetLockfilesByProject(project.Id, ref)
+	}
+}
+
+// discoverRefLockfiles finds the lockfiles on every branch and tag matching the configured
+// patterns other than the default branch. Each lockfile's Ref records where it was read from.
+// A ref that fails is logged and left out.
+func (s *Syringe) discoverRefLockfiles(project *structs.SyringeProject) []*structs.VcsFile {
+	var retLockfiles []*structs.VcsFile
+
+	if len(s.Refs.Branches) == 0 && len(s.Refs.Tags) == 0 {
+		return nil
+	}
+	client, err := s.clientFor(project)
+	if err != nil {
+		return nil
+	}
+	refLister, ok := client.(RefLister)
+	if !ok {
+		log.Debugf("Client can't list refs, only scanning the default branch of %v\n", project.Name)
+		return nil
+	}
+
+	branches, tags, err := refLister.ListRefs(project.Id)
+	if err != nil {
+		log.Warnf("Failed to list refs for %v: %v\n", project.Name, err)
+		return nil
+	}
+
+	defaultBranch, _ := utils.SplitRef(project.Branch)
+	var refs []string
+	for _, branch := range utils.MatchRefs(branches, s.Refs.Branches) {
+		if branch != defaultBranch {
+			refs = append(refs, branch)
+		}
+	}
+	for _, tag := range utils.MatchRefs(tags, s.Refs.Tags) {
+		refs = append(refs, utils.TagRef(tag))
+	}
+
+	for _, ref := range refs {
+		lockfiles, err := s.discoverFiles(project, ref, utils.IsLockfile)
+		if err != nil {
+			log.Warnf("Failed to get lockfiles for %v at %v: %v\n", project.Name, ref, err)
+			continue
+		}
+		for _, lockfile := range lockfiles {
+			lockfile.Ref = ref
+			retLockfiles = append(retLockfiles, lockfile)
+		}
+	}
+	return retLockfiles
+}
+
 func (s *Syringe) GetAllLockfilesSerial() error {
 	lockfilesBar := progressbar.NewOptions(len(*s.Projects), progressbar.OptionSetDescription("Getting Lockfiles"))
 
@@ -149,17 +417,25 @@ func (s *Syringe) GetAllLockfiles() error {
 	// TODO: Remove this
 	sem := semaphore.NewWeighted(50)
 
-	for kID, _ := range s.ProjectsMap {
+	// GetLockfilesByProject writes to ProjectsMap, so it can't be ranged over while they run
+	s.ProjectsMapMutex.RLock()
+	projectIds := make([]string, 0, len(s.ProjectsMap))
+	for kID := range s.ProjectsMap {
+		projectIds = append(projectIds, kID)
+	}
+	s.ProjectsMapMutex.RUnlock()
+
+	for _, kID := range projectIds {
 		wg.Add(1)
 		sem.Acquire(context.Background(), 1)
-		go func(id int64) {
+		go func(id string) {
 			defer wg.Done()
 			defer sem.Release(1)
-			log.Debugf("Getting lockfiles for %v\n", kID)
+			log.Debugf("Getting lockfiles for %v\n", id)
 			_, err := s.GetLockfilesByProject(id)
 			lockfilesBar.Add(1)
 			if err != nil {
-				log.Warnf("failed to GetLockFilesByProject() ID=%v: %v\n", kID, err)
+				log.Warnf("failed to GetLockFilesByProject() ID=%v: %v\n", id, err)
 			}
 		}(kID)
 	}
@@ -245,7 +521,7 @@ func (s *Syringe) IntegratePhylumProjectList(phylumProjectMap *map[string]struct
 	for _, syringeProject := range s.ProjectsMap {
 		for _, lockfile := range syringeProject.Lockfiles {
 			lockfileCount++
-			phylumProjectName := utils.GeneratePhylumProjectName(syringeProject.Name, lockfile.Path, syringeProject.Id)
+			phylumProjectName := s.phylumProjectName(syringeProject, lockfile)
 			phylumProject, ok := (*phylumProjectMap)[phylumProjectName]
 			if ok {
 				lockfile.PhylumProject = &phylumProject
@@ -258,6 +534,23 @@ func (s *Syringe) IntegratePhylumProjectList(phylumProjectMap *map[string]struct
 	return phylumProjectsToCreate
 }
 
+// phylumProjectName names the Phylum project for a lockfile. With several sources the project name
+// is prefixed by its source, so the same repo name on two VCSes doesn't share a Phylum project.
+// A single source keeps the plain names earlier versions created. Refs other than the default
+// branch are suffixed with @ref, e.g. SYR-acme/api@release/1.0__package-lock.json.
+func (s *Syringe) phylumProjectName(project *structs.SyringeProject, lockfile *structs.VcsFile) string {
+	projectName := project.Name
+	if len(s.Sources) > 1 && project.Source != "" {
+		projectName = fmt.Sprintf("%v/%v", project.Source, project.Name)
+	}
+	// Lockfiles from other refs get their own project unless refs are told apart by labels
+	if lockfile.Ref != "" && !s.Refs.Label {
+		refName, _ := utils.SplitRef(lockfile.Ref)
+		projectName = fmt.Sprintf("%v@%v", projectName, refName)
+	}
+	return utils.GeneratePhylumProjectName(projectName, lockfile.Path, project.Id)
+}
+
 func (s *Syringe) PhylumCreateProjectAPI(projectName string, projects chan<- *structs.PhylumProject) error {
 	var projectResponse *phylum.ProjectSummaryResponse
 	opts := &phylum.ProjectOpts{}
@@ -365,6 +658,16 @@ func (s *Syringe) PhylumRunAnalyze(phylumProjectFile structs.PhylumProject, lock
 		phylumProjectFile = *tempProject
 	}
 
+	var label string
+	if lockfile.Ref != "" && s.Refs.Label {
+		label, _ = utils.SplitRef(lockfile.Ref)
+	}
+	return s.phylumAnalyze(phylumProjectFile, lockfile, phylumProjectName, label)
+}
+
+// phylumAnalyze runs phylum analyze on the lockfile against an existing Phylum project, labelling
+// the analysis when label is set
+func (s *Syringe) phylumAnalyze(phylumProjectFile structs.PhylumProject, lockfile *structs.VcsFile, phylumProjectName string, label string) error {
 	// create temp directory to write the lockfile content for analyze
 	log.Debugf("Analyzing %v\n", phylumProjectFile.Name)
 	tempDir, err := ioutil.TempDir("", "syringe-analyze")
@@ -395,6 +698,9 @@ func (s *Syringe) PhylumRunAnalyze(phylumProjectFile structs.PhylumProject, lock
 	if s.PhylumGroupName != "" {
 		AnalyzeCmdArgs = append(AnalyzeCmdArgs, "-g", s.PhylumGroupName, "--project", phylumProjectName)
 	}
+	if label != "" {
+		AnalyzeCmdArgs = append(AnalyzeCmdArgs, "--label", label)
+	}
 	projectAnalyzeCmd := exec.Command("phylum", AnalyzeCmdArgs...)
 	projectAnalyzeCmd.Stderr = &stdErrBytes
 	projectAnalyzeCmd.Dir = tempDir
@@ -2,9 +2,19 @@ package syringePackage
 
 import (
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/google/uuid"
+	Client2 "github.com/peterjmorgan/Syringe/internal/client"
 	"github.com/peterjmorgan/Syringe/internal/structs"
 	"github.com/peterjmorgan/Syringe/internal/utils"
 
@@ -87,7 +97,7 @@ func TestSyringe_ListProjects(t *testing.T) {
 
 func TestSyringe_GetLockfilesByProject(t *testing.T) {
 	type args struct {
-		projectId int64
+		projectName string
 	}
 	tests := []struct {
 		name    string
@@ -97,10 +107,10 @@ func TestSyringe_GetLockfilesByProject(t *testing.T) {
 		wantLen int
 		wantErr bool
 	}{
-		{"gitlab", args{38265422}, gitlabOpts, &structs.SyringeProject{}, 4, false},                     // gitlab: testProject98 - 4
-		{"github", args{325083799}, &structs.SyringeOptions{}, &structs.SyringeProject{}, 1, false},     // github: phylum-ui - 1
-		{"azure", args{1249448610}, &structs.SyringeOptions{}, &structs.SyringeProject{}, 4, false},     // azure: test-project-1 - 4
-		{"bitbucket", args{3407435279}, &structs.SyringeOptions{}, &structs.SyringeProject{}, 2, false}, // bitbucket cloud project: test-repo-1 - 2
+		{"gitlab", args{"testProject98"}, gitlabOpts, &structs.SyringeProject{}, 4, false},
+		{"github", args{"phylum-ui"}, &structs.SyringeOptions{}, &structs.SyringeProject{}, 1, false},
+		{"azure", args{"test-project-1"}, &structs.SyringeOptions{}, &structs.SyringeProject{}, 4, false},
+		{"bitbucket", args{"test-repo-1"}, &structs.SyringeOptions{}, &structs.SyringeProject{}, 2, false}, // bitbucket cloud
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -113,7 +123,14 @@ func TestSyringe_GetLockfilesByProject(t *testing.T) {
 			if err = s.ListProjects(); err != nil {
 				fmt.Printf("failed to list projects: %v\n", err)
 			}
-			got, err := s.GetLockfilesByProject(tt.args.projectId)
+			// Names may be qualified by owner or team project
+			var projectId string
+			for _, p := range *s.Projects {
+				if p.Name == tt.args.projectName || strings.HasSuffix(p.Name, "/"+tt.args.projectName) {
+					projectId = p.Id
+				}
+			}
+			got, err := s.GetLockfilesByProject(projectId)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetLockfilesByProject() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -491,3 +508,411 @@ func TestSyringe_PhylumGetProjects(t *testing.T) {
 //		})
 //	}
 //}
+
+// Exercises ListProjects -> GetAllLockfiles -> IntegratePhylumProjectList without any VCS or Phylum API access
+func TestSyringe_LocalClient(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"one/.git/HEAD":          "ref: refs/heads/main\n",
+		"one/package-lock.json":  "{}",
+		"two/requirements.txt":   "requests\n",
+		"two/nested/poetry.lock": "",
+	} {
+		fullPath := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("failed to create %v: %v", path, err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %v: %v", path, err)
+		}
+	}
+
+	client, err := NewClient("local", &structs.ConfigThing{
+		VcsType:    "local",
+		Associated: map[string]string{"localPath": root},
+	}, &structs.SyringeOptions{})
+	if err != nil {
+		t.Fatalf("failed to create local client: %v", err)
+	}
+	s := &Syringe{
+		Sources:     []*Source{{Name: "local", Client: client}},
+		ProjectsMap: make(map[string]*structs.SyringeProject, 0),
+	}
+
+	tests := []struct {
+		name            string
+		wantProjects    int
+		wantLockfiles   int
+		wantNewProjects int
+	}{
+		{"local", 2, 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.ListProjects(); err != nil {
+				t.Errorf("ListProjects() error = %v", err)
+				return
+			}
+			if len(*s.Projects) != tt.wantProjects {
+				t.Errorf("ListProjects() len(got) = %v, want %v", len(*s.Projects), tt.wantProjects)
+			}
+
+			if err := s.GetAllLockfiles(); err != nil {
+				t.Errorf("GetAllLockfiles() error = %v", err)
+				return
+			}
+			var gotLen int = 0
+			for _, proj := range *s.Projects {
+				gotLen += len(proj.Lockfiles)
+			}
+			if gotLen != tt.wantLockfiles {
+				t.Errorf("GetAllLockfiles() len(got) = %v, want %v", gotLen, tt.wantLockfiles)
+			}
+
+			newProjects := s.IntegratePhylumProjectList(&map[string]structs.PhylumProject{})
+			if len(newProjects) != tt.wantNewProjects {
+				t.Errorf("IntegratePhylumProjectList() len(got) = %v, want %v", len(newProjects), tt.wantNewProjects)
+			}
+		})
+	}
+}
+
+func TestSyringe_ProjectKeyCollision(t *testing.T) {
+	// Both UUIDs share their first 32 bits, so uuid.ID() is the same for each
+	uuidOne := "11111111-aaaa-4aaa-8aaa-aaaaaaaaaaaa"
+	uuidTwo := "11111111-bbbb-4bbb-8bbb-bbbbbbbbbbbb"
+	if uuid.MustParse(uuidOne).ID() != uuid.MustParse(uuidTwo).ID() {
+		t.Fatalf("test UUIDs do not collide")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repositories/acme":
+			fmt.Fprintf(w, `{"values": [
+				{"uuid": "{%v}", "name": "one", "slug": "one", "full_name": "acme/one", "mainbranch": {"name": "main"}},
+				{"uuid": "{%v}", "name": "two", "slug": "two", "full_name": "acme/two", "mainbranch": {"name": "main"}}
+			]}`, uuidOne, uuidTwo)
+		case strings.HasSuffix(r.URL.Path, "/src/main/package-lock.json"):
+			// Each repo's lockfile holds its own name
+			fmt.Fprint(w, strings.Split(r.URL.Path, "/")[3])
+		case strings.Contains(r.URL.Path, "/src/main"):
+			fmt.Fprint(w, `{"values": [{"path": "package-lock.json", "type": "commit_file"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient("bitbucket_cloud", &structs.ConfigThing{
+		VcsType:    "bitbucket_cloud",
+		Associated: map[string]string{"bbOwner": "acme", "bbAccessToken": "testtoken"},
+	}, &structs.SyringeOptions{})
+	if err != nil {
+		t.Fatalf("failed to create bitbucket client: %v", err)
+	}
+	baseUrl, _ := url.Parse(server.URL)
+	client.(*Client2.BitbucketCloudClient).Client.SetApiBaseURL(*baseUrl)
+
+	s := &Syringe{
+		Sources:     []*Source{{Name: "bitbucket_cloud", Client: client}},
+		ProjectsMap: make(map[string]*structs.SyringeProject, 0),
+	}
+	if err := s.ListProjects(); err != nil {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+	if len(s.ProjectsMap) != 2 {
+		t.Errorf("ListProjects() len(ProjectsMap) = %v, want 2", len(s.ProjectsMap))
+	}
+
+	if err := s.GetAllLockfiles(); err != nil {
+		t.Fatalf("GetAllLockfiles() error = %v", err)
+	}
+	for _, project := range s.ProjectsMap {
+		if len(project.Lockfiles) != 1 || string(project.Lockfiles[0].Content) != project.Name {
+			t.Errorf("GetAllLockfiles() lockfiles for %v got = %v", project.Name, project.Lockfiles)
+		}
+	}
+}
+
+func TestNewSources(t *testing.T) {
+	localPath := map[string]string{"localPath": t.TempDir()}
+	tests := []struct {
+		name      string
+		config    *structs.ConfigThing
+		wantNames []string
+		wantErr   bool
+	}{
+		{"single", &structs.ConfigThing{VcsType: "Local", Associated: localPath}, []string{"local"}, false},
+		{"multiple", &structs.ConfigThing{Sources: []structs.VcsSource{
+			{Name: "laptop", VcsType: "local", Associated: localPath},
+			{Name: "server", VcsType: "local", Associated: localPath},
+		}}, []string{"laptop", "server"}, false},
+		{"duplicate", &structs.ConfigThing{Sources: []structs.VcsSource{
+			{Name: "laptop", VcsType: "local", Associated: localPath},
+			{Name: "laptop", VcsType: "local", Associated: localPath},
+		}}, nil, true},
+		{"unnamed", &structs.ConfigThing{Sources: []structs.VcsSource{{VcsType: "local", Associated: localPath}}}, nil, true},
+		{"unknown", &structs.ConfigThing{Sources: []structs.VcsSource{{Name: "svn", VcsType: "subversion"}}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewSources(tt.config, &structs.SyringeOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSources() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			var gotNames []string
+			for _, source := range got {
+				gotNames = append(gotNames, source.Name)
+			}
+			if !reflect.DeepEqual(gotNames, tt.wantNames) {
+				t.Errorf("NewSources() names = %v, want %v", gotNames, tt.wantNames)
+			}
+		})
+	}
+}
+
+// Two sources holding a repo of the same name are both scanned, tagged with their source
+func TestSyringe_MultipleSources(t *testing.T) {
+	config := &structs.ConfigThing{}
+	for _, name := range []string{"laptop", "server"} {
+		root := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(root, "api"), 0755); err != nil {
+			t.Fatalf("failed to create repo: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "api", "package-lock.json"), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write lockfile: %v", err)
+		}
+		config.Sources = append(config.Sources, structs.VcsSource{
+			Name:       name,
+			VcsType:    "local",
+			Associated: map[string]string{"localPath": root},
+		})
+	}
+
+	sources, err := NewSources(config, &structs.SyringeOptions{})
+	if err != nil {
+		t.Fatalf("NewSources() error = %v", err)
+	}
+	s := &Syringe{
+		Sources:     sources,
+		ProjectsMap: make(map[string]*structs.SyringeProject, 0),
+	}
+	if err := s.ListProjects(); err != nil {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+	if len(*s.Projects) != 2 {
+		t.Fatalf("ListProjects() len(got) = %v, want 2", len(*s.Projects))
+	}
+	if err := s.GetAllLockfiles(); err != nil {
+		t.Fatalf("GetAllLockfiles() error = %v", err)
+	}
+	for _, project := range *s.Projects {
+		if len(project.Lockfiles) != 1 || string(project.Lockfiles[0].Content) != project.Source {
+			t.Errorf("GetAllLockfiles() lockfiles for %v from %v got = %v", project.Name, project.Source, project.Lockfiles)
+		}
+	}
+
+	newProjects := s.IntegratePhylumProjectList(&map[string]structs.PhylumProject{})
+	sort.Strings(newProjects)
+	want := []string{"SYR-laptop/api__package-lock.json", "SYR-server/api__package-lock.json"}
+	if !reflect.DeepEqual(newProjects, want) {
+		t.Errorf("IntegratePhylumProjectList() got = %v, want %v", newProjects, want)
+	}
+}
+
+func TestSyringe_CiFiles(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"scanned/package-lock.json":           "{}",
+		"scanned/.github/workflows/ci.yml":    "steps:\n  - uses: phylum-dev/phylum-analyze-pr-action@v2\n",
+		"scanned/.github/workflows/lint.yaml": "steps:\n  - run: npm run lint\n",
+		"unscanned/requirements.txt":          "requests\n",
+		"unscanned/.gitlab-ci.yml":            "test:\n  script:\n    - pytest\n",
+	} {
+		fullPath := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("failed to create %v: %v", path, err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %v: %v", path, err)
+		}
+	}
+
+	client, err := NewClient("local", &structs.ConfigThing{
+		VcsType:    "local",
+		Associated: map[string]string{"localPath": root},
+	}, &structs.SyringeOptions{})
+	if err != nil {
+		t.Fatalf("failed to create local client: %v", err)
+	}
+	s := &Syringe{
+		Sources:     []*Source{{Name: "local", Client: client}},
+		ProjectsMap: make(map[string]*structs.SyringeProject, 0),
+	}
+	if err := s.ListProjects(); err != nil {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+	if err := s.GetAllLockfiles(); err != nil {
+		t.Fatalf("GetAllLockfiles() error = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		wantLockfiles  int
+		wantCiFiles    int
+		wantPhylumInCi bool
+	}{
+		{"scanned", 1, 2, true},
+		{"unscanned", 1, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var project *structs.SyringeProject
+			for _, p := range *s.Projects {
+				if p.Name == tt.name {
+					project = p
+				}
+			}
+			if project == nil {
+				t.Fatalf("project %v not listed", tt.name)
+			}
+			if len(project.Lockfiles) != tt.wantLockfiles {
+				t.Errorf("len(Lockfiles) = %v, want %v", len(project.Lockfiles), tt.wantLockfiles)
+			}
+			if len(project.CiFiles) != tt.wantCiFiles {
+				t.Errorf("len(CiFiles) = %v, want %v", len(project.CiFiles), tt.wantCiFiles)
+			}
+			if project.PhylumInCi != tt.wantPhylumInCi {
+				t.Errorf("PhylumInCi = %v, want %v", project.PhylumInCi, tt.wantPhylumInCi)
+			}
+		})
+	}
+}
+
+// fakeRefClient serves one project whose files differ per branch and tag
+type fakeRefClient struct {
+	files map[string][]string // ref -> paths
+}
+
+func (f *fakeRefClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	return &[]*structs.SyringeProject{{Id: structs.NewProjectKey("github", "github.com", "acme", "1"), Name: "api", Branch: "main"}}, nil
+}
+
+func (f *fakeRefClient) GetLockfilesByProject(projectId string, branch string) ([]*structs.VcsFile, error) {
+	return f.GetFilesByProject(projectId, branch, utils.IsLockfile)
+}
+
+func (f *fakeRefClient) GetFilesByProject(projectId string, branch string, match func(string) bool) ([]*structs.VcsFile, error) {
+	var files []*structs.VcsFile
+	for _, path := range f.files[branch] {
+		if match(path) {
+			files = append(files, &structs.VcsFile{Name: filepath.Base(path), Path: path, Content: []byte(branch)})
+		}
+	}
+	return files, nil
+}
+
+func (f *fakeRefClient) ListRefs(projectId string) ([]string, []string, error) {
+	return []string{"main", "feature/x", "release/1.0", "release/2.0"}, []string{"nightly", "v1.0.0"}, nil
+}
+
+func TestSyringe_Refs(t *testing.T) {
+	client := &fakeRefClient{files: map[string][]string{
+		"main":              {"package-lock.json", ".github/workflows/ci.yml"},
+		"feature/x":         {"package-lock.json"},
+		"release/1.0":       {"package-lock.json", ".github/workflows/ci.yml"},
+		"release/2.0":       {"package-lock.json", "poetry.lock"},
+		"refs/tags/nightly": {"package-lock.json"},
+		"refs/tags/v1.0.0":  {"package-lock.json"},
+	}}
+
+	tests := []struct {
+		name      string
+		refs      structs.RefOptions
+		wantNames []string
+	}{
+		{"default branch only", structs.RefOptions{}, []string{"SYR-api__package-lock.json"}},
+		{"project per ref", structs.RefOptions{Branches: []string{"main", "release/*"}, Tags: []string{"v*"}}, []string{
+			"SYR-api__package-lock.json",
+			"SYR-api@release/1.0__package-lock.json",
+			"SYR-api@release/2.0__package-lock.json",
+			"SYR-api@release/2.0__poetry.lock",
+			"SYR-api@v1.0.0__package-lock.json",
+		}},
+		{"labels", structs.RefOptions{Tags: []string{"v*"}, Label: true}, []string{
+			"SYR-api__package-lock.json",
+			"SYR-api__package-lock.json",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Syringe{
+				Sources:     []*Source{{Name: "github", Client: client}},
+				ProjectsMap: make(map[string]*structs.SyringeProject, 0),
+				Refs:        tt.refs,
+			}
+			if err := s.ListProjects(); err != nil {
+				t.Fatalf("ListProjects() error = %v", err)
+			}
+			project, err := s.GetLockfilesByProject((*s.Projects)[0].Id)
+			if err != nil {
+				t.Fatalf("GetLockfilesByProject() error = %v", err)
+			}
+
+			var gotNames []string
+			for _, lockfile := range project.Lockfiles {
+				gotNames = append(gotNames, s.phylumProjectName(project, lockfile))
+				if wantContent := lockfile.Ref; wantContent != "" && string(lockfile.Content) != wantContent {
+					t.Errorf("%v read from %v, want %v", lockfile.Path, string(lockfile.Content), wantContent)
+				}
+			}
+			if !reflect.DeepEqual(gotNames, tt.wantNames) {
+				t.Errorf("phylumProjectName() got = %v, want %v", gotNames, tt.wantNames)
+			}
+			// CI files only come from the default branch
+			if len(project.CiFiles) != 1 || string(project.CiFiles[0].Content) != "main" {
+				t.Errorf("CiFiles = %v, want the default branch's", project.CiFiles)
+			}
+		})
+	}
+}
+
+// fakeRateLimitedClient reports a limiter it may share with other sources
+type fakeRateLimitedClient struct {
+	fakeClient
+	limiter *utils.AdaptiveRateLimitTransport
+}
+
+func (f *fakeRateLimitedClient) RateLimiter() *utils.AdaptiveRateLimitTransport {
+	return f.limiter
+}
+
+func TestSyringe_RateLimitStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	shared := utils.NewAdaptiveRateLimitTransport(nil)
+	idle := utils.NewAdaptiveRateLimitTransport(nil)
+	// A body that can't be rewound isn't retried, so the 429 is counted without waiting
+	req, _ := http.NewRequest(http.MethodPost, server.URL, io.MultiReader(strings.NewReader("{}")))
+	resp, err := (&http.Client{Transport: shared}).Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	s := &Syringe{Sources: []*Source{
+		{Name: "azure-a", Client: &fakeRateLimitedClient{limiter: shared}},
+		{Name: "gitlab", Client: &fakeRateLimitedClient{limiter: idle}},
+		{Name: "github", Client: &fakeClient{}},
+		{Name: "azure-b", Client: &fakeRateLimitedClient{limiter: shared}},
+	}}
+	got := s.RateLimitStats()
+	want := []*structs.RateLimitStats{{Source: "azure-a, azure-b", Requests: 1, Throttled: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RateLimitStats() got = %+v, want %+v", got, want)
+	}
+}
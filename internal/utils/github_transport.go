@@ -11,37 +11,8 @@ import (
 	"github.com/google/go-github/github"
 )
 
-const (
-	ctxEtag = ctxEtagType("etag")
-	ctxId   = ctxIdType("id")
-)
-
-// ctxIdType is used to avoid collisions between packages using context
-type ctxIdType string
-
-// ctxEtagType is used to avoid collisions between packages using context
-type ctxEtagType string
-
-// etagTransport allows saving API quota by passing previously stored Etag
-// available via context to request headers
-type etagTransport struct {
-	transport http.RoundTripper
-}
-
-func (ett *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	ctx := req.Context()
-
-	etag := ctx.Value(ctxEtag)
-	if v, ok := etag.(string); ok && v != "" {
-		req.Header.Set("If-None-Match", v)
-	}
-
-	return ett.transport.RoundTrip(req)
-}
-
-func NewEtagTransport(rt http.RoundTripper) *etagTransport {
-	return &etagTransport{transport: rt}
-}
+// defaultAbuseRetryAfter is how long to wait when GitHub's abuse detection doesn't say
+const defaultAbuseRetryAfter = 60 * time.Second
 
 // RateLimitTransport implements GitHub's best practices
 // for avoiding rate limits
@@ -81,6 +52,7 @@ func (rlt *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, err
 	// See https://github.com/google/go-github/pull/986
 	r1, r2, err := drainBody(resp.Body)
 	if err != nil {
+		rlt.unlock(req)
 		return nil, err
 	}
 	resp.Body = r1
@@ -91,6 +63,10 @@ func (rlt *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, err
 	if arlErr, ok := ghErr.(*github.AbuseRateLimitError); ok {
 		rlt.nextRequestDelay = 0
 		retryAfter := arlErr.GetRetryAfter()
+		if retryAfter <= 0 {
+			// No Retry-After header, GitHub recommends waiting at least a minute
+			retryAfter = defaultAbuseRetryAfter
+		}
 		log.Printf("[DEBUG] Abuse detection mechanism triggered, sleeping for %s before retrying",
 			retryAfter)
 		time.Sleep(retryAfter)
@@ -98,9 +74,16 @@ func (rlt *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, err
 		return rlt.RoundTrip(req)
 	}
 
-	if rlErr, ok := ghErr.(*github.RateLimitError); ok {
+	// GitHub Enterprise Server may have rate limiting disabled, or a proxy may strip the headers.
+	// Without a reset time there is nothing to wait for, so hand the error back to the caller
+	// instead of retrying in a tight loop.
+	if rlErr, ok := ghErr.(*github.RateLimitError); ok && !rlErr.Rate.Reset.Time.IsZero() {
 		rlt.nextRequestDelay = 0
 		retryAfter := time.Until(rlErr.Rate.Reset.Time)
+		if retryAfter < time.Second {
+			// Reset already passed, possibly due to clock skew with the server
+			retryAfter = time.Second
+		}
 		log.Printf("[DEBUG] Rate limit %d reached, sleeping for %s (until %s) before retrying",
 			rlErr.Rate.Limit, retryAfter, time.Now().Add(retryAfter))
 		time.Sleep(retryAfter)
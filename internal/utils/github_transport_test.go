@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTransport_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantCalls  int
+	}{
+		{"no rate limit headers", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{}`)
+		}, http.StatusOK, 1},
+		{"rate limited without reset", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "API rate limit exceeded for 10.0.0.1."}`)
+		}, http.StatusForbidden, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				tt.handler(w, r)
+			}))
+			defer server.Close()
+
+			client := &http.Client{
+				Transport: NewRateLimitTransport(http.DefaultTransport, WithReadDelay(0)),
+				Timeout:   5 * time.Second,
+			}
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Errorf("RoundTrip() error = %v", err)
+				return
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("RoundTrip() status got = %v, want %v", resp.StatusCode, tt.wantStatus)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("RoundTrip() calls got = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxCachedBody is the largest response CacheTransport stores; bigger ones, like repo archives,
+// are passed through uncached
+const maxCachedBody = 10 << 20
+
+// cacheAuthHeaders are the request headers that identify who is asking: Authorization for
+// GitHub, Bitbucket, Gitea and GitLab OAuth tokens (bearer or basic), PRIVATE-TOKEN and JOB-TOKEN
+// for GitLab
+var cacheAuthHeaders = []string{"Authorization", "Private-Token", "Job-Token"}
+
+// cacheEntry is a response stored on disk by CacheTransport
+type cacheEntry struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// CacheTransport stores GET responses that carry an ETag or Last-Modified header on disk, keyed by
+// URL, Accept header and auth identity. The identity is the one the client was configured with, e.g.
+// a GitHub App installation, since short-lived tokens like installation tokens would otherwise key
+// every run apart; without one, the auth headers themselves are used. Repeated requests are revalidated with If-None-Match /
+// If-Modified-Since, and a 304 is answered from the cache with the status and body of the stored
+// response. GitHub doesn't count 304s against the rate limit. Responses served from the cache have
+// the X-From-Cache header set.
+type CacheTransport struct {
+	transport http.RoundTripper
+	dir       string
+	identity  string
+}
+
+// NewCacheTransport returns a CacheTransport storing responses in dir, which is created if needed.
+// identity names the credentials requests are sent with and may be a secret; it's only stored
+// hashed. Empty keys entries by the auth headers of each request.
+func NewCacheTransport(rt http.RoundTripper, dir string, identity string) (*CacheTransport, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &CacheTransport{transport: rt, dir: dir, identity: identity}, nil
+}
+
+func (ct *CacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return ct.transport.RoundTrip(req)
+	}
+
+	filename := ct.entryPath(req)
+	entry := ct.load(filename)
+	if entry != nil {
+		etag := entry.Header.Get("Etag")
+		lastModified := entry.Header.Get("Last-Modified")
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		if etag != "" && req.Header.Get("If-None-Match") == "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" && req.Header.Get("If-Modified-Since") == "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := ct.transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		resp.Body.Close()
+		// The 304 carries fresh rate limit and validator headers
+		header := entry.Header.Clone()
+		for name, values := range resp.Header {
+			header[name] = values
+		}
+		entry.Header = header
+		ct.save(filename, entry)
+
+		cached := *resp
+		cached.StatusCode = entry.StatusCode
+		cached.Status = fmt.Sprintf("%d %s", entry.StatusCode, http.StatusText(entry.StatusCode))
+		cached.Header = header.Clone()
+		cached.Header.Set("X-From-Cache", "1")
+		cached.Body = io.NopCloser(bytes.NewReader(entry.Body))
+		cached.ContentLength = int64(len(entry.Body))
+		return &cached, nil
+	}
+
+	if resp.StatusCode != http.StatusOK || !cacheable(resp) {
+		return resp, nil
+	}
+
+	// Read one byte past the limit to tell whether the body fits
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCachedBody+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > maxCachedBody {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	ct.save(filename, &cacheEntry{StatusCode: resp.StatusCode, Header: resp.Header.Clone(), Body: body})
+	return resp, nil
+}
+
+// cacheable reports whether the response can be revalidated and may be stored
+func cacheable(resp *http.Response) bool {
+	if strings.Contains(strings.ToLower(resp.Header.Get("Cache-Control")), "no-store") {
+		return false
+	}
+	return resp.Header.Get("Etag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// entryPath hashes the request's identity into a file name, so neither URLs nor tokens are
+// written to disk in the clear
+func (ct *CacheTransport) entryPath(req *http.Request) string {
+	hash := sha256.New()
+	io.WriteString(hash, req.URL.String())
+	io.WriteString(hash, "\x00"+req.Header.Get("Accept"))
+	for _, name := range cacheAuthHeaders {
+		value := req.Header.Get(name)
+		// With a configured identity only whether the header was sent matters, so unauthenticated
+		// requests still get entries of their own
+		if ct.identity != "" && value != "" {
+			value = "\x00identity:" + ct.identity
+		}
+		io.WriteString(hash, "\x00"+value)
+	}
+	return filepath.Join(ct.dir, hex.EncodeToString(hash.Sum(nil))+".json")
+}
+
+// load returns the stored entry, or nil when there is none or it can't be read
+func (ct *CacheTransport) load(filename string) *cacheEntry {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil
+	}
+	return entry
+}
+
+// save writes the entry through a temp file, so concurrent readers never see a partial entry.
+// Failing to cache isn't an error for the request.
+func (ct *CacheTransport) save(filename string, entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	tempFile, err := os.CreateTemp(ct.dir, "entry-*.tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return
+	}
+	if err := tempFile.Close(); err != nil {
+		return
+	}
+	os.Rename(tempFile.Name(), filename)
+}
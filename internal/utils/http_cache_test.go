@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCacheTransport_RoundTrip(t *testing.T) {
+	calls := 0
+	conditional := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/etag":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				conditional++
+				w.Header().Set("X-RateLimit-Remaining", "4999")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Etag", `"v1"`)
+			w.Header().Set("X-RateLimit-Remaining", "5000")
+			fmt.Fprint(w, `{"user": "`+r.Header.Get("Authorization")+`"}`)
+		case "/modified":
+			if r.Header.Get("If-Modified-Since") != "" {
+				conditional++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			fmt.Fprint(w, `modified`)
+		case "/no-store":
+			if r.Header.Get("If-None-Match") != "" {
+				conditional++
+			}
+			w.Header().Set("Etag", `"v1"`)
+			w.Header().Set("Cache-Control", "private, no-store")
+			fmt.Fprint(w, `secret`)
+		case "/large":
+			w.Header().Set("Etag", `"v1"`)
+			fmt.Fprint(w, strings.Repeat("a", maxCachedBody+1))
+		}
+	}))
+	defer server.Close()
+
+	transport, err := NewCacheTransport(http.DefaultTransport, t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewCacheTransport() error = %v", err)
+	}
+	client := &http.Client{Transport: transport}
+
+	get := func(path string, auth string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Get(%v) error = %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Get(%v) read error = %v", path, err)
+		}
+		return resp, string(body)
+	}
+
+	// An ETag is revalidated and a 304 served from the cache, with the fresh headers
+	get("/etag", "token alice")
+	resp, body := get("/etag", "token alice")
+	if resp.StatusCode != http.StatusOK || body != `{"user": "token alice"}` || resp.Header.Get("X-From-Cache") != "1" {
+		t.Errorf("revalidated: status = %v, body = %v, header = %v", resp.StatusCode, body, resp.Header)
+	}
+	if got := resp.Header.Get("X-RateLimit-Remaining"); got != "4999" {
+		t.Errorf("revalidated: X-RateLimit-Remaining = %v, want 4999", got)
+	}
+
+	// Another identity never sees alice's response
+	resp, body = get("/etag", "token bob")
+	if body != `{"user": "token bob"}` || resp.Header.Get("X-From-Cache") != "" {
+		t.Errorf("other auth: body = %v, header = %v", body, resp.Header)
+	}
+
+	get("/modified", "")
+	if _, body = get("/modified", ""); body != "modified" {
+		t.Errorf("last-modified: body = %v", body)
+	}
+
+	if conditional != 2 {
+		t.Errorf("conditional requests = %v, want 2", conditional)
+	}
+
+	// no-store and oversized responses are passed through without being stored
+	get("/no-store", "")
+	if _, body = get("/no-store", ""); body != "secret" || conditional != 2 {
+		t.Errorf("no-store: body = %v, conditional = %v", body, conditional)
+	}
+	get("/large", "")
+	if resp, body = get("/large", ""); len(body) != maxCachedBody+1 || resp.Header.Get("X-From-Cache") != "" {
+		t.Errorf("large: len(body) = %v, header = %v", len(body), resp.Header)
+	}
+
+	// Writes always reach the server
+	before := calls
+	resp, err = client.Post(server.URL+"/etag", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	resp.Body.Close()
+	if calls != before+1 || resp.Header.Get("X-From-Cache") != "" {
+		t.Errorf("post: calls = %v, header = %v", calls-before, resp.Header)
+	}
+}
+
+func TestCacheTransport_Identity(t *testing.T) {
+	conditional := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Etag", `"v1"`)
+		fmt.Fprint(w, `repos`)
+	}))
+	defer server.Close()
+
+	// A GitHub App installation's token changes between runs, but it stays the same identity
+	dir := t.TempDir()
+	for _, token := range []string{"token ghs_first", "token ghs_second"} {
+		transport, err := NewCacheTransport(http.DefaultTransport, dir, "github-app:1:2")
+		if err != nil {
+			t.Fatalf("NewCacheTransport() error = %v", err)
+		}
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Authorization", token)
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		resp.Body.Close()
+	}
+	if conditional != 1 {
+		t.Errorf("conditional requests = %v, want 1", conditional)
+	}
+
+	// Unauthenticated requests don't share the identity's entries
+	transport, _ := NewCacheTransport(http.DefaultTransport, dir, "github-app:1:2")
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if conditional != 1 || resp.Header.Get("X-From-Cache") != "" {
+		t.Errorf("unauthenticated: conditional = %v, header = %v", conditional, resp.Header)
+	}
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+)
+
+// userAgentTransport sets the User-Agent header on every request
+type userAgentTransport struct {
+	transport http.RoundTripper
+	userAgent string
+}
+
+func (uat *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", uat.userAgent)
+	return uat.transport.RoundTrip(req)
+}
+
+// NewHttpTransport builds the transport shared by every client: the proxy (or the HTTP(S)_PROXY
+// variables when none is set), the system roots plus an optional CA bundle, opt-in insecure TLS
+// and the user agent
+func NewHttpTransport(opts structs.HttpOptions) (http.RoundTripper, error) {
+	tlsConfig, err := NewTlsConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if opts.ProxyUrl != "" {
+		proxyUrl, err := url.Parse(opts.ProxyUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy URL %v: %v", opts.ProxyUrl, err)
+		}
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	// Same settings as http.DefaultTransport, which may itself have been replaced by InstallDefaultTransport
+	var transport http.RoundTripper = &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	if opts.UserAgent != "" {
+		transport = &userAgentTransport{transport: transport, userAgent: opts.UserAgent}
+	}
+	return transport, nil
+}
+
+// NewHttpClient returns a client using NewHttpTransport and the request timeout
+func NewHttpClient(opts structs.HttpOptions) (*http.Client, error) {
+	transport, err := NewHttpTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport, Timeout: opts.Timeout}, nil
+}
+
+// NewTlsConfig trusts the system roots plus the PEM certificates in opts.CaBundle, and skips
+// verification only when opts.Insecure is set
+func NewTlsConfig(opts structs.HttpOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if opts.CaBundle == "" {
+		return tlsConfig, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	pemData, err := os.ReadFile(opts.CaBundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle %v: %v", opts.CaBundle, err)
+	}
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %v", opts.CaBundle)
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
+
+// InstallDefaultTransport replaces http.DefaultTransport for libraries that can't be handed a
+// client: the Azure DevOps SDK, and the token exchange inside the Phylum client. The Azure DevOps
+// SDK has no rate limiting of its own, so each organization is paced through a RateLimitRouter.
+func InstallDefaultTransport(opts structs.HttpOptions) error {
+	transport, err := NewHttpTransport(opts)
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = NewRateLimitRouter(transport)
+	return nil
+}
+
+// DefaultRateLimiter returns the limiter InstallDefaultTransport paces requests under prefix with,
+// or nil when it hasn't been installed
+func DefaultRateLimiter(prefix string) *AdaptiveRateLimitTransport {
+	router, ok := http.DefaultTransport.(*RateLimitRouter)
+	if !ok {
+		return nil
+	}
+	return router.Limiter(prefix)
+}
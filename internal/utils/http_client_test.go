@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+)
+
+func TestNewHttpClient_Proxy(t *testing.T) {
+	var gotHost, gotUserAgent string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxied request carries the absolute URL of the target
+		gotHost = r.URL.Host
+		gotUserAgent = r.Header.Get("User-Agent")
+		fmt.Fprint(w, `{}`)
+	}))
+	defer proxy.Close()
+
+	client, err := NewHttpClient(structs.HttpOptions{ProxyUrl: proxy.URL, UserAgent: "Syringe-test"})
+	if err != nil {
+		t.Fatalf("NewHttpClient() error = %v", err)
+	}
+	resp, err := client.Get("http://vcs.example.invalid/api")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if gotHost != "vcs.example.invalid" {
+		t.Errorf("proxy got host = %v, want vcs.example.invalid", gotHost)
+	}
+	if gotUserAgent != "Syringe-test" {
+		t.Errorf("proxy got User-Agent = %v, want Syringe-test", gotUserAgent)
+	}
+}
+
+func TestNewHttpClient_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caBundle, pemData, 0644); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+	notPem := filepath.Join(t.TempDir(), "not.pem")
+	if err := os.WriteFile(notPem, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		opts          structs.HttpOptions
+		wantClientErr bool
+		wantGetErr    bool
+	}{
+		{"system roots only", structs.HttpOptions{}, false, true},
+		{"ca bundle", structs.HttpOptions{CaBundle: caBundle}, false, false},
+		{"insecure", structs.HttpOptions{Insecure: true}, false, false},
+		{"missing ca bundle", structs.HttpOptions{CaBundle: filepath.Join(t.TempDir(), "missing.pem")}, true, false},
+		{"ca bundle without certificates", structs.HttpOptions{CaBundle: notPem}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewHttpClient(tt.opts)
+			if (err != nil) != tt.wantClientErr {
+				t.Fatalf("NewHttpClient() error = %v, wantErr %v", err, tt.wantClientErr)
+			}
+			if err != nil {
+				return
+			}
+			resp, err := client.Get(server.URL)
+			if (err != nil) != tt.wantGetErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantGetErr)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
+func TestNewHttpClient_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+
+	client, err := NewHttpClient(structs.HttpOptions{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewHttpClient() error = %v", err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Errorf("Get() error = nil, want timeout")
+	}
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultThrottleWait is how long to wait after a 429 that doesn't say when to retry
+const defaultThrottleWait = 60 * time.Second
+
+// maxThrottleWait caps a single wait, so a bogus reset time can't stall a run for hours
+const maxThrottleWait = 15 * time.Minute
+
+// nearLimitDelay spaces requests once Bitbucket says the quota is nearly used up
+const nearLimitDelay = time.Second
+
+// maxThrottleRetries is how many times one request is retried after being throttled
+const maxThrottleRetries = 5
+
+// slowdownFraction is the share of the quota left at which requests start being spread out
+// until the quota resets
+const slowdownFraction = 0.1
+
+// AdaptiveRateLimitTransport paces requests by the rate limit headers GitLab, Bitbucket and
+// Azure DevOps send: RateLimit-* (GitLab), X-RateLimit-* (Bitbucket, Azure DevOps) and
+// Retry-After. Once less than a tenth of the quota is left, requests are spread out until it
+// resets; when it runs out they wait for the reset. A 429, or a 503 with Retry-After, is retried
+// after the wait the server asks for.
+type AdaptiveRateLimitTransport struct {
+	transport http.RoundTripper
+
+	m         sync.Mutex
+	remaining int // -1 until a response reports it
+	limit     int
+	reset     time.Time // when the quota refills
+	notBefore time.Time // no request is sent before this, set by Retry-After
+	stats     structs.RateLimitStats
+
+	// time.Now and time.Sleep, replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewAdaptiveRateLimitTransport wraps rt, which defaults to http.DefaultTransport
+func NewAdaptiveRateLimitTransport(rt http.RoundTripper) *AdaptiveRateLimitTransport {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &AdaptiveRateLimitTransport{transport: rt, remaining: -1, now: time.Now, sleep: time.Sleep}
+}
+
+func (art *AdaptiveRateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if wait := art.nextDelay(); wait > 0 {
+			log.Debugf("Holding request to %v for %v to stay within its rate limit\n", req.URL.Host, wait)
+			art.wait(wait)
+		}
+
+		resp, err := art.transport.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		art.m.Lock()
+		art.stats.Requests++
+		art.m.Unlock()
+
+		retryAfter := art.update(resp)
+		if !IsThrottled(resp) {
+			return resp, nil
+		}
+
+		art.m.Lock()
+		art.stats.Throttled++
+		art.m.Unlock()
+		// A request with a body can only be resent if it can be rewound
+		if attempt >= maxThrottleRetries || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+			return resp, nil
+		}
+		if retryAfter <= 0 {
+			retryAfter = defaultThrottleWait
+		}
+		log.Warnf("Throttled by %v (%v), retrying in %v\n", req.URL.Host, resp.Status, retryAfter)
+		resp.Body.Close()
+		// Hold every request, not just this one, until the wait is over
+		art.m.Lock()
+		art.stats.Retries++
+		if until := art.now().Add(retryAfter); until.After(art.notBefore) {
+			art.notBefore = until
+		}
+		art.m.Unlock()
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// IsThrottled reports whether the server turned a request away to slow its client down: a 429, or a
+// 503 with Retry-After. AdaptiveRateLimitTransport retries these, so other retry layers shouldn't.
+func IsThrottled(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != "")
+}
+
+// nextDelay returns how long to hold the next request: until a Retry-After passes, until the reset
+// when the quota is gone, or the time left before the reset shared among the requests left when
+// the quota is running low
+func (art *AdaptiveRateLimitTransport) nextDelay() time.Duration {
+	art.m.Lock()
+	defer art.m.Unlock()
+
+	now := art.now()
+	if art.notBefore.After(now) {
+		return art.notBefore.Sub(now)
+	}
+	if art.remaining < 0 || art.reset.IsZero() || !art.reset.After(now) {
+		return 0
+	}
+	untilReset := art.reset.Sub(now)
+	if art.remaining == 0 {
+		return untilReset
+	}
+	if art.limit > 0 && float64(art.remaining) < float64(art.limit)*slowdownFraction {
+		// Spend what's left of the quota evenly until it refills. Counting this request keeps
+		// concurrent requests from all taking the same slot.
+		delay := untilReset / time.Duration(art.remaining+1)
+		art.remaining--
+		return delay
+	}
+	return 0
+}
+
+// update records the quota a response reports and returns the wait it asks for, if any
+func (art *AdaptiveRateLimitTransport) update(resp *http.Response) time.Duration {
+	now := art.now()
+	header := resp.Header
+
+	remaining, hasRemaining := headerInt(header, "RateLimit-Remaining", "X-RateLimit-Remaining")
+	limit, _ := headerInt(header, "RateLimit-Limit", "X-RateLimit-Limit")
+	reset, hasReset := headerInt(header, "RateLimit-Reset", "X-RateLimit-Reset")
+	retryAfter := parseRetryAfter(header.Get("Retry-After"), now)
+
+	art.m.Lock()
+	defer art.m.Unlock()
+	if hasRemaining {
+		art.remaining = remaining
+		art.limit = limit
+		art.reset = time.Time{}
+		if hasReset {
+			art.reset = resetTime(reset, now)
+		}
+	}
+	if retryAfter <= 0 && resp.StatusCode == http.StatusTooManyRequests && !art.reset.IsZero() {
+		retryAfter = art.reset.Sub(now)
+	}
+	if retryAfter > maxThrottleWait {
+		retryAfter = maxThrottleWait
+	}
+	// Azure DevOps sends Retry-After on successful responses once a user is being delayed
+	if retryAfter > 0 {
+		art.notBefore = now.Add(retryAfter)
+	} else if header.Get("X-RateLimit-NearLimit") == "true" && art.remaining < 0 {
+		// Bitbucket warns when less than a fifth of the quota is left, without saying how much
+		art.notBefore = now.Add(nearLimitDelay)
+	}
+	return retryAfter
+}
+
+// wait sleeps for d, counting it towards the time spent throttled
+func (art *AdaptiveRateLimitTransport) wait(d time.Duration) {
+	if d > maxThrottleWait {
+		d = maxThrottleWait
+	}
+	art.m.Lock()
+	art.stats.Waits++
+	art.stats.Waited += d
+	art.m.Unlock()
+	art.sleep(d)
+}
+
+// Stats returns the requests sent and the throttling seen so far
+func (art *AdaptiveRateLimitTransport) Stats() structs.RateLimitStats {
+	art.m.Lock()
+	defer art.m.Unlock()
+	return art.stats
+}
+
+// headerInt returns the first of names the header holds as an integer
+func headerInt(header http.Header, names ...string) (int, bool) {
+	for _, name := range names {
+		if value := header.Get(name); value != "" {
+			if n, err := strconv.Atoi(value); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// resetTime reads a reset header, which GitLab and Azure DevOps send as a unix time and some
+// proxies as seconds from now
+func resetTime(reset int, now time.Time) time.Time {
+	if reset > 1000000000 {
+		return time.Unix(int64(reset), 0)
+	}
+	return now.Add(time.Duration(reset) * time.Second)
+}
+
+// parseRetryAfter reads Retry-After as seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// RateLimitRouter paces the requests under each registered URL prefix with that prefix's own
+// AdaptiveRateLimitTransport and passes other requests straight through. It lets clients that share
+// http.DefaultTransport, like Azure DevOps organizations, be throttled and report apart.
+type RateLimitRouter struct {
+	transport http.RoundTripper
+
+	m        sync.RWMutex
+	limiters map[string]*AdaptiveRateLimitTransport // by lowercased URL prefix, without a trailing slash
+}
+
+// NewRateLimitRouter wraps rt, which defaults to http.DefaultTransport
+func NewRateLimitRouter(rt http.RoundTripper) *RateLimitRouter {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &RateLimitRouter{transport: rt, limiters: make(map[string]*AdaptiveRateLimitTransport, 0)}
+}
+
+// Limiter returns the limiter for requests under prefix, e.g. an Azure DevOps organization URL,
+// creating it on first use
+func (rlr *RateLimitRouter) Limiter(prefix string) *AdaptiveRateLimitTransport {
+	prefix = strings.TrimSuffix(strings.ToLower(prefix), "/")
+	rlr.m.Lock()
+	defer rlr.m.Unlock()
+	limiter, ok := rlr.limiters[prefix]
+	if !ok {
+		limiter = NewAdaptiveRateLimitTransport(rlr.transport)
+		rlr.limiters[prefix] = limiter
+	}
+	return limiter
+}
+
+func (rlr *RateLimitRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if limiter := rlr.limiterFor(req); limiter != nil {
+		return limiter.RoundTrip(req)
+	}
+	return rlr.transport.RoundTrip(req)
+}
+
+// limiterFor returns the limiter of the longest prefix req's URL falls under, or nil
+func (rlr *RateLimitRouter) limiterFor(req *http.Request) *AdaptiveRateLimitTransport {
+	reqUrl := strings.ToLower(req.URL.String())
+	rlr.m.RLock()
+	defer rlr.m.RUnlock()
+	var match *AdaptiveRateLimitTransport
+	matchLen := -1
+	for prefix, limiter := range rlr.limiters {
+		if (reqUrl == prefix || strings.HasPrefix(reqUrl, prefix+"/") || strings.HasPrefix(reqUrl, prefix+"?")) && len(prefix) > matchLen {
+			match, matchLen = limiter, len(prefix)
+		}
+	}
+	return match
+}
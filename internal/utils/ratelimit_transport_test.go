@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRateLimitTransport_RoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	inOneMinute := strconv.FormatInt(start.Add(time.Minute).Unix(), 10)
+
+	type response struct {
+		status int
+		header map[string]string
+	}
+	ok := response{http.StatusOK, nil}
+	tooMany := response{http.StatusTooManyRequests, nil}
+
+	tests := []struct {
+		name          string
+		responses     []response
+		requests      int
+		wantStatus    int
+		wantWaited    time.Duration
+		wantThrottled int
+		wantRetries   int
+	}{
+		{"not limited", []response{ok, ok}, 2, http.StatusOK, 0, 0, 0},
+		{"429 with Retry-After", []response{{http.StatusTooManyRequests, map[string]string{"Retry-After": "30"}}, ok},
+			1, http.StatusOK, 30 * time.Second, 1, 1},
+		{"429 with reset", []response{{http.StatusTooManyRequests, map[string]string{"RateLimit-Limit": "100", "RateLimit-Remaining": "0", "RateLimit-Reset": inOneMinute}}, ok},
+			1, http.StatusOK, time.Minute, 1, 1},
+		{"429 without headers", []response{tooMany, ok},
+			1, http.StatusOK, defaultThrottleWait, 1, 1},
+		{"503 with Retry-After", []response{{http.StatusServiceUnavailable, map[string]string{"Retry-After": "5"}}, ok},
+			1, http.StatusOK, 5 * time.Second, 1, 1},
+		{"503 without Retry-After", []response{{http.StatusServiceUnavailable, nil}},
+			1, http.StatusServiceUnavailable, 0, 0, 0},
+		{"retries exhausted", []response{tooMany, tooMany, tooMany, tooMany, tooMany, tooMany},
+			1, http.StatusTooManyRequests, maxThrottleRetries * defaultThrottleWait, maxThrottleRetries + 1, maxThrottleRetries},
+		// 5 of 100 left with a minute to go: the next request waits for its share of the minute
+		{"quota running low", []response{{http.StatusOK, map[string]string{"RateLimit-Limit": "100", "RateLimit-Remaining": "5", "RateLimit-Reset": inOneMinute}}, ok},
+			2, http.StatusOK, 10 * time.Second, 0, 0},
+		{"quota used up", []response{{http.StatusOK, map[string]string{"X-RateLimit-Limit": "100", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": inOneMinute}}, ok},
+			2, http.StatusOK, time.Minute, 0, 0},
+		{"quota plentiful", []response{{http.StatusOK, map[string]string{"X-RateLimit-Limit": "100", "X-RateLimit-Remaining": "50", "X-RateLimit-Reset": inOneMinute}}, ok},
+			2, http.StatusOK, 0, 0, 0},
+		// Azure DevOps delays a user with Retry-After on successful responses
+		{"Retry-After on success", []response{{http.StatusOK, map[string]string{"Retry-After": "3"}}, ok},
+			2, http.StatusOK, 3 * time.Second, 0, 0},
+		{"Bitbucket near limit", []response{{http.StatusOK, map[string]string{"X-RateLimit-NearLimit": "true"}}, ok},
+			2, http.StatusOK, nearLimitDelay, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				resp := tt.responses[calls]
+				calls++
+				for name, value := range resp.header {
+					w.Header().Set(name, value)
+				}
+				w.WriteHeader(resp.status)
+			}))
+			defer server.Close()
+
+			now := start
+			transport := NewAdaptiveRateLimitTransport(http.DefaultTransport)
+			transport.now = func() time.Time { return now }
+			transport.sleep = func(d time.Duration) { now = now.Add(d) }
+			client := &http.Client{Transport: transport}
+
+			var status int
+			for i := 0; i < tt.requests; i++ {
+				resp, err := client.Post(server.URL, "application/json", strings.NewReader(`{"n": 1}`))
+				if err != nil {
+					t.Fatalf("Post() error = %v", err)
+				}
+				resp.Body.Close()
+				status = resp.StatusCode
+			}
+
+			if status != tt.wantStatus {
+				t.Errorf("status = %v, want %v", status, tt.wantStatus)
+			}
+			if calls != len(tt.responses) {
+				t.Errorf("server got %v requests, want %v", calls, len(tt.responses))
+			}
+			for _, body := range bodies {
+				if body != `{"n": 1}` {
+					t.Errorf("server got body %q, want the request body on every attempt", body)
+				}
+			}
+			stats := transport.Stats()
+			if stats.Waited != tt.wantWaited || stats.Throttled != tt.wantThrottled || stats.Retries != tt.wantRetries || stats.Requests != calls {
+				t.Errorf("Stats() = %+v, want waited %v, throttled %v, retries %v", stats, tt.wantWaited, tt.wantThrottled, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestRateLimitRouter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy/_apis/git/repositories" && r.Header.Get("X-Retried") == "" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	router := NewRateLimitRouter(http.DefaultTransport)
+	busy := router.Limiter(server.URL + "/Busy/")
+	quiet := router.Limiter(server.URL + "/quiet")
+	if router.Limiter(server.URL+"/busy") != busy {
+		t.Errorf("Limiter() returned a second limiter for the same prefix")
+	}
+	now := time.Unix(1700000000, 0)
+	for _, limiter := range []*AdaptiveRateLimitTransport{busy, quiet} {
+		limiter.now = func() time.Time { return now }
+		limiter.sleep = func(d time.Duration) { now = now.Add(d) }
+	}
+	// The retry is marked so the server lets it through
+	busy.transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if busy.Stats().Throttled > 0 {
+			req.Header.Set("X-Retried", "1")
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	client := &http.Client{Transport: router}
+
+	for _, path := range []string{"/busy/_apis/git/repositories", "/quiet/_apis/git/repositories", "/busyness", "/_apis/connectionData"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Get(%v) error = %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Get(%v) status = %v", path, resp.StatusCode)
+		}
+	}
+
+	// Only the busy organization was held back, and requests outside both prefixes weren't paced
+	if stats := busy.Stats(); stats.Requests != 2 || stats.Throttled != 1 || stats.Waited != 30*time.Second {
+		t.Errorf("busy Stats() = %+v", stats)
+	}
+	if stats := quiet.Stats(); stats.Requests != 1 || stats.Waits != 0 {
+		t.Errorf("quiet Stats() = %+v", stats)
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -6,12 +6,16 @@ import (
 	"fmt"
 	"github.com/manifoldco/promptui"
 	"github.com/peterjmorgan/Syringe/internal/structs"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"reflect"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 )
 
@@ -39,13 +43,93 @@ func GetSupportedLockfiles() []string {
 	}
 }
 
-// CI Files to target
-//func GetGitlabCIFiles() []string {
-//	return []string{
-//		".gitlab-ci.yml",
-//		".gitlab-ci.yaml",
-//	}
-//}
+// CI Files to target, at the repo root
+func GetSupportedCiFiles() []string {
+	return []string{
+		".gitlab-ci.yml",
+		".gitlab-ci.yaml",
+		"azure-pipelines.yml",
+		"azure-pipelines.yaml",
+		"bitbucket-pipelines.yml",
+	}
+}
+
+// Directory of GitHub Actions workflows; every .yml/.yaml directly in it is a CI file
+const GithubWorkflowsDir = ".github/workflows"
+
+// IsLockfile reports whether the file at path is a supported lockfile
+func IsLockfile(path string) bool {
+	fileName := filepath.Base(path)
+	return slices.Contains(GetSupportedLockfiles(), fileName) || strings.HasSuffix(fileName, ".csproj")
+}
+
+// IsCiFile reports whether path, relative to the repo root, is a CI definition
+func IsCiFile(path string) bool {
+	path = strings.TrimPrefix(filepath.ToSlash(path), "/")
+	if slices.Contains(GetSupportedCiFiles(), path) {
+		return true
+	}
+	ext := filepath.Ext(path)
+	return filepath.ToSlash(filepath.Dir(path)) == GithubWorkflowsDir && (ext == ".yml" || ext == ".yaml")
+}
+
+// IsLockfileOrCiFile matches both, to collect lockfiles and CI files in one pass over a repo
+func IsLockfileOrCiFile(path string) bool {
+	return IsLockfile(path) || IsCiFile(path)
+}
+
+// Strings in a CI definition that show it runs Phylum: the phylum-ci package and image, the
+// phylum-dev GitHub actions and the phylum CLI itself
+func GetPhylumCiMarkers() []string {
+	return []string{
+		"phylum-ci",
+		"phylum-dev/",
+		"phylumio/",
+		"phylum analyze",
+	}
+}
+
+// CiRunsPhylum reports whether any of the CI files invoke Phylum
+func CiRunsPhylum(ciFiles []*structs.VcsFile) bool {
+	for _, ciFile := range ciFiles {
+		content := strings.ToLower(string(ciFile.Content))
+		for _, marker := range GetPhylumCiMarkers() {
+			if strings.Contains(content, marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TagRef qualifies a tag name so clients can tell it from a branch when it is passed as the
+// branch argument of GetFilesByProject
+func TagRef(tag string) string {
+	return "refs/tags/" + tag
+}
+
+// SplitRef returns the short name of a ref passed to a client and whether it is a tag. Branches
+// may be plain names or refs/heads/ refs, which is how Azure reports default branches.
+func SplitRef(ref string) (string, bool) {
+	if strings.HasPrefix(ref, "refs/tags/") {
+		return strings.TrimPrefix(ref, "refs/tags/"), true
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), false
+}
+
+// MatchRefs returns the names matching any of the glob patterns, in their original order
+func MatchRefs(names []string, patterns []string) []string {
+	var matched []string
+	for _, name := range names {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				matched = append(matched, name)
+				break
+			}
+		}
+	}
+	return matched
+}
 
 func ReadEnvVar(key string) (string, error) {
 	if value, ok := os.LookupEnv(key); ok {
@@ -151,6 +235,45 @@ func ReadEnvironment() (map[string]string, error) {
 	return envMap, nil
 }
 
+// ParseBool reads a config value such as "true", "yes" or "1"; anything else is false
+func ParseBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "y", "1":
+		return true
+	}
+	return false
+}
+
+// SplitList splits a comma-separated config value, dropping blanks
+func SplitList(value string) []string {
+	var retList []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			retList = append(retList, item)
+		}
+	}
+	return retList
+}
+
+// UrlHost returns the host (and port) of rawUrl, or rawUrl itself if it has none
+func UrlHost(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || parsed.Host == "" {
+		return rawUrl
+	}
+	return parsed.Host
+}
+
+// EscapePath escapes each segment of a slash-separated repo path, for building raw file URLs
+func EscapePath(filePath string) string {
+	segments := strings.Split(filePath, "/")
+	for idx, segment := range segments {
+		segments[idx] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
 func RemoveTempDir(tempDir string) {
 	err := os.RemoveAll(tempDir)
 	if err != nil {
@@ -158,7 +281,7 @@ func RemoveTempDir(tempDir string) {
 	}
 }
 
-func GeneratePhylumProjectName(projectName string, lockfilePath string, projectId int64) string {
+func GeneratePhylumProjectName(projectName string, lockfilePath string, projectId string) string {
 	return fmt.Sprintf("SYR-%v__%v", projectName, lockfilePath)
 }
 
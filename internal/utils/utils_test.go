@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/joho/godotenv"
+	"github.com/peterjmorgan/Syringe/internal/structs"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -52,3 +53,107 @@ func TestReadEnvironment(t *testing.T) {
 		})
 	}
 }
+
+func TestIsCiFile(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{".gitlab-ci.yml", true},
+		{"/azure-pipelines.yml", true},
+		{"bitbucket-pipelines.yml", true},
+		{".github/workflows/build.yml", true},
+		{".github/workflows/release.yaml", true},
+		{".github/workflows/scripts/setup.yml", false},
+		{".github/dependabot.yml", false},
+		{"services/api/.gitlab-ci.yml", false},
+		{"package-lock.json", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := IsCiFile(tt.path); got != tt.want {
+				t.Errorf("IsCiFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCiRunsPhylum(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"github action", "steps:\n  - uses: phylum-dev/phylum-analyze-pr-action@v2\n", true},
+		{"gitlab image", "analyze:\n  image: docker.io/phylumio/phylum-ci:latest\n", true},
+		{"cli", "script:\n  - phylum analyze package-lock.json\n", true},
+		{"no phylum", "script:\n  - npm test\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ciFiles := []*structs.VcsFile{{Name: ".gitlab-ci.yml", Path: ".gitlab-ci.yml", Content: []byte(tt.content)}}
+			if got := CiRunsPhylum(ciFiles); got != tt.want {
+				t.Errorf("CiRunsPhylum() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitRef(t *testing.T) {
+	tests := []struct {
+		ref       string
+		wantName  string
+		wantIsTag bool
+	}{
+		{"main", "main", false},
+		{"release/1.0", "release/1.0", false},
+		{"refs/heads/release/1.0", "release/1.0", false},
+		{TagRef("v1.0.0"), "v1.0.0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			gotName, gotIsTag := SplitRef(tt.ref)
+			if gotName != tt.wantName || gotIsTag != tt.wantIsTag {
+				t.Errorf("SplitRef() = %v, %v, want %v, %v", gotName, gotIsTag, tt.wantName, tt.wantIsTag)
+			}
+		})
+	}
+}
+
+func TestMatchRefs(t *testing.T) {
+	names := []string{"main", "release/1.0", "release/1.0/rc", "v1.2.0", "v2.0.0-beta"}
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"none", nil, nil},
+		{"branch glob", []string{"release/*"}, []string{"release/1.0"}},
+		{"several", []string{"main", "v1.*"}, []string{"main", "v1.2.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchRefs(names, tt.patterns); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MatchRefs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"package-lock.json", "package-lock.json"},
+		{"web/app/yarn.lock", "web/app/yarn.lock"},
+		{"my app/#1/100%/poetry.lock?", "my%20app/%231/100%25/poetry.lock%3F"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := EscapePath(tt.path); got != tt.want {
+				t.Errorf("EscapePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
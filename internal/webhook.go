@@ -0,0 +1,353 @@
+package syringePackage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+	"github.com/peterjmorgan/Syringe/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// WebhookPath is where serve takes each source's push webhooks, followed by the source name,
+// e.g. /webhooks/github
+const WebhookPath = "/webhooks/"
+
+// maxWebhookBody is the largest payload accepted; GitHub caps its payloads at 25MB
+const maxWebhookBody = 25 << 20
+
+// webhookQueueSize is how many rescans can wait before webhooks are turned away
+const webhookQueueSize = 1000
+
+// webhookRelistInterval is how often a source is listed again to find a pushed repo it didn't list
+// before, e.g. one created after serve started. Repos the source leaves out, like filtered forks,
+// are looked for at most this often.
+const webhookRelistInterval = time.Minute
+
+// errUnlistedRepo is the reason pushedProject gives for repos the source hasn't listed
+const errUnlistedRepo = "repo isn't among the listed projects"
+
+// WebhookServer receives push webhooks and rescans each pushed repo that may have changed a
+// lockfile on a ref Syringe scans. Rescans run one at a time from a queue, so webhooks are answered
+// right away; a repo already waiting isn't queued twice.
+type WebhookServer struct {
+	Syringe *Syringe
+	// Rescan rereads and analyzes one project; Syringe.RescanProject unless replaced, e.g. in tests
+	Rescan func(project *structs.SyringeProject) error
+	// RelistInterval is webhookRelistInterval unless replaced, e.g. in tests
+	RelistInterval time.Duration
+
+	queue  chan string
+	queued map[string]bool
+	mutex  sync.Mutex
+	// pushes to unlisted repos waiting for their source to be listed again, by source name, and
+	// when each source was last listed
+	relisting map[string][]*structs.PushEvent
+	relisted  map[string]time.Time
+}
+
+// NewWebhookServer serves webhooks for the projects s has listed, analyzing them into the Phylum
+// projects in phylumProjectMap, which gains the projects created for new lockfiles
+func NewWebhookServer(s *Syringe, phylumProjectMap *map[string]structs.PhylumProject) *WebhookServer {
+	return &WebhookServer{
+		Syringe: s,
+		Rescan: func(project *structs.SyringeProject) error {
+			return s.RescanProject(project, phylumProjectMap)
+		},
+		RelistInterval: webhookRelistInterval,
+		queue:          make(chan string, webhookQueueSize),
+		queued:         make(map[string]bool, 0),
+		relisting:      make(map[string][]*structs.PushEvent, 0),
+		relisted:       make(map[string]time.Time, 0),
+	}
+}
+
+func (w *WebhookServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/healthz" {
+		fmt.Fprintln(rw, "ok")
+		return
+	}
+	sourceName := strings.TrimPrefix(req.URL.Path, WebhookPath)
+	source := w.source(sourceName)
+	if !strings.HasPrefix(req.URL.Path, WebhookPath) || source == nil || source.WebhookSecret == "" {
+		http.NotFound(rw, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(rw, req.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(rw, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	event, err := ParseWebhook(source.VcsType, source.WebhookSecret, req.Header, body)
+	if errors.Is(err, ErrWebhookUnauthorized) {
+		log.Warnf("Rejected webhook for source %v from %v: %v\n", sourceName, req.RemoteAddr, err)
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Warnf("Bad webhook for source %v: %v\n", sourceName, err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if event == nil {
+		fmt.Fprintln(rw, "ignored: not a push")
+		return
+	}
+
+	project, reason := w.pushedProject(source, event)
+	if project == nil && reason == errUnlistedRepo && w.relist(source, event) {
+		log.Infof("Listing source %v again to find pushed repo %v\n", sourceName, event.RepoId)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "queued: listing %v again\n", sourceName)
+		return
+	}
+	if project == nil {
+		log.Debugf("Ignoring push to %v from source %v: %v\n", event.RepoId, sourceName, reason)
+		fmt.Fprintf(rw, "ignored: %v\n", reason)
+		return
+	}
+	if !w.enqueue(project.Id) {
+		log.Errorf("Rescan queue is full, dropping push to %v\n", project.Name)
+		http.Error(rw, "rescan queue is full", http.StatusServiceUnavailable)
+		return
+	}
+	log.Infof("Queued rescan of %v after a push\n", project.Name)
+	rw.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(rw, "queued: %v\n", project.Name)
+}
+
+func (w *WebhookServer) source(name string) *Source {
+	for _, source := range w.Syringe.Sources {
+		if source.Name == name {
+			return source
+		}
+	}
+	return nil
+}
+
+// pushedProject returns the listed project a push event is for if it needs rescanning, or why not
+func (w *WebhookServer) pushedProject(source *Source, event *structs.PushEvent) (*structs.SyringeProject, string) {
+	s := w.Syringe
+	var project *structs.SyringeProject
+	s.ProjectsMapMutex.RLock()
+	for _, candidate := range s.ProjectsMap {
+		if candidate.Source != source.Name && !(candidate.Source == "" && len(s.Sources) == 1) {
+			continue
+		}
+		if _, _, _, id, err := structs.ParseProjectKey(candidate.Id); err == nil && id == event.RepoId {
+			project = candidate
+			break
+		}
+	}
+	s.ProjectsMapMutex.RUnlock()
+	if project == nil {
+		return nil, errUnlistedRepo
+	}
+
+	if !w.scansRef(project, event.Refs) {
+		return nil, "no scanned branch or tag was pushed"
+	}
+	if event.PathsKnown {
+		touched := false
+		for _, path := range event.Paths {
+			if utils.IsLockfile(path) {
+				touched = true
+				break
+			}
+		}
+		if !touched {
+			return nil, "no lockfile changed"
+		}
+	}
+	return project, ""
+}
+
+// scansRef reports whether any of refs is the project's default branch or matches the configured
+// branch and tag patterns
+func (w *WebhookServer) scansRef(project *structs.SyringeProject, refs []string) bool {
+	// Azure DevOps lists default branches as full refs
+	defaultBranch, _ := utils.SplitRef(project.Branch)
+	for _, ref := range refs {
+		if strings.HasPrefix(ref, "refs/heads/") {
+			branch := strings.TrimPrefix(ref, "refs/heads/")
+			if branch == defaultBranch || len(utils.MatchRefs([]string{branch}, w.Syringe.Refs.Branches)) > 0 {
+				return true
+			}
+		} else if strings.HasPrefix(ref, "refs/tags/") {
+			tag := strings.TrimPrefix(ref, "refs/tags/")
+			if len(utils.MatchRefs([]string{tag}, w.Syringe.Refs.Tags)) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enqueue queues a rescan of the project unless one is already waiting, reporting false when the
+// queue is full
+func (w *WebhookServer) enqueue(projectId string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.queued[projectId] {
+		return true
+	}
+	select {
+	case w.queue <- projectId:
+		w.queued[projectId] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// relist lists source again in the background to look for the repo event was pushed to, then acts
+// on the push if the repo turned up. It reports false when the source was listed too recently to
+// be listed again. Pushes arriving while a listing runs wait for it.
+func (w *WebhookServer) relist(source *Source, event *structs.PushEvent) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if waiting, ok := w.relisting[source.Name]; ok {
+		w.relisting[source.Name] = append(waiting, event)
+		return true
+	}
+	if time.Since(w.relisted[source.Name]) < w.RelistInterval {
+		return false
+	}
+	w.relisting[source.Name] = []*structs.PushEvent{event}
+	w.relisted[source.Name] = time.Now()
+
+	go func() {
+		if err := w.Syringe.ListSourceProjects(source); err != nil {
+			log.Errorf("Failed to list projects from %v again: %v\n", source.Name, err)
+		}
+		w.mutex.Lock()
+		waiting := w.relisting[source.Name]
+		delete(w.relisting, source.Name)
+		w.mutex.Unlock()
+
+		for _, event := range waiting {
+			project, reason := w.pushedProject(source, event)
+			if project == nil {
+				log.Debugf("Ignoring push to %v from source %v: %v\n", event.RepoId, source.Name, reason)
+				continue
+			}
+			if !w.enqueue(project.Id) {
+				log.Errorf("Rescan queue is full, dropping push to %v\n", project.Name)
+				continue
+			}
+			log.Infof("Queued rescan of new repo %v after a push\n", project.Name)
+		}
+	}()
+	return true
+}
+
+// Run rescans queued projects until ctx is done
+func (w *WebhookServer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case projectId := <-w.queue:
+			// A push while the rescan runs queues another one
+			w.mutex.Lock()
+			delete(w.queued, projectId)
+			w.mutex.Unlock()
+
+			w.Syringe.ProjectsMapMutex.RLock()
+			project := w.Syringe.ProjectsMap[projectId]
+			w.Syringe.ProjectsMapMutex.RUnlock()
+			if err := w.Rescan(project); err != nil {
+				log.Errorf("Failed to rescan %v: %v\n", project.Name, err)
+				continue
+			}
+			log.Infof("Rescanned %v\n", project.Name)
+		}
+	}
+}
+
+// ListSourceProjects lists one source again and adds the projects not listed before, such as
+// repos created since ListProjects ran
+func (s *Syringe) ListSourceProjects(source *Source) error {
+	syringeProjects, err := source.Client.ListProjects()
+	if err != nil {
+		return err
+	}
+
+	s.ProjectsMapMutex.Lock()
+	defer s.ProjectsMapMutex.Unlock()
+	added := 0
+	for _, project := range *syringeProjects {
+		if _, ok := s.ProjectsMap[project.Id]; ok {
+			continue
+		}
+		project.Source = source.Name
+		s.ProjectsMap[project.Id] = project
+		if s.Projects != nil {
+			*s.Projects = append(*s.Projects, project)
+		}
+		added++
+	}
+	log.Debugf("Listed %v new projects from %v\n", added, source.Name)
+	return nil
+}
+
+// RescanProject rereads a project's lockfiles and analyzes each one that changed since it was last
+// analyzed, creating Phylum projects for new lockfiles. The scan state, when enabled, is updated
+// and saved.
+func (s *Syringe) RescanProject(project *structs.SyringeProject, phylumProjectMap *map[string]structs.PhylumProject) error {
+	s.ProjectsMapMutex.Lock()
+	project.Hydrated = false
+	project.Unchanged = false
+	project.Lockfiles = nil
+	project.CiFiles = nil
+	s.ProjectsMapMutex.Unlock()
+
+	if _, err := s.GetLockfilesByProject(project.Id); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, lockfile := range project.Lockfiles {
+		if s.LockfileUnchanged(project, lockfile) {
+			log.Debugf("Skipping %v from %v, unchanged since the last scan\n", lockfile.Path, project.Name)
+			continue
+		}
+		phylumProjectName := s.phylumProjectName(project, lockfile)
+		phylumProject, ok := (*phylumProjectMap)[phylumProjectName]
+		if !ok {
+			created := make(chan *structs.PhylumProject, 1)
+			if err := s.PhylumCreateProject(phylumProjectName, created); err != nil {
+				log.Errorf("Failed to create phylum project %v: %v\n", phylumProjectName, err)
+				failed++
+				continue
+			}
+			phylumProject = *<-created
+			(*phylumProjectMap)[phylumProjectName] = phylumProject
+		}
+		lockfile.PhylumProject = &phylumProject
+
+		if err := s.PhylumRunAnalyze(phylumProject, lockfile, phylumProjectName); err != nil {
+			log.Errorf("Failed to analyze %v: %v\n", phylumProjectName, err)
+			failed++
+			continue
+		}
+		s.RecordAnalyzed(project, lockfile)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v lockfiles failed", failed, len(project.Lockfiles))
+	}
+
+	s.RecordHead(project)
+	return s.SaveState()
+}
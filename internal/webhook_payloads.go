@@ -0,0 +1,256 @@
+package syringePackage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/peterjmorgan/Syringe/internal/structs"
+)
+
+// ErrWebhookUnauthorized is returned for webhooks whose signature or secret token doesn't match
+var ErrWebhookUnauthorized = errors.New("webhook signature or token doesn't match the source's webhookSecret")
+
+// ErrWebhookUnsupported is returned for VCS types serve can't take webhooks from
+var ErrWebhookUnsupported = errors.New("push webhooks are not supported for this VCS")
+
+// zeroCommit is the object ID VCSes report for the missing side of a created or deleted ref
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+// githubCommitLimit is the most commits GitHub lists in a push payload
+const githubCommitLimit = 2048
+
+// ParseWebhook verifies a webhook from a source of vcsType against secret and reduces it to a
+// PushEvent. Events other than pushes, like pings, return nil without an error.
+func ParseWebhook(vcsType string, secret string, header http.Header, body []byte) (*structs.PushEvent, error) {
+	switch vcsType {
+	case "github":
+		if !validHmac(secret, body, header.Get("X-Hub-Signature-256"), "sha256=") {
+			return nil, ErrWebhookUnauthorized
+		}
+		if header.Get("X-GitHub-Event") != "push" {
+			return nil, nil
+		}
+		return parseGithubPush(body)
+	case "gitea":
+		// Gitea's push payload is GitHub's, signed without a prefix
+		if !validHmac(secret, body, header.Get("X-Gitea-Signature"), "") {
+			return nil, ErrWebhookUnauthorized
+		}
+		if header.Get("X-Gitea-Event") != "push" {
+			return nil, nil
+		}
+		return parseGithubPush(body)
+	case "gitlab":
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return nil, ErrWebhookUnauthorized
+		}
+		if event := header.Get("X-Gitlab-Event"); event != "Push Hook" && event != "Tag Push Hook" {
+			return nil, nil
+		}
+		return parseGitlabPush(body)
+	case "bitbucket_cloud":
+		if !validHmac(secret, body, header.Get("X-Hub-Signature"), "sha256=") {
+			return nil, ErrWebhookUnauthorized
+		}
+		if header.Get("X-Event-Key") != "repo:push" {
+			return nil, nil
+		}
+		return parseBitbucketCloudPush(body)
+	case "bitbucket_server":
+		if !validHmac(secret, body, header.Get("X-Hub-Signature"), "sha256=") {
+			return nil, ErrWebhookUnauthorized
+		}
+		if header.Get("X-Event-Key") != "repo:refs_changed" {
+			return nil, nil
+		}
+		return parseBitbucketServerPush(body)
+	case "azure":
+		// Service hooks can only send basic auth; the password is the secret
+		_, password, ok := (&http.Request{Header: header}).BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(secret)) != 1 {
+			return nil, ErrWebhookUnauthorized
+		}
+		return parseAzurePush(body)
+	default:
+		return nil, ErrWebhookUnsupported
+	}
+}
+
+// validHmac checks a hex HMAC-SHA256 signature of body, e.g. sha256=<hex> for GitHub
+func validHmac(secret string, body []byte, signature string, prefix string) bool {
+	if secret == "" || !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+func parseGithubPush(body []byte) (*structs.PushEvent, error) {
+	var payload struct {
+		Ref        string `json:"ref"`
+		Before     string `json:"before"`
+		Created    bool   `json:"created"`
+		Deleted    bool   `json:"deleted"`
+		Repository struct {
+			Id int64 `json:"id"`
+		} `json:"repository"`
+		Commits []struct {
+			Added    []string `json:"added"`
+			Modified []string `json:"modified"`
+		} `json:"commits"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %v", err)
+	}
+
+	event := &structs.PushEvent{
+		RepoId:     strconv.FormatInt(payload.Repository.Id, 10),
+		PathsKnown: !payload.Created && payload.Before != zeroCommit && len(payload.Commits) < githubCommitLimit,
+	}
+	if !payload.Deleted {
+		event.Refs = []string{payload.Ref}
+	}
+	for _, commit := range payload.Commits {
+		event.Paths = append(event.Paths, commit.Added...)
+		event.Paths = append(event.Paths, commit.Modified...)
+	}
+	return event, nil
+}
+
+func parseGitlabPush(body []byte) (*structs.PushEvent, error) {
+	var payload struct {
+		Ref               string `json:"ref"`
+		Before            string `json:"before"`
+		After             string `json:"after"`
+		ProjectId         int    `json:"project_id"`
+		TotalCommitsCount int    `json:"total_commits_count"`
+		Commits           []struct {
+			Added    []string `json:"added"`
+			Modified []string `json:"modified"`
+		} `json:"commits"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %v", err)
+	}
+
+	// GitLab lists at most 20 commits, but counts them all
+	event := &structs.PushEvent{
+		RepoId:     strconv.Itoa(payload.ProjectId),
+		PathsKnown: payload.Before != zeroCommit && payload.TotalCommitsCount <= len(payload.Commits),
+	}
+	if payload.After != zeroCommit {
+		event.Refs = []string{payload.Ref}
+	}
+	for _, commit := range payload.Commits {
+		event.Paths = append(event.Paths, commit.Added...)
+		event.Paths = append(event.Paths, commit.Modified...)
+	}
+	return event, nil
+}
+
+// parseBitbucketCloudPush reads the refs a push changed. Bitbucket doesn't list changed files.
+func parseBitbucketCloudPush(body []byte) (*structs.PushEvent, error) {
+	var payload struct {
+		Repository struct {
+			Uuid string `json:"uuid"`
+		} `json:"repository"`
+		Push struct {
+			Changes []struct {
+				New *struct {
+					Type string `json:"type"`
+					Name string `json:"name"`
+				} `json:"new"`
+			} `json:"changes"`
+		} `json:"push"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %v", err)
+	}
+	// Project keys hold the UUID without braces
+	repoUuid, err := uuid.Parse(payload.Repository.Uuid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository uuid %v: %v", payload.Repository.Uuid, err)
+	}
+
+	event := &structs.PushEvent{RepoId: repoUuid.String()}
+	for _, change := range payload.Push.Changes {
+		if change.New == nil {
+			continue
+		}
+		switch change.New.Type {
+		case "branch", "named_branch":
+			event.Refs = append(event.Refs, "refs/heads/"+change.New.Name)
+		case "tag", "annotated_tag":
+			event.Refs = append(event.Refs, "refs/tags/"+change.New.Name)
+		}
+	}
+	return event, nil
+}
+
+// parseBitbucketServerPush reads the refs a push changed. Bitbucket doesn't list changed files.
+func parseBitbucketServerPush(body []byte) (*structs.PushEvent, error) {
+	var payload struct {
+		Repository struct {
+			Id int64 `json:"id"`
+		} `json:"repository"`
+		Changes []struct {
+			RefId string `json:"refId"`
+			Type  string `json:"type"`
+		} `json:"changes"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %v", err)
+	}
+
+	event := &structs.PushEvent{RepoId: strconv.FormatInt(payload.Repository.Id, 10)}
+	for _, change := range payload.Changes {
+		if change.Type != "DELETE" {
+			event.Refs = append(event.Refs, change.RefId)
+		}
+	}
+	return event, nil
+}
+
+// parseAzurePush reads the refs a git.push service hook changed. Azure doesn't list changed files.
+func parseAzurePush(body []byte) (*structs.PushEvent, error) {
+	var payload struct {
+		EventType string `json:"eventType"`
+		Resource  struct {
+			Repository struct {
+				Id string `json:"id"`
+			} `json:"repository"`
+			RefUpdates []struct {
+				Name        string `json:"name"`
+				NewObjectId string `json:"newObjectId"`
+			} `json:"refUpdates"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %v", err)
+	}
+	if payload.EventType != "git.push" {
+		return nil, nil
+	}
+
+	event := &structs.PushEvent{RepoId: strings.ToLower(payload.Resource.Repository.Id)}
+	for _, refUpdate := range payload.Resource.RefUpdates {
+		if refUpdate.NewObjectId != zeroCommit {
+			event.Refs = append(event.Refs, refUpdate.Name)
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,287 @@
+package syringePackage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peterjmorgan/Syringe/internal/structs"
+)
+
+const testWebhookSecret = "s3cret"
+
+// Recorded push payloads, trimmed to the fields Syringe reads
+const (
+	githubPushPayload = `{"ref": "refs/heads/main", "before": "c0ffee", "created": false, "deleted": false,
+		"repository": {"id": 1, "full_name": "acme/api"},
+		"commits": [{"added": ["web/package-lock.json"], "modified": ["README.md"], "removed": []}]}`
+	githubReadmePayload = `{"ref": "refs/heads/main", "before": "c0ffee",
+		"repository": {"id": 1}, "commits": [{"added": [], "modified": ["README.md"], "removed": ["yarn.lock"]}]}`
+	gitlabPushPayload = `{"object_kind": "push", "ref": "refs/heads/main", "before": "c0ffee", "after": "fea7", "project_id": 4,
+		"total_commits_count": 21, "commits": [{"added": [], "modified": ["README.md"], "removed": []}]}`
+	bitbucketCloudPushPayload = `{"repository": {"uuid": "{1a2b3c4d-0000-4000-8000-000000000001}"},
+		"push": {"changes": [{"new": {"type": "branch", "name": "main"}}, {"new": {"type": "tag", "name": "v1.0"}}, {"new": null}]}}`
+	bitbucketServerPushPayload = `{"eventKey": "repo:refs_changed", "repository": {"id": 9},
+		"changes": [{"refId": "refs/heads/main", "type": "UPDATE"}, {"refId": "refs/heads/old", "type": "DELETE"}]}`
+	azurePushPayload = `{"eventType": "git.push", "resource": {"repository": {"id": "7A1F0C1E-0000-4000-8000-000000000002"},
+		"refUpdates": [{"name": "refs/heads/main", "newObjectId": "fea7"}]}}`
+)
+
+func githubSignature(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		vcsType string
+		header  map[string]string
+		body    string
+		want    *structs.PushEvent
+		wantErr error
+	}{
+		{"github", "github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": githubSignature(testWebhookSecret, githubPushPayload)}, githubPushPayload,
+			&structs.PushEvent{RepoId: "1", Refs: []string{"refs/heads/main"}, Paths: []string{"web/package-lock.json", "README.md"}, PathsKnown: true}, nil},
+		{"github bad signature", "github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": githubSignature("wrong", githubPushPayload)}, githubPushPayload,
+			nil, ErrWebhookUnauthorized},
+		{"github unsigned", "github", map[string]string{"X-GitHub-Event": "push"}, githubPushPayload,
+			nil, ErrWebhookUnauthorized},
+		{"github ping", "github", map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": githubSignature(testWebhookSecret, `{"zen": "hi"}`)}, `{"zen": "hi"}`,
+			nil, nil},
+		{"gitea", "gitea", map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": strings.TrimPrefix(githubSignature(testWebhookSecret, githubReadmePayload), "sha256=")}, githubReadmePayload,
+			&structs.PushEvent{RepoId: "1", Refs: []string{"refs/heads/main"}, Paths: []string{"README.md"}, PathsKnown: true}, nil},
+		{"gitlab truncated commits", "gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": testWebhookSecret}, gitlabPushPayload,
+			&structs.PushEvent{RepoId: "4", Refs: []string{"refs/heads/main"}, Paths: []string{"README.md"}, PathsKnown: false}, nil},
+		{"gitlab bad token", "gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}, gitlabPushPayload,
+			nil, ErrWebhookUnauthorized},
+		{"bitbucket cloud", "bitbucket_cloud", map[string]string{"X-Event-Key": "repo:push", "X-Hub-Signature": githubSignature(testWebhookSecret, bitbucketCloudPushPayload)}, bitbucketCloudPushPayload,
+			&structs.PushEvent{RepoId: "1a2b3c4d-0000-4000-8000-000000000001", Refs: []string{"refs/heads/main", "refs/tags/v1.0"}}, nil},
+		{"bitbucket server", "bitbucket_server", map[string]string{"X-Event-Key": "repo:refs_changed", "X-Hub-Signature": githubSignature(testWebhookSecret, bitbucketServerPushPayload)}, bitbucketServerPushPayload,
+			&structs.PushEvent{RepoId: "9", Refs: []string{"refs/heads/main"}}, nil},
+		{"azure", "azure", map[string]string{"Authorization": "Basic " + basicAuth("syringe", testWebhookSecret)}, azurePushPayload,
+			&structs.PushEvent{RepoId: "7a1f0c1e-0000-4000-8000-000000000002", Refs: []string{"refs/heads/main"}}, nil},
+		{"azure bad password", "azure", map[string]string{"Authorization": "Basic " + basicAuth("syringe", "wrong")}, azurePushPayload,
+			nil, ErrWebhookUnauthorized},
+		{"local", "local", nil, `{}`, nil, ErrWebhookUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for name, value := range tt.header {
+				header.Set(name, value)
+			}
+			got, err := ParseWebhook(tt.vcsType, testWebhookSecret, header, []byte(tt.body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseWebhook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWebhook() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeAzureClient lists a repo whose default branch is a full ref, as Azure DevOps reports it
+type fakeAzureClient struct {
+	fakeClient
+}
+
+func (f *fakeAzureClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	return &[]*structs.SyringeProject{{
+		Id:     structs.NewProjectKey("azure", "dev.azure.com", "acme", "7a1f0c1e-0000-4000-8000-000000000002"),
+		Name:   "acme/web",
+		Branch: "refs/heads/main",
+	}}, nil
+}
+
+func basicAuth(username string, password string) string {
+	req := &http.Request{Header: http.Header{}}
+	req.SetBasicAuth(username, password)
+	return strings.TrimPrefix(req.Header.Get("Authorization"), "Basic ")
+}
+
+func TestWebhookServer(t *testing.T) {
+	s := &Syringe{
+		Sources: []*Source{
+			{Name: "github", Client: &fakeRefClient{}, VcsType: "github", WebhookSecret: testWebhookSecret},
+			{Name: "azure", Client: &fakeAzureClient{}, VcsType: "azure", WebhookSecret: testWebhookSecret},
+		},
+		ProjectsMap: make(map[string]*structs.SyringeProject, 0),
+		Refs:        structs.RefOptions{Branches: []string{"release/*"}},
+	}
+	if err := s.ListProjects(); err != nil {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+
+	rescanned := make(chan string, 10)
+	webhookServer := NewWebhookServer(s, &map[string]structs.PhylumProject{})
+	webhookServer.Rescan = func(project *structs.SyringeProject) error {
+		rescanned <- project.Name
+		return nil
+	}
+	server := httptest.NewServer(webhookServer)
+	defer server.Close()
+
+	post := func(path string, body string, signature string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", signature)
+		// Azure DevOps authenticates with basic auth instead of a signature
+		req.Header.Set("Authorization", "Basic "+basicAuth("syringe", testWebhookSecret))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST %v error = %v", path, err)
+		}
+		defer resp.Body.Close()
+		reply, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(reply)
+	}
+	signed := func(body string) string { return githubSignature(testWebhookSecret, body) }
+	otherBranch := strings.Replace(githubPushPayload, "refs/heads/main", "refs/heads/feature/x", 1)
+	releaseBranch := strings.Replace(githubPushPayload, "refs/heads/main", "refs/heads/release/1.0", 1)
+	otherRepo := strings.Replace(githubPushPayload, `"id": 1`, `"id": 2`, 1)
+	azureFeature := strings.Replace(azurePushPayload, "refs/heads/main", "refs/heads/feature/x", 1)
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		signature  string
+		wantStatus int
+		wantReply  string
+	}{
+		{"lockfile pushed", "/webhooks/github", githubPushPayload, signed(githubPushPayload), http.StatusAccepted, "queued: api"},
+		{"already queued", "/webhooks/github", githubPushPayload, signed(githubPushPayload), http.StatusAccepted, "queued: api"},
+		{"no lockfile changed", "/webhooks/github", githubReadmePayload, signed(githubReadmePayload), http.StatusOK, "ignored: no lockfile changed"},
+		{"other branch", "/webhooks/github", otherBranch, signed(otherBranch), http.StatusOK, "ignored: no scanned branch or tag was pushed"},
+		{"release branch", "/webhooks/github", releaseBranch, signed(releaseBranch), http.StatusAccepted, "queued: api"},
+		{"unknown repo", "/webhooks/github", otherRepo, signed(otherRepo), http.StatusAccepted, "queued: listing github again"},
+		{"azure default branch", "/webhooks/azure", azurePushPayload, "", http.StatusAccepted, "queued: acme/web"},
+		{"azure other branch", "/webhooks/azure", azureFeature, "", http.StatusOK, "ignored: no scanned branch or tag was pushed"},
+		{"bad signature", "/webhooks/github", githubPushPayload, githubSignature("wrong", githubPushPayload), http.StatusUnauthorized, ""},
+		{"unknown source", "/webhooks/gitlab", githubPushPayload, signed(githubPushPayload), http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reply := post(tt.path, tt.body, tt.signature)
+			if status != tt.wantStatus || !strings.HasPrefix(reply, tt.wantReply) {
+				t.Errorf("POST %v got %v %q, want %v %q", tt.path, status, reply, tt.wantStatus, tt.wantReply)
+			}
+		})
+	}
+
+	// The three accepted pushes for the GitHub repo rescan it once, alongside the Azure repo
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhookServer.Run(ctx)
+	var names []string
+	for len(names) < 2 {
+		select {
+		case name := <-rescanned:
+			names = append(names, name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("rescanned %v after the pushes, want api and acme/web", names)
+		}
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"acme/web", "api"}) {
+		t.Errorf("rescanned %v, want api and acme/web", names)
+	}
+	select {
+	case name := <-rescanned:
+		t.Errorf("rescanned %v twice", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// fakeGrowingClient lists the repos created so far
+type fakeGrowingClient struct {
+	ids   []string
+	mutex sync.Mutex
+}
+
+func (f *fakeGrowingClient) create(id string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.ids = append(f.ids, id)
+}
+
+func (f *fakeGrowingClient) ListProjects() (*[]*structs.SyringeProject, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var projects []*structs.SyringeProject
+	for _, id := range f.ids {
+		projects = append(projects, &structs.SyringeProject{Id: structs.NewProjectKey("github", "github.com", "acme", id), Name: "repo" + id, Branch: "main"})
+	}
+	return &projects, nil
+}
+
+func (f *fakeGrowingClient) GetLockfilesByProject(projectId string, branch string) ([]*structs.VcsFile, error) {
+	return nil, nil
+}
+
+func TestWebhookServer_NewRepo(t *testing.T) {
+	client := &fakeGrowingClient{ids: []string{"1"}}
+	s := &Syringe{
+		Sources:     []*Source{{Name: "github", Client: client, VcsType: "github", WebhookSecret: testWebhookSecret}},
+		ProjectsMap: make(map[string]*structs.SyringeProject, 0),
+	}
+	if err := s.ListProjects(); err != nil {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+
+	rescanned := make(chan string, 10)
+	webhookServer := NewWebhookServer(s, &map[string]structs.PhylumProject{})
+	webhookServer.Rescan = func(project *structs.SyringeProject) error {
+		rescanned <- project.Name
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhookServer.Run(ctx)
+
+	push := func(repoId string) (int, string) {
+		body := strings.Replace(githubPushPayload, `"id": 1`, `"id": `+repoId, 1)
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", githubSignature(testWebhookSecret, body))
+		rw := httptest.NewRecorder()
+		webhookServer.ServeHTTP(rw, req)
+		return rw.Code, rw.Body.String()
+	}
+
+	// A repo created after serve started is found by listing its source again
+	client.create("2")
+	if status, reply := push("2"); status != http.StatusAccepted || !strings.HasPrefix(reply, "queued: listing github again") {
+		t.Errorf("push to new repo got %v %q", status, reply)
+	}
+	select {
+	case name := <-rescanned:
+		if name != "repo2" {
+			t.Errorf("rescanned %v, want repo2", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no rescan after a push to a new repo")
+	}
+	if len(*s.Projects) != 2 {
+		t.Errorf("projects after listing again = %v, want 2", len(*s.Projects))
+	}
+
+	// The source was just listed, so a repo it doesn't list is ignored
+	if status, reply := push("3"); status != http.StatusOK || !strings.HasPrefix(reply, "ignored: repo isn't among the listed projects") {
+		t.Errorf("push to unlisted repo got %v %q", status, reply)
+	}
+}